@@ -0,0 +1,304 @@
+package tinygit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// AttributesFileName is the name of the per directory attributes files.
+const AttributesFileName = ".gitattributes"
+
+func infoAttributesFile() string {
+	return filepath.Join(commonDir(), "info", "attributes")
+}
+
+// Values of the attributes which are set or unset rather than given a value,
+// an unspecified attribute has no value at all.
+const (
+	AttributeSet         = "set"
+	AttributeUnset       = "unset"
+	AttributeUnspecified = "unspecified"
+)
+
+// builtinMacros are the attribute macros known without being defined.
+var builtinMacros = map[string][]string{
+	"binary": {"-diff", "-merge", "-text"},
+}
+
+// attributeLine is a line of an attributes file, the pattern selects the
+// paths the attribute states apply to. A state is "name" to set, "-name" to
+// unset, "!name" to make unspecified or "name=value".
+type attributeLine struct {
+	pattern *ignorePattern
+	states  []string
+}
+
+// attributesFile holds the lines of an attributes file and the macros it
+// defines with "[attr]<name> <states>".
+type attributesFile struct {
+	lines  []attributeLine
+	macros map[string][]string
+}
+
+// readAttributesFile read the attributes file whose patterns are relative
+// to base, an absent file has no lines. Macros are only allowed when
+// allowMacros is set.
+func readAttributesFile(file, base, source string, allowMacros bool) (*attributesFile, error) {
+	attrs := &attributesFile{macros: make(map[string][]string)}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return attrs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read attributes file: %w", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if strings.HasPrefix(fields[0], "[attr]") {
+			name := strings.TrimPrefix(fields[0], "[attr]")
+			if !allowMacros {
+				return nil, fmt.Errorf("%s:%d: [attr]%s not allowed", source, line, name)
+			}
+			attrs.macros[name] = fields[1:]
+			continue
+		}
+		// negated patterns have no meaning for attributes
+		if strings.HasPrefix(fields[0], "!") {
+			continue
+		}
+		p, err := parseIgnorePattern(fields[0], base, source, line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", source, line, err)
+		}
+		if p != nil {
+			attrs.lines = append(attrs.lines, attributeLine{pattern: p, states: fields[1:]})
+		}
+	}
+	return attrs, scanner.Err()
+}
+
+// globalAttributesFile return the file named by core.attributesFile, by
+// default $XDG_CONFIG_HOME/tinygit/attributes or ~/.config/tinygit/attributes.
+func globalAttributesFile(config *Config) string {
+	if file, ok := config.Get("core.attributesFile"); ok {
+		if strings.HasPrefix(file, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				file = filepath.Join(home, file[2:])
+			}
+		}
+		return file
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "tinygit", "attributes")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "tinygit", "attributes")
+}
+
+// attributeRules decides the attributes of paths. From the lowest to the
+// highest precedence the lines come from the global attributes file, the
+// .gitattributes files from the root down to the directory of the path and
+// .tinygit/info/attributes. The last line stating an attribute wins.
+type attributeRules struct {
+	global *attributesFile
+	info   *attributesFile
+	// dirs caches the .gitattributes file of each directory, the rules are
+	// shared by the workers hashing files in parallel.
+	mu     sync.Mutex
+	dirs   map[string]*attributesFile
+	macros map[string][]string
+}
+
+// newAttributeRules create the rules of the attributes files of the
+// repository.
+func newAttributeRules(config *Config) (*attributeRules, error) {
+	r := &attributeRules{
+		global: &attributesFile{},
+		dirs:   make(map[string]*attributesFile),
+		macros: make(map[string][]string),
+	}
+	for name, states := range builtinMacros {
+		r.macros[name] = states
+	}
+	if file := globalAttributesFile(config); file != "" {
+		global, err := readAttributesFile(file, "", file, true)
+		if err != nil {
+			return nil, err
+		}
+		r.global = global
+	}
+	root, err := r.dirAttributes("")
+	if err != nil {
+		return nil, err
+	}
+	file := infoAttributesFile()
+	info, err := readAttributesFile(file, "", filepath.ToSlash(file), true)
+	if err != nil {
+		return nil, err
+	}
+	r.info = info
+	for _, file := range []*attributesFile{r.global, root, info} {
+		for name, states := range file.macros {
+			r.macros[name] = states
+		}
+	}
+	return r, nil
+}
+
+// dirAttributes return the .gitattributes file of the directory, only the
+// root one may define macros.
+func (r *attributeRules) dirAttributes(dir string) (*attributesFile, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if file, ok := r.dirs[dir]; ok {
+		return file, nil
+	}
+	source := AttributesFileName
+	if dir != "" {
+		source = dir + "/" + AttributesFileName
+	}
+	file, err := readAttributesFile(filepath.FromSlash(source), dir, source, dir == "")
+	if err != nil {
+		return nil, err
+	}
+	r.dirs[dir] = file
+	return file, nil
+}
+
+// apply record the attribute state into attrs, expanding macros.
+func (r *attributeRules) apply(attrs map[string]string, state string, depth int) {
+	switch {
+	case strings.HasPrefix(state, "-"):
+		attrs[state[1:]] = AttributeUnset
+	case strings.HasPrefix(state, "!"):
+		delete(attrs, state[1:])
+	case strings.Contains(state, "="):
+		name, value, _ := strings.Cut(state, "=")
+		attrs[name] = value
+	default:
+		attrs[state] = AttributeSet
+		// a macro also sets its states, recursion is bounded against loops
+		if macro, ok := r.macros[state]; ok && depth < 8 {
+			for _, s := range macro {
+				r.apply(attrs, s, depth+1)
+			}
+		}
+	}
+}
+
+// attributes return the specified attributes of the repo relative path of a
+// file, the set and unset ones having the values AttributeSet and
+// AttributeUnset.
+func (r *attributeRules) attributes(rel string) (map[string]string, error) {
+	files := []*attributesFile{r.global}
+	var dirs []string
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, "")
+	for i := len(dirs) - 1; i >= 0; i-- {
+		file, err := r.dirAttributes(dirs[i])
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	files = append(files, r.info)
+
+	attrs := make(map[string]string)
+	for _, file := range files {
+		for _, line := range file.lines {
+			if !line.pattern.match(rel, false) {
+				continue
+			}
+			for _, state := range line.states {
+				r.apply(attrs, state, 0)
+			}
+		}
+	}
+	return attrs, nil
+}
+
+// CheckAttrParam check-attr command params.
+type CheckAttrParam struct {
+	// Attributes are the names to report, All reports every specified one.
+	Attributes []string
+	All        bool
+	Paths      []string
+}
+
+// AttributeValue is the value of an attribute of a path reported by
+// CheckAttr.
+type AttributeValue struct {
+	Path  string
+	Name  string
+	Value string
+}
+
+// String format the value as "<path>: <attribute>: <value>".
+func (v AttributeValue) String() string {
+	return fmt.Sprintf("%s: %s: %s", v.Path, v.Name, v.Value)
+}
+
+// CheckAttr return the values of the attributes of each path, in the order
+// of the paths then of the attributes. With All the specified attributes are
+// returned sorted by name.
+func CheckAttr(param CheckAttrParam) ([]AttributeValue, error) {
+	if len(param.Paths) == 0 {
+		return nil, errors.New("no path specified")
+	}
+	if !param.All && len(param.Attributes) == 0 {
+		return nil, errors.New("no attribute specified")
+	}
+	config, err := ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := newAttributeRules(config)
+	if err != nil {
+		return nil, err
+	}
+	var values []AttributeValue
+	for _, p := range param.Paths {
+		rel, err := repoRelPath(p)
+		if err != nil {
+			return nil, err
+		}
+		attrs, err := rules.attributes(rel)
+		if err != nil {
+			return nil, err
+		}
+		names := param.Attributes
+		if param.All {
+			names = make([]string, 0, len(attrs))
+			for name := range attrs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+		for _, name := range names {
+			value, ok := attrs[name]
+			if !ok {
+				value = AttributeUnspecified
+			}
+			values = append(values, AttributeValue{Path: p, Name: name, Value: value})
+		}
+	}
+	return values, nil
+}
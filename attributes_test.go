@@ -0,0 +1,72 @@
+package tinygit
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckAttr(t *testing.T) {
+	setupRepo(t)
+	global := filepath.Join(t.TempDir(), "attributes")
+	if err := os.WriteFile(global, []byte("*.txt eol=crlf diff\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := ReadConfig()
+	config.Set("core.attributesFile", global)
+	if err := WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, map[string]string{
+		".tinygit/info/attributes": "local.txt -text\n",
+		".gitattributes":           "[attr]generated -diff linguist=off\n*.txt text\n*.png binary\n!*.md text\ngen/* generated\n",
+		"dir/.gitattributes":       "*.txt eol=lf !diff\n/top.txt -text\n",
+	})
+
+	values, err := CheckAttr(CheckAttrParam{
+		Attributes: []string{"text", "eol", "diff"},
+		Paths:      []string{"a.txt", "dir/a.txt", "dir/top.txt", "dir/sub/top.txt", "local.txt", "a.md"},
+	})
+	if err != nil {
+		t.Fatalf("check-attr: %+v", err)
+	}
+	var got []string
+	for _, v := range values {
+		got = append(got, v.String())
+	}
+	want := []string{
+		"a.txt: text: set", "a.txt: eol: crlf", "a.txt: diff: set",
+		"dir/a.txt: text: set", "dir/a.txt: eol: lf", "dir/a.txt: diff: unspecified",
+		"dir/top.txt: text: unset", "dir/top.txt: eol: lf", "dir/top.txt: diff: unspecified",
+		"dir/sub/top.txt: text: set", "dir/sub/top.txt: eol: lf", "dir/sub/top.txt: diff: unspecified",
+		"local.txt: text: unset", "local.txt: eol: crlf", "local.txt: diff: set",
+		"a.md: text: unspecified", "a.md: eol: unspecified", "a.md: diff: unspecified",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+
+	// macros set their own name and expand to their states
+	values, err = CheckAttr(CheckAttrParam{All: true, Paths: []string{"a.png", "gen/out.c"}})
+	if err != nil {
+		t.Fatalf("check-attr: %+v", err)
+	}
+	got = nil
+	for _, v := range values {
+		got = append(got, v.String())
+	}
+	want = []string{
+		"a.png: binary: set", "a.png: diff: unset", "a.png: merge: unset", "a.png: text: unset",
+		"gen/out.c: diff: unset", "gen/out.c: generated: set", "gen/out.c: linguist: off",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+
+	// macros can only be defined at the top level
+	writeFiles(t, map[string]string{"dir/.gitattributes": "[attr]mine text\n"})
+	if _, err := CheckAttr(CheckAttrParam{Attributes: []string{"text"}, Paths: []string{"dir/a.txt"}}); err == nil {
+		t.Fatal("expected a macro definition error")
+	}
+}
@@ -0,0 +1,284 @@
+package tinygit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CheckoutParam checkout command params.
+type CheckoutParam struct {
+	// Target is the branch or commit to check out, HEAD when empty.
+	Target string
+	// NewBranch creates a branch starting at Target and checks it out.
+	NewBranch string
+	// Detach detaches HEAD at the commit even when Target is a branch.
+	Detach bool
+	// Force discards the local modifications of tracked files.
+	Force bool
+}
+
+// Checkout switch the index, the working tree and HEAD to the target commit
+// and return a message describing the new HEAD. Local modifications of files
+// which differ between the current and the target commits are refused unless
+// forced, other local modifications are kept.
+func Checkout(param CheckoutParam) (string, error) {
+	target := param.Target
+	if target == "" {
+		target = "HEAD"
+	}
+	if param.NewBranch != "" {
+		if err := checkBranchName(param.NewBranch); err != nil {
+			return "", err
+		}
+		sha1, err := readRef("refs/heads/" + param.NewBranch)
+		if err != nil {
+			return "", err
+		}
+		if sha1 != "" {
+			return "", fmt.Errorf("a branch named '%s' already exists", param.NewBranch)
+		}
+	}
+	currentBranch, currentSha1, err := readHead()
+	if err != nil {
+		return "", err
+	}
+
+	// a branch is checked out by name, anything else detaches HEAD
+	var branch string
+	if !param.Detach && param.NewBranch == "" && target != "HEAD" {
+		sha1, err := readRef("refs/heads/" + target)
+		if err != nil {
+			return "", err
+		}
+		if sha1 != "" || target == currentBranch {
+			branch = target
+		}
+	}
+	var sha1 string
+	switch {
+	case target == "HEAD" || branch != "" && branch == currentBranch:
+		sha1 = currentSha1
+		branch = currentBranch
+	case branch != "":
+		sha1, err = readRef("refs/heads/" + branch)
+	default:
+		sha1, err = resolveRevision(target)
+	}
+	if err != nil {
+		return "", err
+	}
+	if param.NewBranch != "" {
+		branch = param.NewBranch
+	} else if param.Detach {
+		branch = ""
+	}
+	// a branch is checked out in a single working tree
+	if branch != "" && branch != currentBranch && param.NewBranch == "" {
+		at, err := checkedOutAt(branch)
+		if err != nil {
+			return "", err
+		}
+		if at != "" {
+			return "", fmt.Errorf("'%s' is already checked out at '%s'", branch, at)
+		}
+	}
+
+	// an unborn branch has no commit to check out
+	if sha1 == "" {
+		if param.NewBranch == "" {
+			return fmt.Sprintf("Already on '%s'", branch), nil
+		}
+		if err := writeHead(branch, ""); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Switched to a new branch '%s'", branch), nil
+	}
+
+	commit, err := readCommit(sha1)
+	if err != nil {
+		return "", err
+	}
+	targetIndexes, err := flattenTree(commit.Tree)
+	if err != nil {
+		return "", err
+	}
+	headIndexes, err := headTree()
+	if err != nil {
+		return "", err
+	}
+	lock, err := lockIndex()
+	if err != nil {
+		return "", err
+	}
+	defer lock.abort()
+	filter, err := newConvertFilter()
+	if err != nil {
+		return "", err
+	}
+	if err := checkoutTree(lock, filter, headIndexes, targetIndexes, param.Force); err != nil {
+		return "", err
+	}
+
+	if param.NewBranch != "" {
+		if err := updateRef("refs/heads/"+branch, sha1, ""); err != nil {
+			return "", err
+		}
+	}
+	if err := writeHead(branch, sha1); err != nil {
+		return "", err
+	}
+	switch {
+	case param.NewBranch != "":
+		return fmt.Sprintf("Switched to a new branch '%s'", branch), nil
+	case branch != "" && branch == currentBranch:
+		return fmt.Sprintf("Already on '%s'", branch), nil
+	case branch != "":
+		return fmt.Sprintf("Switched to branch '%s'", branch), nil
+	}
+	return fmt.Sprintf("HEAD is now at %s %s", sha1[:7], commit.subject()), nil
+}
+
+// checkoutTree move the index and the working tree from the head files to the
+// target files. Files which are the same in both keep their index entry and
+// local modifications, the others must be unmodified unless forced. Untracked
+// files which are not ignored are never overwritten unless forced. The new
+// entries are written through the lock of the index held by the caller.
+func checkoutTree(lock *indexLock, filter *convertFilter, head, target Indexes, force bool) error {
+	indexes, err := ReadIndex()
+	if err != nil {
+		return err
+	}
+	if !force && len(indexes.Unmerged()) > 0 {
+		return errors.New("you need to resolve your current index first")
+	}
+	rules, err := standardIgnoreRules()
+	if err != nil {
+		return err
+	}
+	cone, sparse, err := readSparseCone()
+	if err != nil {
+		return err
+	}
+
+	paths := make(map[string]bool)
+	for _, list := range []Indexes{head, target, indexes} {
+		for _, index := range list {
+			paths[index.Path] = true
+		}
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+	find := func(list Indexes, p string) *Index {
+		if i, ok := list.Find(p); ok {
+			return &list[i]
+		}
+		return nil
+	}
+
+	indexTime := indexModTime()
+	var (
+		result    Indexes
+		writes    Indexes
+		removes   []string
+		dirty     []string
+		untracked []string
+	)
+	for _, p := range sorted {
+		h, t, i := find(head, p), find(target, p), find(indexes, p)
+		if !force && sameEntry(h, t) {
+			if i != nil {
+				result = append(result, *i)
+			}
+			continue
+		}
+		// the conflict stages of a forced checkout are always replaced
+		merged := i == nil || i.Stage() == StageMerged
+		change := worktreeUnchanged
+		if i != nil && merged {
+			if change, err = i.worktreeChange(filter, indexTime); err != nil {
+				return err
+			}
+		}
+		if merged && sameEntry(i, t) && change == worktreeUnchanged {
+			if i != nil {
+				result = append(result, *i)
+			}
+			continue
+		}
+		if !force {
+			switch {
+			case !sameEntry(i, h):
+				dirty = append(dirty, p)
+				continue
+			case i != nil && change == worktreeModified:
+				dirty = append(dirty, p)
+				continue
+			case i == nil:
+				_, err := os.Lstat(filepath.FromSlash(p))
+				if err == nil {
+					ignored, err := rules.ignored(p, false)
+					if err != nil {
+						return err
+					}
+					if !ignored {
+						untracked = append(untracked, p)
+						continue
+					}
+				} else if !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+			}
+		}
+		if i != nil || t == nil {
+			removes = append(removes, p)
+		}
+		if t != nil {
+			writes = append(writes, *t)
+		}
+	}
+	if len(dirty) > 0 {
+		return fmt.Errorf("your local changes to the following files would be overwritten by checkout:\n\t%s\nPlease commit your changes or stash them before you switch branches.",
+			strings.Join(dirty, "\n\t"))
+	}
+	if len(untracked) > 0 {
+		return fmt.Errorf("the following untracked working tree files would be overwritten by checkout:\n\t%s\nPlease move or remove them before you switch branches.",
+			strings.Join(untracked, "\n\t"))
+	}
+
+	// files are removed first so directories can replace them and the other way round
+	for _, p := range removes {
+		if err := removeWorktreeFile(p); err != nil {
+			return err
+		}
+	}
+	for _, index := range writes {
+		if sparse && !cone.includes(index.Path) {
+			index.SetSkipWorktree(true)
+			result = append(result, index)
+			continue
+		}
+		index, err := checkoutIndex(filter, index)
+		if err != nil {
+			return err
+		}
+		result = append(result, index)
+	}
+	return writeIndex(lock, result.Sort())
+}
+
+// sameEntry report whether both entries are absent or record the same content
+// and mode.
+func sameEntry(a, b *Index) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Sha1 == b.Sha1 && treeMode(a.Mode) == treeMode(b.Mode) && !a.IntentToAdd() && !b.IntentToAdd()
+}
@@ -0,0 +1,163 @@
+package tinygit
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckout(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{
+		"a.txt":     "a",
+		"b.txt":     "b",
+		"dir/c.txt": "c",
+		"run.sh":    "#!/bin/sh",
+	})
+	if err := os.Chmod("run.sh", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	first := commitIndex(t, "first")
+
+	message, err := Checkout(CheckoutParam{NewBranch: "topic"})
+	if err != nil {
+		t.Fatalf("checkout: %+v", err)
+	}
+	if message != "Switched to a new branch 'topic'" {
+		t.Fatalf("unexpected message %q", message)
+	}
+	writeFiles(t, map[string]string{"a.txt": "a2", "dir/d/e.txt": "e"})
+	if err := os.Remove("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	second := commitIndex(t, "second")
+
+	if _, err := Checkout(CheckoutParam{Target: "master"}); err != nil {
+		t.Fatalf("checkout: %+v", err)
+	}
+	assertFiles(t, map[string]string{"a.txt": "a", "b.txt": "b", "dir/c.txt": "c", "run.sh": "#!/bin/sh"})
+	if _, err := os.Stat("dir/d"); !os.IsNotExist(err) {
+		t.Fatalf("expected dir/d removed, but got %v", err)
+	}
+	if info, err := os.Stat("run.sh"); err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Fatalf("expected run.sh executable, but got %v, %v", info.Mode(), err)
+	}
+	branch, head, _ := readHead()
+	if branch != "master" || head != first {
+		t.Fatalf("expected HEAD master at %s, but got %s at %s", first, branch, head)
+	}
+	// the rebuilt index has stat data, so nothing shows as changed
+	if got := mustLsFiles(t, LsFilesParam{Modified: true}); len(got) != 0 {
+		t.Fatalf("expected no modified files, but got %q", got)
+	}
+	report, err := Status(StatusParam{})
+	if err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	if len(report.Entries) != 0 {
+		t.Fatalf("expected clean status, but got %+v", report.Entries)
+	}
+
+	// local modifications of files the same in both commits are carried over
+	writeFiles(t, map[string]string{"dir/c.txt": "local"})
+	if message, err = Checkout(CheckoutParam{Target: "topic"}); err != nil {
+		t.Fatalf("checkout: %+v", err)
+	}
+	if message != "Switched to branch 'topic'" {
+		t.Fatalf("unexpected message %q", message)
+	}
+	assertFiles(t, map[string]string{"a.txt": "a2", "dir/c.txt": "local", "dir/d/e.txt": "e"})
+	if _, err := os.Stat("b.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expected b.txt removed, but got %v", err)
+	}
+
+	// local modifications of files which differ are refused
+	writeFiles(t, map[string]string{"a.txt": "local"})
+	_, err = Checkout(CheckoutParam{Target: "master"})
+	if err == nil || !strings.Contains(err.Error(), "\ta.txt\n") {
+		t.Fatalf("expected local changes error, but got %v", err)
+	}
+	if branch, _, _ := readHead(); branch != "topic" {
+		t.Fatalf("expected HEAD to stay on topic, but got %s", branch)
+	}
+
+	// detach at an abbreviated commit, forcing away the local changes
+	if message, err = Checkout(CheckoutParam{Target: first[:8], Force: true}); err != nil {
+		t.Fatalf("checkout: %+v", err)
+	}
+	if message != "HEAD is now at "+first[:7]+" first" {
+		t.Fatalf("unexpected message %q", message)
+	}
+	assertFiles(t, map[string]string{"a.txt": "a", "b.txt": "b", "dir/c.txt": "c"})
+	branch, head, _ = readHead()
+	if branch != "" || head != first {
+		t.Fatalf("expected detached HEAD at %s, but got %s at %s", first, branch, head)
+	}
+
+	// untracked files are not overwritten
+	writeFiles(t, map[string]string{"dir/d/e.txt": "untracked"})
+	_, err = Checkout(CheckoutParam{Target: "topic"})
+	if err == nil || !strings.Contains(err.Error(), "untracked working tree files") {
+		t.Fatalf("expected untracked files error, but got %v", err)
+	}
+	if err := os.Remove("dir/d/e.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Checkout(CheckoutParam{Target: "topic"}); err != nil {
+		t.Fatalf("checkout: %+v", err)
+	}
+	if _, head, _ := readHead(); head != second {
+		t.Fatalf("expected HEAD at %s, but got %s", second, head)
+	}
+
+	for _, param := range []CheckoutParam{
+		{Target: "missing"},
+		{NewBranch: "topic"},
+		{NewBranch: "bad..name"},
+	} {
+		if _, err := Checkout(param); err == nil {
+			t.Errorf("expected error for %+v", param)
+		}
+	}
+}
+
+func TestCheckoutSparse(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a", "in/b.txt": "b", "out/c.txt": "c"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	commitIndex(t, "first")
+	if _, err := Checkout(CheckoutParam{NewBranch: "topic"}); err != nil {
+		t.Fatalf("checkout: %+v", err)
+	}
+	writeFiles(t, map[string]string{"in/b.txt": "b2", "out/c.txt": "c2"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	commitIndex(t, "second")
+	if _, err := Checkout(CheckoutParam{Target: "master"}); err != nil {
+		t.Fatalf("checkout: %+v", err)
+	}
+	if err := SparseCheckoutSet([]string{"in"}); err != nil {
+		t.Fatalf("sparse checkout: %+v", err)
+	}
+	if _, err := Checkout(CheckoutParam{Target: "topic"}); err != nil {
+		t.Fatalf("checkout: %+v", err)
+	}
+	assertFiles(t, map[string]string{"a.txt": "a", "in/b.txt": "b2"})
+	if _, err := os.Stat("out"); !os.IsNotExist(err) {
+		t.Fatalf("expected out to stay outside the sparse checkout, but got %v", err)
+	}
+	want := []string{"H a.txt", "H in/b.txt", "S out/c.txt"}
+	if got := mustLsFiles(t, LsFilesParam{Tags: true}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+}
@@ -0,0 +1,164 @@
+package tinygit
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// CleanParam clean command params.
+type CleanParam struct {
+	Paths []string
+	// DryRun only reports what would be removed.
+	DryRun bool
+	// Force is required to remove anything unless clean.requireForce is false.
+	Force bool
+	// Directories also removes untracked directories.
+	Directories bool
+	// NoIgnore removes ignored files too, only Excludes are kept.
+	NoIgnore bool
+	// OnlyIgnored removes only the ignored files.
+	OnlyIgnored bool
+	// Excludes are ignore patterns added to the standard ignore rules.
+	Excludes []string
+}
+
+// Clean remove the untracked files which are not ignored from the working
+// tree and return the removed paths, directories removed as a whole end
+// with a slash. Untracked directories are only entered with Directories or
+// when pathspecs are given, and only removed as a whole with Directories.
+func Clean(param CleanParam) ([]string, error) {
+	if param.NoIgnore && param.OnlyIgnored {
+		return nil, errors.New("-x and -X cannot be used together")
+	}
+	if !param.Force && !param.DryRun {
+		config, err := ReadConfig()
+		if err != nil {
+			return nil, err
+		}
+		requireForce, err := config.GetBool("clean.requireForce", true)
+		if err != nil {
+			return nil, err
+		}
+		if requireForce {
+			return nil, errors.New("clean.requireForce defaults to true and neither -n nor -f given; refusing to clean")
+		}
+	}
+	specs := []string{"."}
+	if len(param.Paths) > 0 {
+		specs = make([]string, len(param.Paths))
+		for i, p := range param.Paths {
+			rel, err := repoRelPath(p)
+			if err != nil {
+				return nil, err
+			}
+			specs[i] = rel
+		}
+	}
+	// a path is looked at when it is inside a pathspec or leads to one
+	relevant := func(p string) bool {
+		if matchAnyPathspec(specs, p) {
+			return true
+		}
+		for _, spec := range specs {
+			if strings.HasPrefix(spec, p+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	indexes, err := ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	trackedDirs := make(map[string]bool)
+	for _, index := range indexes {
+		for dir := path.Dir(index.Path); dir != "."; dir = path.Dir(dir) {
+			trackedDirs[dir] = true
+		}
+	}
+	rules, err := newIgnoreRules(param.Excludes, !param.NoIgnore)
+	if err != nil {
+		return nil, err
+	}
+
+	// collect return the paths to remove below dir and whether everything
+	// below it is removed
+	var collect func(dir string, untracked bool) ([]string, bool, error)
+	collect = func(dir string, untracked bool) ([]string, bool, error) {
+		entries, err := os.ReadDir(filepath.Join(".", filepath.FromSlash(dir)))
+		if err != nil {
+			return nil, false, err
+		}
+		var paths []string
+		all := true
+		for _, entry := range entries {
+			rel := path.Join(dir, entry.Name())
+			if entry.Name() == RepoRootPath || !relevant(rel) {
+				all = false
+				continue
+			}
+			ignored, err := rules.ignored(rel, entry.IsDir())
+			if err != nil {
+				return nil, false, err
+			}
+			if !entry.IsDir() {
+				if _, tracked := indexes.Find(rel); !tracked && ignored == param.OnlyIgnored {
+					paths = append(paths, rel)
+				} else {
+					all = false
+				}
+				continue
+			}
+			if !untracked && trackedDirs[rel] {
+				sub, _, err := collect(rel, false)
+				if err != nil {
+					return nil, false, err
+				}
+				paths = append(paths, sub...)
+				all = false
+				continue
+			}
+			// untracked directories are only entered with -d or a pathspec,
+			// never when they hold another repository
+			_, err = os.Stat(filepath.Join(filepath.FromSlash(rel), RepoRootPath))
+			if !param.Directories && len(param.Paths) == 0 || err == nil || ignored && !param.OnlyIgnored {
+				all = false
+				continue
+			}
+			if ignored && param.Directories {
+				paths = append(paths, rel+"/")
+				continue
+			}
+			sub, subAll, err := collect(rel, true)
+			if err != nil {
+				return nil, false, err
+			}
+			if subAll && param.Directories && matchAnyPathspec(specs, rel) {
+				paths = append(paths, rel+"/")
+				continue
+			}
+			paths = append(paths, sub...)
+			all = false
+		}
+		return paths, all, nil
+	}
+	paths, _, err := collect("", false)
+	if err != nil || param.DryRun {
+		return paths, err
+	}
+	for _, p := range paths {
+		if strings.HasSuffix(p, "/") {
+			err = os.RemoveAll(filepath.FromSlash(p))
+		} else {
+			err = os.Remove(filepath.FromSlash(p))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,101 @@
+package tinygit
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{
+		".tinygitignore":       "*.o\nbuild/\n",
+		"a.txt":                "a",
+		"dir/b.txt":            "b",
+		"dir/new.txt":          "new",
+		"dir/obj.o":            "obj",
+		"new.txt":              "new",
+		"build/out.bin":        "out",
+		"untracked/c.txt":      "c",
+		"untracked/d.o":        "d",
+		"junk/e.txt":           "e",
+		"nested/.tinygit/HEAD": "ref: refs/heads/master",
+	})
+	if err := Add(AddParam{Paths: []string{".tinygitignore", "a.txt", "dir/b.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+
+	if _, err := Clean(CleanParam{}); err == nil {
+		t.Fatal("expected error without -n or -f")
+	}
+	if _, err := Clean(CleanParam{DryRun: true, NoIgnore: true, OnlyIgnored: true}); err == nil {
+		t.Fatal("expected error for -x with -X")
+	}
+
+	cases := []struct {
+		name  string
+		param CleanParam
+		want  []string
+	}{
+		{
+			name:  "files",
+			param: CleanParam{},
+			want:  []string{"dir/new.txt", "new.txt"},
+		},
+		{
+			name:  "directories",
+			param: CleanParam{Directories: true},
+			want:  []string{"dir/new.txt", "junk/", "new.txt", "untracked/c.txt"},
+		},
+		{
+			name:  "no_ignore",
+			param: CleanParam{Directories: true, NoIgnore: true},
+			want:  []string{"build/", "dir/new.txt", "dir/obj.o", "junk/", "new.txt", "untracked/"},
+		},
+		{
+			name:  "only_ignored",
+			param: CleanParam{Directories: true, OnlyIgnored: true},
+			want:  []string{"build/", "dir/obj.o", "untracked/d.o"},
+		},
+		{
+			name:  "excludes",
+			param: CleanParam{Directories: true, Excludes: []string{"new.txt"}},
+			want:  []string{"junk/", "untracked/c.txt"},
+		},
+		{
+			name:  "pathspec",
+			param: CleanParam{Paths: []string{"untracked", "dir"}},
+			want:  []string{"dir/new.txt", "untracked/c.txt"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.param.DryRun = true
+			got, err := Clean(c.param)
+			if err != nil {
+				t.Fatalf("clean: %+v", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("expected %q, but got %q", c.want, got)
+			}
+		})
+	}
+
+	config, _ := ReadConfig()
+	config.Set("clean.requireForce", "false")
+	if err := WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Clean(CleanParam{Directories: true}); err != nil {
+		t.Fatalf("clean: %+v", err)
+	}
+	for _, p := range []string{"new.txt", "dir/new.txt", "junk", "untracked/c.txt"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s removed, but got %v", p, err)
+		}
+	}
+	assertFiles(t, map[string]string{"a.txt": "a", "dir/obj.o": "obj", "build/out.bin": "out", "untracked/d.o": "d"})
+	if _, err := os.Stat("nested/.tinygit/HEAD"); err != nil {
+		t.Fatalf("expected the nested repository kept, but got %v", err)
+	}
+}
@@ -1,12 +1,15 @@
 package tinygit
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/startdusk/tinygit/shared/filestat"
+	"github.com/startdusk/tinygit/shared/refs"
 )
 
 // Initail create directory for repo and initialize .tinygit directory.
@@ -23,10 +26,7 @@ func Initail(repo string) error {
 			return err
 		}
 	}
-	if err := os.WriteFile(filepath.Join(tinygitPath, "HEAD"), []byte("ref: refs/heads/master"), os.ModePerm); err != nil {
-		return err
-	}
-	return nil
+	return refs.Store{GitDir: tinygitPath, CommonDir: tinygitPath}.WriteSymbolic("HEAD", "refs/heads/master")
 }
 
 // PrintHelp print the help message.
@@ -41,65 +41,264 @@ start a working area (see also: tinygit help tutorial)
 work on the current change (see also: tinygit help everyday)
    add       Add file contents to the index
    mv        Move or rename a file, a directory, or a symlink
+   restore   Restore working tree files
    rm        Remove files from the working tree and from the index
+
+grow, mark and tweak your common history
+   checkout  Switch branches or restore working tree files
+   reset     Reset current HEAD to the specified state
+
+examine the history and state (see also: tinygit help revisions)
+   status    Show the working tree status
 	`
 	fmt.Println(help)
 }
 
+// AddParam add command params.
+type AddParam struct {
+	Paths []string
+	// All stages every change of the working tree when no paths are given.
+	All bool
+	// Update only refreshes entries already in the index, new files are not added.
+	Update bool
+	// IgnoreRemoval keeps index entries whose files are removed from the working tree.
+	IgnoreRemoval bool
+	// IntentToAdd records new paths with an empty content to be added later.
+	IntentToAdd bool
+	// Jobs bounds the number of files hashed concurrently, zero means one per CPU.
+	Jobs int
+	// Force allows adding otherwise ignored files.
+	Force bool
+}
+
 // Add add file contents to the index.
-func Add(path string) error {
-	// 1.read files recursively
-	var paths []string
-	err := filepath.Walk(path, func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
+func Add(param AddParam) error {
+	if len(param.Paths) == 0 && !param.All && !param.Update {
+		return errors.New("nothing specified, nothing added")
+	}
+	specs := []string{"."}
+	if len(param.Paths) > 0 {
+		specs = make([]string, len(param.Paths))
+		for i, path := range param.Paths {
+			rel, err := repoRelPath(path)
+			if err != nil {
+				return err
+			}
+			specs[i] = rel
 		}
-		fmt.Println(path, info.Size())
-		return nil
-	})
+	}
+
+	lock, err := lockIndex()
 	if err != nil {
 		return err
 	}
-	// 2.read index all entries
+	defer lock.abort()
+
+	// 1.read index all entries
 	indexes, err := ReadIndex()
 	if err != nil {
 		return err
 	}
+	positions := make(map[string]int, len(indexes))
+	for i, index := range indexes {
+		// conflicting paths have no stage 0 entry and are always rehashed
+		if index.Stage() == StageMerged {
+			positions[index.Path] = i
+		}
+	}
 
-	for _, path := range paths {
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return err
+	// 2.read files recursively, skipping ignored untracked files
+	rules, err := standardIgnoreRules()
+	if err != nil {
+		return err
+	}
+	trackedDirs := make(map[string]bool)
+	for _, index := range indexes {
+		for dir := filepath.Dir(index.Path); dir != "."; dir = filepath.Dir(dir) {
+			trackedDirs[filepath.ToSlash(dir)] = true
 		}
-		sha1, _, err := HashObject(HashParam{
-			Data:      data,
-			ObjType:   Blob,
-			WriteFile: true,
+	}
+	var paths, ignoredSpecs []string
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		matched := false
+		err = filepath.Walk(filepath.FromSlash(spec), func(path string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Name() == RepoRootPath && info.IsDir() {
+				return filepath.SkipDir
+			}
+			if info.Name() == RepoRootPath {
+				return nil
+			}
+			rel := filepath.ToSlash(filepath.Clean(path))
+			if _, tracked := indexes.Find(rel); !param.Force && !tracked && !trackedDirs[rel] && rel != "." {
+				ignored, err := rules.ignored(rel, info.IsDir())
+				if err != nil {
+					return err
+				}
+				if ignored {
+					// ignored paths given explicitly are refused, others are skipped
+					if rel == spec {
+						matched = true
+						ignoredSpecs = append(ignoredSpecs, spec)
+					}
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+			}
+			// another repository is added as a gitlink to its HEAD commit
+			nested := info.IsDir() && rel != "." && isNestedRepo(path)
+			if info.IsDir() && !nested {
+				return nil
+			}
+			matched = true
+			_, tracked := indexes.Find(rel)
+			if !seen[rel] && (tracked || !param.Update) {
+				seen[rel] = true
+				paths = append(paths, rel)
+			}
+			if nested {
+				return filepath.SkipDir
+			}
+			return nil
 		})
-		if err != nil {
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
 			return err
 		}
-		st, err := filestat.Stat(path)
-		if err != nil {
+		for _, index := range indexes {
+			if matchPathspec(spec, index.Path) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("pathspec '%s' did not match any files", spec)
+		}
+	}
+
+	// 3.stage removals of tracked files missing from the working tree
+	removed := make(map[string]bool)
+	if !param.IgnoreRemoval && !param.IntentToAdd {
+		for _, index := range indexes {
+			if seen[index.Path] || index.AssumeUnchanged() || index.SkipWorktree() || index.FSMonitorValid() {
+				continue
+			}
+			for _, spec := range specs {
+				if !matchPathspec(spec, index.Path) {
+					continue
+				}
+				if _, err := os.Lstat(filepath.FromSlash(index.Path)); errors.Is(err, fs.ErrNotExist) {
+					removed[index.Path] = true
+				}
+				break
+			}
+		}
+	}
+
+	// 4.hash changed files in parallel and replace their entries
+	if param.IntentToAdd {
+		if _, _, err := HashObject(HashParam{ObjType: Blob, WriteFile: true}); err != nil {
 			return err
 		}
-		index := Index{
-			CTimeS: st.CreateTime,
-			CTimeN: 0,
-			MTimeS: st.ModifyTime,
-			MTimeN: 0,
-			Dev:    st.Dev,
-			INO:    st.INO,
-			Mode:   st.Mode,
-			UID:    st.UID,
-			GID:    st.GID,
-			Size:   st.Size,
-			Sha1:   sha1,
-			Flags:  st.Flags,
-			Path:   path,
-		}
-		indexes = append(indexes, index)
-	}
-	indexes = indexes.Sort()
-	return WriteIndex(indexes)
+	}
+	filter, err := newConvertFilter()
+	if err != nil {
+		return err
+	}
+	indexTime := indexModTime()
+	hashed := make([]*Index, len(paths))
+	errs := make([]error, len(paths))
+	parallelEach(len(paths), param.Jobs, func(n int) {
+		path := paths[n]
+		// files the file system monitor knows unchanged are not looked at
+		if i, tracked := positions[path]; tracked && indexes[i].FSMonitorValid() && !param.IntentToAdd {
+			return
+		}
+		if isNestedRepo(filepath.FromSlash(path)) {
+			index, err := gitlinkIndex(path)
+			if err != nil {
+				errs[n] = err
+				return
+			}
+			if i, tracked := positions[path]; !tracked || indexes[i].Mode != index.Mode || indexes[i].Sha1 != index.Sha1 {
+				hashed[n] = &index
+			}
+			return
+		}
+		st, err := filestat.Stat(filepath.FromSlash(path))
+		if err != nil {
+			errs[n] = fmt.Errorf("%s: %w", path, err)
+			return
+		}
+		if param.IntentToAdd {
+			if _, tracked := indexes.Find(path); tracked {
+				return
+			}
+			index := Index{Mode: st.Mode, Sha1: emptyBlobSha1, Path: path}
+			index.SetIntentToAdd(true)
+			hashed[n] = &index
+			return
+		}
+		if i, tracked := positions[path]; tracked {
+			index := indexes[i]
+			if index.AssumeUnchanged() || index.SkipWorktree() ||
+				index.MatchStat(st) && !index.isRacy(indexTime) {
+				return
+			}
+		}
+		sha1, err := hashFile(filter, path)
+		if err != nil {
+			errs[n] = fmt.Errorf("%s: %w", path, err)
+			return
+		}
+		index := newIndex(path, sha1, st)
+		hashed[n] = &index
+	})
+	if err := collectErrors(errs); err != nil {
+		return err
+	}
+	replaced := make(map[string]bool)
+	var added Indexes
+	for _, index := range hashed {
+		if index != nil {
+			replaced[index.Path] = true
+			added = append(added, *index)
+		}
+	}
+
+	// the new entries replace every stage of their path
+	merged := make(Indexes, 0, len(indexes)+len(added))
+	for _, index := range indexes {
+		if !removed[index.Path] && !replaced[index.Path] {
+			merged = append(merged, index)
+		}
+	}
+	merged = append(merged, added...)
+	if err := writeIndex(lock, merged.Sort()); err != nil {
+		return err
+	}
+	if len(ignoredSpecs) > 0 {
+		return fmt.Errorf("the following paths are ignored by one of your %s files:\n%s\nhint: use -f if you really want to add them",
+			IgnoreFileName, strings.Join(ignoredSpecs, "\n"))
+	}
+	return nil
+}
+
+// hashFile write the content of the repo relative path to the object store as
+// a blob and return its sha1.
+func hashFile(filter *convertFilter, path string) (string, error) {
+	data, err := readWorktreeFile(filter, path)
+	if err != nil {
+		return "", err
+	}
+	sha1, _, err := HashObject(HashParam{
+		Data:      data,
+		ObjType:   Blob,
+		WriteFile: true,
+	})
+	return sha1, err
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/startdusk/tinygit"
+)
+
+func checkAttr(args []string) {
+	var param tinygit.CheckAttrParam
+	var positionals []string
+	dashdash := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-a" || arg == "--all":
+			param.All = true
+		case arg == "--":
+			dashdash = true
+			param.Attributes = positionals
+			param.Paths = append(param.Paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			fatal(fmt.Errorf("unknown option '%s'", arg))
+		default:
+			positionals = append(positionals, arg)
+		}
+	}
+	// without '--' the first argument is an attribute unless -a is given
+	switch {
+	case dashdash:
+	case param.All:
+		param.Paths = positionals
+	case len(positionals) > 0:
+		param.Attributes = positionals[:1]
+		param.Paths = positionals[1:]
+	}
+	values, err := tinygit.CheckAttr(param)
+	if err != nil {
+		fatal(err)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	for _, v := range values {
+		fmt.Fprintln(w, v)
+	}
+	w.Flush()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/startdusk/tinygit"
+)
+
+func checkIgnore(args []string) {
+	var (
+		param       tinygit.CheckIgnoreParam
+		verbose     bool
+		nonMatching bool
+		stdin       bool
+	)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-v" || arg == "--verbose":
+			verbose = true
+		case arg == "-n" || arg == "--non-matching":
+			nonMatching = true
+		case arg == "--no-index":
+			param.NoIndex = true
+		case arg == "--stdin":
+			stdin = true
+		case arg == "--":
+			param.Paths = append(param.Paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			fatal(fmt.Errorf("unknown option '%s'", arg))
+		default:
+			param.Paths = append(param.Paths, arg)
+		}
+	}
+	if nonMatching && !verbose {
+		fatal(errors.New("--non-matching is only valid with --verbose"))
+	}
+	if stdin {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			param.Paths = append(param.Paths, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			fatal(err)
+		}
+	}
+	matches, err := tinygit.CheckIgnore(param)
+	if err != nil {
+		fatal(err)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	ignored := false
+	for _, m := range matches {
+		if m.Ignored() {
+			ignored = true
+		}
+		switch {
+		case verbose && (m.Pattern != "" || nonMatching):
+			fmt.Fprintln(w, m.Verbose())
+		case !verbose && m.Ignored():
+			fmt.Fprintln(w, m.Path)
+		}
+	}
+	w.Flush()
+	// like git, the exit status tells whether any path is ignored
+	if !ignored {
+		exit(1)
+	}
+}
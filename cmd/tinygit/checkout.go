@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/startdusk/tinygit"
+)
+
+func checkout(args []string) {
+	var param tinygit.CheckoutParam
+	var targets []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-b":
+			if i+1 >= len(args) {
+				fatal(errors.New("switch 'b' requires a value"))
+			}
+			param.NewBranch = args[i+1]
+			i++
+		case arg == "-f" || arg == "--force":
+			param.Force = true
+		case arg == "--detach":
+			param.Detach = true
+		case strings.HasPrefix(arg, "-"):
+			fatal(fmt.Errorf("unknown option '%s'", arg))
+		default:
+			targets = append(targets, arg)
+		}
+	}
+	if len(targets) > 1 {
+		fatal(errors.New("only one branch or commit can be checked out"))
+	}
+	if len(targets) == 1 {
+		param.Target = targets[0]
+	}
+	message, err := tinygit.Checkout(param)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Fprintln(os.Stderr, message)
+}
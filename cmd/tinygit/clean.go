@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/startdusk/tinygit"
+)
+
+func clean(args []string) {
+	var param tinygit.CleanParam
+	quiet := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-n" || arg == "--dry-run":
+			param.DryRun = true
+		case arg == "-f" || arg == "--force":
+			param.Force = true
+		case arg == "-d":
+			param.Directories = true
+		case arg == "-x":
+			param.NoIgnore = true
+		case arg == "-X":
+			param.OnlyIgnored = true
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		case arg == "-e" || arg == "--exclude":
+			if i+1 >= len(args) {
+				fatal(errors.New("option 'exclude' requires a value"))
+			}
+			param.Excludes = append(param.Excludes, args[i+1])
+			i++
+		case strings.HasPrefix(arg, "--exclude="):
+			param.Excludes = append(param.Excludes, strings.TrimPrefix(arg, "--exclude="))
+		case arg == "--":
+			param.Paths = append(param.Paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			fatal(fmt.Errorf("unknown option '%s'", arg))
+		default:
+			param.Paths = append(param.Paths, arg)
+		}
+	}
+	paths, err := tinygit.Clean(param)
+	if err != nil {
+		fatal(err)
+	}
+	if quiet && !param.DryRun {
+		return
+	}
+	action := "Removing"
+	if param.DryRun {
+		action = "Would remove"
+	}
+	for _, p := range paths {
+		fmt.Printf("%s %s\n", action, p)
+	}
+}
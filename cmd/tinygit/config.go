@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/startdusk/tinygit"
+)
+
+func config(args []string) {
+	unset := false
+	if len(args) > 0 && args[0] == "--unset" {
+		unset = true
+		args = args[1:]
+	}
+	if len(args) == 0 || len(args) > 2 || unset && len(args) != 1 {
+		fatal(errors.New("usage: tinygit config [--unset] <name> [<value>]"))
+	}
+	c, err := tinygit.ReadConfig()
+	if err != nil {
+		fatal(err)
+	}
+	switch {
+	case unset:
+		err = c.Unset(args[0])
+	case len(args) == 2:
+		err = c.Set(args[0], args[1])
+	default:
+		value, ok := c.Get(args[0])
+		if !ok {
+			exit(1)
+		}
+		fmt.Println(value)
+		return
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if err := tinygit.WriteConfig(c); err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/startdusk/tinygit"
+)
+
+func fsmonitor(args []string) {
+	if len(args) == 0 {
+		fatal(errors.New("usage: tinygit fsmonitor (start|run|stop|status)"))
+	}
+	switch args[0] {
+	case "start":
+		if err := fsmonitorStart(); err != nil {
+			fatal(err)
+		}
+	case "run":
+		// the daemon outlives the terminal which started it
+		signal.Ignore(syscall.SIGHUP)
+		if err := tinygit.FSMonitorRun(); err != nil {
+			fatal(err)
+		}
+	case "stop":
+		if err := tinygit.FSMonitorStop(); err != nil {
+			fatal(err)
+		}
+	case "status":
+		message, err := tinygit.FSMonitorStatus()
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(message)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown subcommand: `%s`\n", args[0])
+		exit(129)
+	}
+}
+
+// fsmonitorStart run the daemon in the background and wait until it answers.
+func fsmonitorStart() error {
+	if _, err := tinygit.FSMonitorStatus(); err == nil {
+		return errors.New("fsmonitor-daemon is already running")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, "fsmonitor", "run")
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("fsmonitor-daemon failed to start: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if _, err := tinygit.FSMonitorStatus(); err == nil {
+			return nil
+		}
+	}
+	return errors.New("fsmonitor-daemon did not start in time")
+}
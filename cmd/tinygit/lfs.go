@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/startdusk/tinygit"
+)
+
+func lfs(args []string) {
+	if len(args) == 0 {
+		fatal(errors.New("usage: tinygit lfs (track|untrack|ls-files) [<pattern>...]"))
+	}
+	var (
+		lines []string
+		err   error
+	)
+	switch args[0] {
+	case "track":
+		if len(args) == 1 {
+			var patterns []string
+			patterns, err = tinygit.LFSTracked()
+			if err == nil {
+				fmt.Println("Listing tracked patterns")
+			}
+			for _, pattern := range patterns {
+				lines = append(lines, fmt.Sprintf("    %s (%s)", pattern, tinygit.AttributesFileName))
+			}
+		} else {
+			lines, err = tinygit.LFSTrack(args[1:])
+		}
+	case "untrack":
+		lines, err = tinygit.LFSUntrack(args[1:])
+	case "ls-files":
+		var files []tinygit.LFSFile
+		files, err = tinygit.LFSLsFiles()
+		for _, file := range files {
+			lines = append(lines, file.String())
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown subcommand: `%s`\n", args[0])
+		exit(129)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
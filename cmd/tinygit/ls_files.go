@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/startdusk/tinygit"
+)
+
+func lsFiles(args []string) {
+	var param tinygit.LsFilesParam
+	terminator := "\n"
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-c" || arg == "--cached":
+			param.Cached = true
+		case arg == "-d" || arg == "--deleted":
+			param.Deleted = true
+		case arg == "-m" || arg == "--modified":
+			param.Modified = true
+		case arg == "-o" || arg == "--others":
+			param.Others = true
+		case arg == "-i" || arg == "--ignored":
+			param.Ignored = true
+		case arg == "-s" || arg == "--stage":
+			param.Stage = true
+		case arg == "-u" || arg == "--unmerged":
+			param.Unmerged = true
+		case arg == "-t":
+			param.Tags = true
+		case arg == "-v":
+			param.Verbose = true
+		case arg == "-z":
+			terminator = "\x00"
+		case arg == "-x" || arg == "--exclude":
+			if i+1 >= len(args) {
+				fatal(errors.New("option 'exclude' requires a value"))
+			}
+			param.Excludes = append(param.Excludes, args[i+1])
+			i++
+		case arg == "--exclude-standard":
+			param.ExcludeStandard = true
+		case strings.HasPrefix(arg, "--exclude="):
+			param.Excludes = append(param.Excludes, strings.TrimPrefix(arg, "--exclude="))
+		case arg == "--":
+			param.Paths = append(param.Paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			fatal(fmt.Errorf("unknown option '%s'", arg))
+		default:
+			param.Paths = append(param.Paths, arg)
+		}
+	}
+	lines, err := tinygit.LsFiles(param)
+	if err != nil {
+		fatal(err)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, line := range lines {
+		w.WriteString(line)
+		w.WriteString(terminator)
+	}
+}
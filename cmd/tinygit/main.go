@@ -11,11 +11,11 @@ import (
 var version = tinygit.Version()
 
 func main() {
+	defer tinygit.StopFilterProcesses()
 	if len(os.Args) == 1 {
 		tinygit.PrintHelp()
 		return
 	}
-	fmt.Println(os.Args)
 	cmd := os.Args[1]
 	switch cmd {
 	case "version":
@@ -30,7 +30,7 @@ func main() {
 		}
 		if err := tinygit.Initail(repo); err != nil {
 			fmt.Println("can't init this repository")
-			os.Exit(0)
+			exit(0)
 		}
 		if repo == "." {
 			dir, _ := os.Getwd()
@@ -38,15 +38,77 @@ func main() {
 		}
 		_ = repo // TODO: print something ...
 	case "add":
-		if len(os.Args) != 3 {
-			// TODO: print somthing...
-			os.Exit(1)
+		var param tinygit.AddParam
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "-A", "--all":
+				param.All = true
+			case "-u", "--update":
+				param.Update = true
+			case "--ignore-removal", "--no-all":
+				param.IgnoreRemoval = true
+			case "-N", "--intent-to-add":
+				param.IntentToAdd = true
+			case "-f", "--force":
+				param.Force = true
+			default:
+				param.Paths = append(param.Paths, arg)
+			}
 		}
-		path := os.Args[2]
-		tinygit.Add(path)
+		if err := tinygit.Add(param); err != nil {
+			fatal(err)
+		}
+	case "check-attr":
+		checkAttr(os.Args[2:])
+	case "check-ignore":
+		checkIgnore(os.Args[2:])
+	case "checkout":
+		checkout(os.Args[2:])
+	case "clean":
+		clean(os.Args[2:])
+	case "config":
+		config(os.Args[2:])
+	case "fsmonitor":
+		fsmonitor(os.Args[2:])
+	case "lfs":
+		lfs(os.Args[2:])
+	case "ls-files":
+		lsFiles(os.Args[2:])
+	case "mv":
+		mv(os.Args[2:])
+	case "reset":
+		reset(os.Args[2:])
+	case "restore":
+		restore(os.Args[2:])
+	case "rm":
+		rm(os.Args[2:])
+	case "sparse-checkout":
+		sparseCheckout(os.Args[2:])
+	case "stash":
+		stash(os.Args[2:])
+	case "status":
+		status(os.Args[2:])
+	case "update-index":
+		updateIndex(os.Args[2:])
+	case "worktree":
+		worktree(os.Args[2:])
+	case "submodule":
+		submodule(os.Args[2:])
 	case "help", "h":
 		tinygit.PrintHelp()
 	default:
 		fmt.Printf("Unsupported `%s` command\n", cmd)
 	}
 }
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+	exit(128)
+}
+
+// exit stop the filter processes, which deferred calls do not reach, and
+// exit with the code.
+func exit(code int) {
+	tinygit.StopFilterProcesses()
+	os.Exit(code)
+}
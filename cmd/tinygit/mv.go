@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/startdusk/tinygit"
+)
+
+func mv(args []string) {
+	var param tinygit.MvParam
+	verbose := false
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-f" || arg == "--force":
+			param.Force = true
+		case arg == "-n" || arg == "--dry-run":
+			param.DryRun = true
+		case arg == "-v" || arg == "--verbose":
+			verbose = true
+		case arg == "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			fatal(fmt.Errorf("unknown option '%s'", arg))
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) > 0 {
+		param.Sources, param.Destination = paths[:len(paths)-1], paths[len(paths)-1]
+	}
+	renames, err := tinygit.Mv(param)
+	if err != nil {
+		fatal(err)
+	}
+	for _, rename := range renames {
+		if param.DryRun {
+			fmt.Printf("Checking rename of '%s' to '%s'\n", rename.Source, rename.Destination)
+		}
+		if param.DryRun || verbose {
+			fmt.Printf("Renaming %s to %s\n", rename.Source, rename.Destination)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/startdusk/tinygit"
+)
+
+func reset(args []string) {
+	var param tinygit.ResetParam
+	var positionals []string
+	dashdash := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--soft" || arg == "--mixed" || arg == "--hard":
+			if param.Mode != "" {
+				fatal(errors.New("only one reset mode can be used"))
+			}
+			param.Mode = strings.TrimPrefix(arg, "--")
+		case arg == "--":
+			dashdash = true
+			param.Paths = append(param.Paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			fatal(fmt.Errorf("unknown option '%s'", arg))
+		default:
+			positionals = append(positionals, arg)
+		}
+	}
+	switch {
+	case dashdash && len(positionals) > 1:
+		fatal(errors.New("only one commit can be given before '--'"))
+	case dashdash && len(positionals) == 1:
+		param.Commit = positionals[0]
+	case len(positionals) > 0:
+		// without '--' the first argument is a commit unless it names a file
+		if _, err := os.Lstat(positionals[0]); err == nil {
+			param.Paths = positionals
+		} else {
+			param.Commit = positionals[0]
+			param.Paths = positionals[1:]
+		}
+	}
+	message, err := tinygit.Reset(param)
+	if err != nil {
+		fatal(err)
+	}
+	if message != "" {
+		fmt.Println(message)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/startdusk/tinygit"
+)
+
+func restore(args []string) {
+	var param tinygit.RestoreParam
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-S" || arg == "--staged":
+			param.Staged = true
+		case arg == "-W" || arg == "--worktree":
+			param.Worktree = true
+		case arg == "--ours":
+			param.Ours = true
+		case arg == "--theirs":
+			param.Theirs = true
+		case arg == "-s" || arg == "--source":
+			if i+1 >= len(args) {
+				fatal(errors.New("option 'source' requires a value"))
+			}
+			param.Source = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--source="):
+			param.Source = strings.TrimPrefix(arg, "--source=")
+		case arg == "--":
+			param.Paths = append(param.Paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			fatal(fmt.Errorf("unknown option '%s'", arg))
+		default:
+			param.Paths = append(param.Paths, arg)
+		}
+	}
+	if err := tinygit.Restore(param); err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/startdusk/tinygit"
+)
+
+func rm(args []string) {
+	var param tinygit.RmParam
+	quiet := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--cached":
+			param.Cached = true
+		case arg == "-r":
+			param.Recursive = true
+		case arg == "-f" || arg == "--force":
+			param.Force = true
+		case arg == "-n" || arg == "--dry-run":
+			param.DryRun = true
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		case arg == "--ignore-unmatch":
+			param.IgnoreUnmatch = true
+		case arg == "--":
+			param.Paths = append(param.Paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			fatal(fmt.Errorf("unknown option '%s'", arg))
+		default:
+			param.Paths = append(param.Paths, arg)
+		}
+	}
+	paths, err := tinygit.Rm(param)
+	if err != nil {
+		fatal(err)
+	}
+	if quiet {
+		return
+	}
+	for _, p := range paths {
+		fmt.Printf("rm '%s'\n", p)
+	}
+}
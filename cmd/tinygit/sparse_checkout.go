@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/startdusk/tinygit"
+)
+
+func sparseCheckout(args []string) {
+	if len(args) == 0 {
+		fatal(errors.New("usage: tinygit sparse-checkout (set|add|list|disable) [<directories>...]"))
+	}
+	var dirs []string
+	for _, arg := range args[1:] {
+		// cone mode is the only supported mode
+		if arg == "--cone" {
+			continue
+		}
+		dirs = append(dirs, arg)
+	}
+	var err error
+	switch args[0] {
+	case "set":
+		err = tinygit.SparseCheckoutSet(dirs)
+	case "add":
+		err = tinygit.SparseCheckoutAdd(dirs)
+	case "list":
+		var list []string
+		list, err = tinygit.SparseCheckoutList()
+		for _, dir := range list {
+			fmt.Println(dir)
+		}
+	case "disable":
+		err = tinygit.SparseCheckoutDisable()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown subcommand: `%s`\n", args[0])
+		exit(129)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/startdusk/tinygit"
+)
+
+func stash(args []string) {
+	subcommand := "push"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		subcommand, args = args[0], args[1:]
+	}
+	switch subcommand {
+	case "push":
+		stashPush(args)
+	case "list":
+		lines, err := tinygit.StashList()
+		if err != nil {
+			fatal(err)
+		}
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+	case "show":
+		lines, err := tinygit.StashShow(stashName(args))
+		if err != nil {
+			fatal(err)
+		}
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+	case "apply", "pop":
+		var param tinygit.StashApplyParam
+		var names []string
+		for _, arg := range args {
+			switch {
+			case arg == "--index":
+				param.Index = true
+			case strings.HasPrefix(arg, "-"):
+				fatal(fmt.Errorf("unknown option '%s'", arg))
+			default:
+				names = append(names, arg)
+			}
+		}
+		param.Stash = stashName(names)
+		var conflicts []string
+		var message string
+		var err error
+		if subcommand == "pop" {
+			conflicts, message, err = tinygit.StashPop(param)
+		} else {
+			conflicts, err = tinygit.StashApply(param)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		for _, conflict := range conflicts {
+			fmt.Println(conflict)
+		}
+		if message != "" {
+			fmt.Println(message)
+		}
+		if len(conflicts) > 0 {
+			exit(1)
+		}
+	case "drop":
+		message, err := tinygit.StashDrop(stashName(args))
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(message)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown subcommand: `%s`\n", subcommand)
+		exit(129)
+	}
+}
+
+func stashPush(args []string) {
+	var param tinygit.StashPushParam
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-u" || arg == "--include-untracked":
+			param.IncludeUntracked = true
+		case arg == "-k" || arg == "--keep-index":
+			param.KeepIndex = true
+		case arg == "-m" || arg == "--message":
+			if i+1 >= len(args) {
+				fatal(errors.New("option 'message' requires a value"))
+			}
+			param.Message = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--message="):
+			param.Message = strings.TrimPrefix(arg, "--message=")
+		default:
+			fatal(fmt.Errorf("unknown option '%s'", arg))
+		}
+	}
+	message, err := tinygit.StashPush(param)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Println(message)
+}
+
+// stashName return the single stash entry named by the arguments.
+func stashName(args []string) string {
+	if len(args) > 1 {
+		fatal(errors.New("too many revisions specified"))
+	}
+	if len(args) == 1 {
+		return args[0]
+	}
+	return ""
+}
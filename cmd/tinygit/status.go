@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/startdusk/tinygit"
+)
+
+func status(args []string) {
+	var (
+		param  tinygit.StatusParam
+		format = "long"
+		branch bool
+		nul    bool
+	)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-s" || arg == "--short":
+			format = "short"
+		case arg == "--long":
+			format = "long"
+		case arg == "--porcelain" || arg == "--porcelain=v1":
+			format = "v1"
+		case arg == "--porcelain=v2":
+			format = "v2"
+		case arg == "-b" || arg == "--branch":
+			branch = true
+		case arg == "-z":
+			nul = true
+		case arg == "-u" || arg == "--untracked-files":
+			param.Untracked = "all"
+		case strings.HasPrefix(arg, "--untracked-files="):
+			param.Untracked = strings.TrimPrefix(arg, "--untracked-files=")
+		case strings.HasPrefix(arg, "-u"):
+			param.Untracked = strings.TrimPrefix(arg, "-u")
+		case arg == "--":
+			param.Paths = append(param.Paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			fatal(fmt.Errorf("unknown option '%s'", arg))
+		default:
+			param.Paths = append(param.Paths, arg)
+		}
+	}
+	// -z implies the porcelain format unless another one is given
+	if nul && format == "long" {
+		format = "v1"
+	}
+
+	report, err := tinygit.Status(param)
+	if err != nil {
+		fatal(err)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	var lines []string
+	switch format {
+	case "long":
+		w.WriteString(report.Long())
+		return
+	case "short", "v1":
+		lines = report.Short(branch)
+	case "v2":
+		lines = report.PorcelainV2(branch)
+	}
+	terminator := "\n"
+	if nul {
+		terminator = "\x00"
+	}
+	for _, line := range lines {
+		w.WriteString(line)
+		w.WriteString(terminator)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/startdusk/tinygit"
+)
+
+func submodule(args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		submoduleStatus(args)
+		return
+	}
+	switch args[0] {
+	case "add":
+		var rest []string
+		for _, arg := range args[1:] {
+			if strings.HasPrefix(arg, "-") {
+				fatal(fmt.Errorf("unknown option '%s'", arg))
+			}
+			rest = append(rest, arg)
+		}
+		if len(rest) == 0 || len(rest) > 2 {
+			fatal(errors.New("usage: tinygit submodule add <repository> [<path>]"))
+		}
+		param := tinygit.SubmoduleAddParam{URL: rest[0]}
+		if len(rest) == 2 {
+			param.Path = rest[1]
+		}
+		if err := tinygit.SubmoduleAdd(param); err != nil {
+			fatal(err)
+		}
+	case "init":
+		paths := submodulePaths(args[1:], nil)
+		messages, err := tinygit.SubmoduleInit(paths)
+		if err != nil {
+			fatal(err)
+		}
+		for _, message := range messages {
+			fmt.Println(message)
+		}
+	case "update":
+		var param tinygit.SubmoduleUpdateParam
+		param.Paths = submodulePaths(args[1:], map[string]*bool{
+			"--init":  &param.Init,
+			"-f":      &param.Force,
+			"--force": &param.Force,
+		})
+		messages, err := tinygit.SubmoduleUpdate(param)
+		if err != nil {
+			fatal(err)
+		}
+		for _, message := range messages {
+			fmt.Println(message)
+		}
+	case "status":
+		submoduleStatus(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown subcommand: `%s`\n", args[0])
+		exit(129)
+	}
+}
+
+func submoduleStatus(args []string) {
+	statuses, err := tinygit.SubmoduleStatuses(submodulePaths(args, nil))
+	if err != nil {
+		fatal(err)
+	}
+	for _, status := range statuses {
+		fmt.Println(status)
+	}
+}
+
+// submodulePaths return the paths of the arguments, setting the flags of
+// the options.
+func submodulePaths(args []string, flags map[string]*bool) []string {
+	var paths []string
+	for i, arg := range args {
+		if arg == "--" {
+			return append(paths, args[i+1:]...)
+		}
+		if flag, ok := flags[arg]; ok {
+			*flag = true
+			continue
+		}
+		if strings.HasPrefix(arg, "-") {
+			fatal(fmt.Errorf("unknown option '%s'", arg))
+		}
+		paths = append(paths, arg)
+	}
+	return paths
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/startdusk/tinygit"
+)
+
+func updateIndex(args []string) {
+	var param tinygit.UpdateIndexParam
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--add":
+			param.Add = true
+		case arg == "--remove":
+			param.Remove = true
+		case arg == "--force-remove":
+			param.ForceRemove = true
+		case arg == "--refresh":
+			param.Refresh = true
+		case arg == "--assume-unchanged":
+			param.AssumeUnchanged = true
+		case arg == "--no-assume-unchanged":
+			param.NoAssumeUnchanged = true
+		case arg == "--skip-worktree":
+			param.SkipWorktree = true
+		case arg == "--no-skip-worktree":
+			param.NoSkipWorktree = true
+		case arg == "--split-index":
+			param.SplitIndex = true
+		case arg == "--no-split-index":
+			param.NoSplitIndex = true
+		case arg == "--index-info":
+			param.IndexInfo = os.Stdin
+		case strings.HasPrefix(arg, "--chmod="):
+			param.Chmod = strings.TrimPrefix(arg, "--chmod=")
+		case arg == "--cacheinfo":
+			if i+1 >= len(args) {
+				fatal(errors.New("option 'cacheinfo' expects <mode>,<sha1>,<path>"))
+			}
+			value := args[i+1]
+			i++
+			// the old "--cacheinfo <mode> <sha1> <path>" form
+			if !strings.Contains(value, ",") && i+2 < len(args) {
+				value = strings.Join([]string{value, args[i+1], args[i+2]}, ",")
+				i += 2
+			}
+			info, err := tinygit.ParseCacheInfo(value)
+			if err != nil {
+				fatal(err)
+			}
+			param.CacheInfo = append(param.CacheInfo, info)
+		case arg == "--":
+			param.Paths = append(param.Paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			fatal(fmt.Errorf("unknown option '%s'", arg))
+		default:
+			param.Paths = append(param.Paths, arg)
+		}
+	}
+	if err := tinygit.UpdateIndex(param); err != nil {
+		var pathErrs tinygit.PathErrors
+		if errors.As(err, &pathErrs) {
+			for _, err := range pathErrs {
+				fmt.Println(err)
+			}
+			exit(1)
+		}
+		fatal(err)
+	}
+}
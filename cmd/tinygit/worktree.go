@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/startdusk/tinygit"
+)
+
+func worktree(args []string) {
+	if len(args) == 0 {
+		fatal(errors.New("usage: tinygit worktree (add|list|remove|prune) [<options>]"))
+	}
+	switch args[0] {
+	case "add":
+		worktreeAdd(args[1:])
+	case "list":
+		worktrees, err := tinygit.WorktreeList()
+		if err != nil {
+			fatal(err)
+		}
+		// the paths are aligned like git does
+		width := 0
+		for _, w := range worktrees {
+			if len(w.Path) > width {
+				width = len(w.Path)
+			}
+		}
+		for _, w := range worktrees {
+			fmt.Printf("%-*s%s\n", width, w.Path, strings.TrimPrefix(w.String(), w.Path))
+		}
+	case "remove":
+		var param tinygit.WorktreeRemoveParam
+		for _, arg := range args[1:] {
+			switch {
+			case arg == "-f" || arg == "--force":
+				param.Force = true
+			case strings.HasPrefix(arg, "-"):
+				fatal(fmt.Errorf("unknown option '%s'", arg))
+			case param.Worktree != "":
+				fatal(errors.New("usage: tinygit worktree remove [-f] <worktree>"))
+			default:
+				param.Worktree = arg
+			}
+		}
+		if param.Worktree == "" {
+			fatal(errors.New("usage: tinygit worktree remove [-f] <worktree>"))
+		}
+		if err := tinygit.WorktreeRemove(param); err != nil {
+			fatal(err)
+		}
+	case "prune":
+		var param tinygit.WorktreePruneParam
+		verbose := false
+		for _, arg := range args[1:] {
+			switch arg {
+			case "-n", "--dry-run":
+				param.DryRun = true
+			case "-v", "--verbose":
+				verbose = true
+			default:
+				fatal(fmt.Errorf("unknown option '%s'", arg))
+			}
+		}
+		messages, err := tinygit.WorktreePrune(param)
+		if err != nil {
+			fatal(err)
+		}
+		if verbose || param.DryRun {
+			for _, message := range messages {
+				fmt.Println(message)
+			}
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown subcommand: `%s`\n", args[0])
+		exit(129)
+	}
+}
+
+func worktreeAdd(args []string) {
+	var param tinygit.WorktreeAddParam
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-b":
+			if i+1 >= len(args) {
+				fatal(errors.New("switch 'b' requires a value"))
+			}
+			param.NewBranch = args[i+1]
+			i++
+		case arg == "-f" || arg == "--force":
+			param.Force = true
+		case arg == "--detach":
+			param.Detach = true
+		case strings.HasPrefix(arg, "-"):
+			fatal(fmt.Errorf("unknown option '%s'", arg))
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if len(rest) == 0 || len(rest) > 2 {
+		fatal(errors.New("usage: tinygit worktree add [-b <new-branch>] [--detach] [-f] <path> [<commit-ish>]"))
+	}
+	param.Path = rest[0]
+	if len(rest) == 2 {
+		param.Commitish = rest[1]
+	}
+	message, err := tinygit.WorktreeAdd(param)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Println(message)
+}
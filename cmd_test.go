@@ -0,0 +1,384 @@
+package tinygit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func indexPaths(t *testing.T) []string {
+	t.Helper()
+	indexes, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	var paths []string
+	for _, index := range indexes {
+		paths = append(paths, index.Path)
+	}
+	return paths
+}
+
+func TestAdd(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{
+		"a.txt":     "a",
+		"dir/b.txt": "b",
+		"dir/c.txt": "c",
+	})
+
+	if err := Add(AddParam{Paths: []string{"."}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	want := []string{"a.txt", "dir/b.txt", "dir/c.txt"}
+	if got := indexPaths(t); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected index paths %v, but got %v", want, got)
+	}
+
+	// adding again must replace entries instead of duplicating them
+	writeFiles(t, map[string]string{"dir/b.txt": "bb"})
+	if err := Add(AddParam{Paths: []string{"dir"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	indexes, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	if len(indexes) != len(want) {
+		t.Fatalf("expected %d entries, but got %d", len(want), len(indexes))
+	}
+	sha1, _, _ := HashObject(HashParam{Data: []byte("bb"), ObjType: Blob})
+	if indexes[1].Sha1 != sha1 {
+		t.Fatalf("expected sha1 %s, but got %s", sha1, indexes[1].Sha1)
+	}
+	if _, err := ReadObject(sha1); err != nil {
+		t.Fatalf("read object: %+v", err)
+	}
+}
+
+func TestAddRemoval(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{
+		"a.txt":     "a",
+		"dir/b.txt": "b",
+	})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if err := os.RemoveAll("dir"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, map[string]string{"new.txt": "new"})
+
+	if err := Add(AddParam{Paths: []string{"."}, IgnoreRemoval: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	want := []string{"a.txt", "dir/b.txt", "new.txt"}
+	if got := indexPaths(t); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected index paths %v, but got %v", want, got)
+	}
+
+	if err := Add(AddParam{Paths: []string{"dir"}}); err != nil {
+		t.Fatalf("add removed dir: %+v", err)
+	}
+	want = []string{"a.txt", "new.txt"}
+	if got := indexPaths(t); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected index paths %v, but got %v", want, got)
+	}
+}
+
+func TestAddUpdate(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	if err := Add(AddParam{Paths: []string{"a.txt", "b.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if err := os.Remove("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, map[string]string{"c.txt": "c"})
+
+	if err := Add(AddParam{Update: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	want := []string{"a.txt"}
+	if got := indexPaths(t); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected index paths %v, but got %v", want, got)
+	}
+
+	if err := Add(AddParam{}); err == nil {
+		t.Fatal("expected error when nothing specified")
+	}
+	if err := Add(AddParam{Paths: []string{"missing"}}); err == nil {
+		t.Fatal("expected error for unmatched pathspec")
+	}
+}
+
+func TestAddStatCache(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a"})
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes("a.txt", past, past); err != nil {
+		t.Fatal(err)
+	}
+	if err := Add(AddParam{Paths: []string{"a.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+
+	// a bogus sha1 survives as long as the file state is unchanged
+	indexes, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	const bogus = "0000000000000000000000000000000000000000"
+	indexes[0].Sha1 = bogus
+	if err := WriteIndex(indexes); err != nil {
+		t.Fatalf("write index: %+v", err)
+	}
+	if err := Add(AddParam{Paths: []string{"a.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	indexes, err = ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	if indexes[0].Sha1 != bogus {
+		t.Fatalf("expected unchanged file not to be rehashed, but got sha1 %s", indexes[0].Sha1)
+	}
+
+	writeFiles(t, map[string]string{"a.txt": "changed"})
+	if err := Add(AddParam{Paths: []string{"a.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	indexes, err = ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	sha1, _, _ := HashObject(HashParam{Data: []byte("changed"), ObjType: Blob})
+	if indexes[0].Sha1 != sha1 {
+		t.Fatalf("expected sha1 %s, but got %s", sha1, indexes[0].Sha1)
+	}
+}
+
+func TestAddParallel(t *testing.T) {
+	setupRepo(t)
+	files := make(map[string]string)
+	var want []string
+	for i := 0; i < 100; i++ {
+		path := fmt.Sprintf("dir%d/file%03d.txt", i%7, i)
+		files[path] = fmt.Sprintf("content %d", i)
+		want = append(want, path)
+	}
+	sort.Strings(want)
+	writeFiles(t, files)
+
+	if err := Add(AddParam{Paths: []string{"."}, Jobs: 4}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	indexes, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	if len(indexes) != len(want) {
+		t.Fatalf("expected %d entries, but got %d", len(want), len(indexes))
+	}
+	for i, index := range indexes {
+		if index.Path != want[i] {
+			t.Fatalf("expected path %s at %d, but got %s", want[i], i, index.Path)
+		}
+		sha1, _, _ := HashObject(HashParam{Data: []byte(files[index.Path]), ObjType: Blob})
+		if index.Sha1 != sha1 {
+			t.Fatalf("expected sha1 %s for %s, but got %s", sha1, index.Path, index.Sha1)
+		}
+	}
+}
+
+func TestAddParallelErrors(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a", "c.txt": "c"})
+	// sockets can not be read as file content
+	for _, name := range []string{"b.sock", "d.sock"} {
+		l, err := net.Listen("unix", name)
+		if err != nil {
+			t.Skipf("listen: %v", err)
+		}
+		defer l.Close()
+	}
+
+	err := Add(AddParam{Paths: []string{"."}, Jobs: 4})
+	var pathErrs PathErrors
+	if !errors.As(err, &pathErrs) {
+		t.Fatalf("expected path errors, but got %+v", err)
+	}
+	if len(pathErrs) != 2 {
+		t.Fatalf("expected 2 errors, but got %d: %v", len(pathErrs), pathErrs)
+	}
+	for i, prefix := range []string{"b.sock: ", "d.sock: "} {
+		if !strings.HasPrefix(pathErrs[i].Error(), prefix) {
+			t.Fatalf("expected error %d to start with %q, but got %q", i, prefix, pathErrs[i])
+		}
+	}
+	if got := indexPaths(t); len(got) != 0 {
+		t.Fatalf("expected index untouched on error, but got %v", got)
+	}
+}
+
+func TestAddIntentToAdd(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a"})
+	if err := Add(AddParam{Paths: []string{"a.txt"}, IntentToAdd: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	indexes, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	if len(indexes) != 1 || !indexes[0].IntentToAdd() || indexes[0].Sha1 != emptyBlobSha1 {
+		t.Fatalf("expected an intent-to-add entry, but got %+v", indexes)
+	}
+	if obj, err := ReadObject(emptyBlobSha1); err != nil || obj.Type != Blob || len(obj.Data) != 0 {
+		t.Fatalf("expected the empty blob to be stored, but got %+v, %v", obj, err)
+	}
+	if got, want := mustLsFiles(t, LsFilesParam{Modified: true}), []string{"a.txt"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected modified %v, but got %v", want, got)
+	}
+
+	if err := Add(AddParam{Paths: []string{"a.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	indexes, err = ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	if len(indexes) != 1 || indexes[0].IntentToAdd() || indexes[0].Sha1 == emptyBlobSha1 {
+		t.Fatalf("expected the content to be added, but got %+v", indexes)
+	}
+}
+
+func TestAddHonorsEntryBits(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"gen.txt": "gen", "sparse.txt": "sparse"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if err := UpdateIndex(UpdateIndexParam{Paths: []string{"gen.txt"}, AssumeUnchanged: true}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	if err := UpdateIndex(UpdateIndexParam{Paths: []string{"sparse.txt"}, SkipWorktree: true}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	writeFiles(t, map[string]string{"gen.txt": "regenerated"})
+	if err := os.Remove("sparse.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := mustLsFiles(t, LsFilesParam{Modified: true}); len(got) != 0 {
+		t.Fatalf("expected no modified files, but got %v", got)
+	}
+	want := []string{"h gen.txt", "S sparse.txt"}
+	if got := mustLsFiles(t, LsFilesParam{Verbose: true}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	indexes, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	sha1, _, _ := HashObject(HashParam{Data: []byte("gen"), ObjType: Blob})
+	if len(indexes) != 2 || indexes[0].Sha1 != sha1 || !indexes[1].SkipWorktree() {
+		t.Fatalf("expected entries to be kept, but got %+v", indexes)
+	}
+
+	if err := UpdateIndex(UpdateIndexParam{Paths: []string{"gen.txt"}, NoAssumeUnchanged: true}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	if got, want := mustLsFiles(t, LsFilesParam{Modified: true}), []string{"gen.txt"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected modified %v, but got %v", want, got)
+	}
+	if err := UpdateIndex(UpdateIndexParam{Paths: []string{"missing"}, SkipWorktree: true}); err == nil {
+		t.Fatal("expected error marking an untracked path")
+	}
+}
+
+func mustLsFiles(t *testing.T, param LsFilesParam) []string {
+	t.Helper()
+	lines, err := LsFiles(param)
+	if err != nil {
+		t.Fatalf("ls-files: %+v", err)
+	}
+	return lines
+}
+
+func TestAddSymlinkAndExecutable(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a", "run.sh": "#!/bin/sh\n"})
+	if err := os.Chmod("run.sh", 0755); err != nil {
+		t.Fatal(err)
+	}
+	// links are recorded as is, even when dangling
+	if err := os.Symlink("a.txt", "a.link"); err != nil {
+		t.Skipf("symlink: %v", err)
+	}
+	if err := os.Symlink("missing", "b.link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	indexes, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	want := map[string]struct {
+		mode uint16
+		data string
+	}{
+		"a.link": {ModeSymlink, "a.txt"},
+		"a.txt":  {ModeRegular, "a"},
+		"b.link": {ModeSymlink, "missing"},
+		"run.sh": {ModeExecutable, "#!/bin/sh\n"},
+	}
+	if len(indexes) != len(want) {
+		t.Fatalf("expected %d entries, but got %+v", len(want), indexes)
+	}
+	for _, index := range indexes {
+		sha1, _, _ := HashObject(HashParam{Data: []byte(want[index.Path].data), ObjType: Blob})
+		if index.Mode != want[index.Path].mode || index.Sha1 != sha1 {
+			t.Fatalf("%s: expected mode %o and sha1 %s, but got %o and %s", index.Path, want[index.Path].mode, sha1, index.Mode, index.Sha1)
+		}
+	}
+	report, err := Status(StatusParam{})
+	if err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	if got := report.Short(false); !reflect.DeepEqual(got, []string{"A  a.link", "A  a.txt", "A  b.link", "A  run.sh"}) {
+		t.Fatalf("unexpected status %q", got)
+	}
+
+	// checkout recreates the links and the executable bit
+	for _, name := range []string{"a.link", "b.link", "run.sh"} {
+		if err := os.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Restore(RestoreParam{Paths: []string{"."}}); err != nil {
+		t.Fatalf("restore: %+v", err)
+	}
+	for name, target := range map[string]string{"a.link": "a.txt", "b.link": "missing"} {
+		if got, err := os.Readlink(name); err != nil || got != target {
+			t.Fatalf("expected %s to link to %s, but got %q, %v", name, target, got, err)
+		}
+	}
+	if info, err := os.Stat("run.sh"); err != nil || info.Mode().Perm() != 0755 {
+		t.Fatalf("expected run.sh to be executable, but got %v, %v", info, err)
+	}
+}
@@ -0,0 +1,106 @@
+package tinygit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/startdusk/tinygit/shared/refs"
+)
+
+// commitObject represents the content of a commit object.
+type commitObject struct {
+	Tree      string
+	Parents   []string
+	Author    string
+	Committer string
+	Message   string
+}
+
+// parseCommit parse the headers and the message of a commit object.
+func parseCommit(data []byte) (commitObject, error) {
+	var c commitObject
+	header, message, _ := bytes.Cut(data, []byte("\n\n"))
+	c.Message = string(message)
+	for _, line := range strings.Split(string(header), "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "tree":
+			c.Tree = value
+		case "parent":
+			c.Parents = append(c.Parents, value)
+		case "author":
+			c.Author = value
+		case "committer":
+			c.Committer = value
+		}
+	}
+	if !refs.IsSha1(c.Tree) {
+		return c, fmt.Errorf("invalid commit tree '%s'", c.Tree)
+	}
+	return c, nil
+}
+
+// readCommit read the commit object with given SHA-1 prefix.
+func readCommit(sha1Prefix string) (commitObject, error) {
+	obj, err := ReadObject(sha1Prefix)
+	if err != nil {
+		return commitObject{}, err
+	}
+	if obj.Type != Commit {
+		return commitObject{}, fmt.Errorf("object %s is a %s, not a commit", sha1Prefix, obj.Type)
+	}
+	return parseCommit(obj.Data)
+}
+
+// Bytes format the commit object data.
+func (c commitObject) Bytes() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "tree %s\n", c.Tree)
+	for _, parent := range c.Parents {
+		fmt.Fprintf(&buf, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&buf, "author %s\n", c.Author)
+	fmt.Fprintf(&buf, "committer %s\n", c.Committer)
+	buf.WriteString("\n")
+	buf.WriteString(c.Message)
+	return buf.Bytes()
+}
+
+// writeCommit write the commit object to the object store and return its sha1.
+func writeCommit(c commitObject) (string, error) {
+	sha1, _, err := HashObject(HashParam{Data: c.Bytes(), ObjType: Commit, WriteFile: true})
+	return sha1, err
+}
+
+// signature return the identity of the user followed by the current time,
+// the format of the author and committer headers. The identity comes from
+// user.name and user.email, or from the environment when they are not set.
+func signature() (string, error) {
+	config, err := ReadConfig()
+	if err != nil {
+		return "", err
+	}
+	name, ok := config.Get("user.name")
+	if !ok {
+		name = os.Getenv("USER")
+	}
+	if name == "" {
+		name = "unknown"
+	}
+	email, ok := config.Get("user.email")
+	if !ok {
+		host, _ := os.Hostname()
+		email = name + "@" + host
+	}
+	now := time.Now()
+	return fmt.Sprintf("%s <%s> %d %s", name, email, now.Unix(), now.Format("-0700")), nil
+}
+
+// subject return the first line of the commit message.
+func (c commitObject) subject() string {
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	return subject
+}
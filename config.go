@@ -0,0 +1,264 @@
+package tinygit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func configFile() string {
+	return filepath.Join(commonDir(), "config")
+}
+
+// Config represents the repository configuration, stored in the Git config
+// file format. Keys are written "section.name" or "section.subsection.name".
+type Config struct {
+	sections []*configSection
+}
+
+type configSection struct {
+	name       string
+	subsection string
+	entries    []configEntry
+}
+
+type configEntry struct {
+	name  string
+	value string
+}
+
+// splitConfigKey split the key into its lowercase section, its subsection and
+// its lowercase name.
+func splitConfigKey(key string) (string, string, string, error) {
+	first := strings.Index(key, ".")
+	last := strings.LastIndex(key, ".")
+	if first <= 0 || last == len(key)-1 {
+		return "", "", "", fmt.Errorf("key does not contain a section: %s", key)
+	}
+	section := strings.ToLower(key[:first])
+	name := strings.ToLower(key[last+1:])
+	var subsection string
+	if first != last {
+		subsection = key[first+1 : last]
+	}
+	return section, subsection, name, nil
+}
+
+func (c *Config) section(section, subsection string) *configSection {
+	for _, s := range c.sections {
+		if s.name == section && s.subsection == subsection {
+			return s
+		}
+	}
+	return nil
+}
+
+// Get return the last value of key.
+func (c *Config) Get(key string) (string, bool) {
+	section, subsection, name, err := splitConfigKey(key)
+	if err != nil {
+		return "", false
+	}
+	var (
+		value string
+		found bool
+	)
+	for _, s := range c.sections {
+		if s.name != section || s.subsection != subsection {
+			continue
+		}
+		for _, e := range s.entries {
+			if e.name == name {
+				value, found = e.value, true
+			}
+		}
+	}
+	return value, found
+}
+
+// GetBool return the boolean value of key, or def when the key is not set.
+func (c *Config) GetBool(key string, def bool) (bool, error) {
+	value, ok := c.Get(key)
+	if !ok {
+		return def, nil
+	}
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("bad boolean config value '%s' for '%s'", value, key)
+}
+
+// GetInt return the integer value of key, or def when the key is not set.
+func (c *Config) GetInt(key string, def int) (int, error) {
+	value, ok := c.Get(key)
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("bad numeric config value '%s' for '%s'", value, key)
+	}
+	return n, nil
+}
+
+// Subsections return the subsection names of section in file order.
+func (c *Config) Subsections(section string) []string {
+	section = strings.ToLower(section)
+	var names []string
+	seen := make(map[string]bool)
+	for _, s := range c.sections {
+		if s.name == section && s.subsection != "" && !seen[s.subsection] {
+			seen[s.subsection] = true
+			names = append(names, s.subsection)
+		}
+	}
+	return names
+}
+
+// Set set the value of key, replacing its existing value.
+func (c *Config) Set(key, value string) error {
+	section, subsection, name, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+	s := c.section(section, subsection)
+	if s == nil {
+		s = &configSection{name: section, subsection: subsection}
+		c.sections = append(c.sections, s)
+	}
+	for i := range s.entries {
+		if s.entries[i].name == name {
+			s.entries[i].value = value
+			return nil
+		}
+	}
+	s.entries = append(s.entries, configEntry{name: name, value: value})
+	return nil
+}
+
+// Unset remove key from the configuration.
+func (c *Config) Unset(key string) error {
+	section, subsection, name, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+	for _, s := range c.sections {
+		if s.name != section || s.subsection != subsection {
+			continue
+		}
+		entries := s.entries[:0]
+		for _, e := range s.entries {
+			if e.name != name {
+				entries = append(entries, e)
+			}
+		}
+		s.entries = entries
+	}
+	return nil
+}
+
+// Bytes render the configuration in the Git config file format.
+func (c *Config) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, s := range c.sections {
+		if len(s.entries) == 0 {
+			continue
+		}
+		if s.subsection == "" {
+			fmt.Fprintf(&buf, "[%s]\n", s.name)
+		} else {
+			fmt.Fprintf(&buf, "[%s %s]\n", s.name, strconv.Quote(s.subsection))
+		}
+		for _, e := range s.entries {
+			value := e.value
+			if value != strings.TrimSpace(value) || strings.ContainsAny(value, "#;\"\\") {
+				value = strconv.Quote(value)
+			}
+			fmt.Fprintf(&buf, "\t%s = %s\n", e.name, value)
+		}
+	}
+	return buf.Bytes()
+}
+
+// ParseConfig parse a Git style config file.
+func ParseConfig(data []byte) (*Config, error) {
+	c := &Config{}
+	var current *configSection
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("bad config line %d", n)
+			}
+			header := line[1:end]
+			name, subsection, _ := strings.Cut(header, " ")
+			subsection = strings.TrimSpace(subsection)
+			if subsection != "" {
+				unquoted, err := strconv.Unquote(subsection)
+				if err != nil {
+					return nil, fmt.Errorf("bad config line %d", n)
+				}
+				subsection = unquoted
+			}
+			name = strings.ToLower(name)
+			current = c.section(name, subsection)
+			if current == nil {
+				current = &configSection{name: name, subsection: subsection}
+				c.sections = append(c.sections, current)
+			}
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("bad config line %d", n)
+		}
+		name, value, hasValue := strings.Cut(line, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if !hasValue {
+			value = "true"
+		}
+		if strings.HasPrefix(value, "\"") {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("bad config line %d", n)
+			}
+			value = unquoted
+		} else if i := strings.IndexAny(value, "#;"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		current.entries = append(current.entries, configEntry{name: name, value: value})
+	}
+	return c, scanner.Err()
+}
+
+// ReadConfig read the repository configuration, an absent file is an empty
+// configuration.
+func ReadConfig() (*Config, error) {
+	data, err := os.ReadFile(configFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	return ParseConfig(data)
+}
+
+// WriteConfig write the repository configuration.
+func WriteConfig(c *Config) error {
+	return os.WriteFile(configFile(), c.Bytes(), 0644)
+}
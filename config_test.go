@@ -0,0 +1,71 @@
+package tinygit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	data := []byte(`# comment
+[core]
+	splitIndex = true
+	bare
+[splitIndex]
+	maxPercentChange = 50 ; trailing comment
+[filter "Secret Scrub"]
+	clean = "scrub --in"
+`)
+	c, err := ParseConfig(data)
+	if err != nil {
+		t.Fatalf("parse config: %+v", err)
+	}
+
+	if split, err := c.GetBool("core.splitindex", false); err != nil || !split {
+		t.Fatalf("expected core.splitIndex true, but got %v, %v", split, err)
+	}
+	if bare, err := c.GetBool("core.bare", false); err != nil || !bare {
+		t.Fatalf("expected core.bare true, but got %v, %v", bare, err)
+	}
+	if n, err := c.GetInt("splitIndex.maxPercentChange", 20); err != nil || n != 50 {
+		t.Fatalf("expected 50, but got %d, %v", n, err)
+	}
+	if n, err := c.GetInt("splitIndex.missing", 20); err != nil || n != 20 {
+		t.Fatalf("expected default 20, but got %d, %v", n, err)
+	}
+	if value, ok := c.Get("filter.Secret Scrub.clean"); !ok || value != "scrub --in" {
+		t.Fatalf("expected subsection value, but got %q, %v", value, ok)
+	}
+	if _, ok := c.Get("filter.secret scrub.clean"); ok {
+		t.Fatal("expected subsection names to be case sensitive")
+	}
+	if got, want := c.Subsections("filter"), []string{"Secret Scrub"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected subsections %v, but got %v", want, got)
+	}
+
+	if err := c.Set("core.splitIndex", "false"); err != nil {
+		t.Fatalf("set: %+v", err)
+	}
+	if err := c.Set("user.name", " padded "); err != nil {
+		t.Fatalf("set: %+v", err)
+	}
+	if err := c.Unset("core.bare"); err != nil {
+		t.Fatalf("unset: %+v", err)
+	}
+	if err := c.Set("nosection", "x"); err == nil {
+		t.Fatal("expected error for key without section")
+	}
+
+	reparsed, err := ParseConfig(c.Bytes())
+	if err != nil {
+		t.Fatalf("parse config: %+v", err)
+	}
+	if !reflect.DeepEqual(reparsed, c) {
+		t.Fatalf("expected %s, but got %s", c.Bytes(), reparsed.Bytes())
+	}
+	if value, _ := reparsed.Get("user.name"); value != " padded " {
+		t.Fatalf("expected quoted value to round trip, but got %q", value)
+	}
+	if _, ok := reparsed.Get("core.bare"); ok {
+		t.Fatal("expected core.bare to be unset")
+	}
+}
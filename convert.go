@@ -0,0 +1,226 @@
+package tinygit
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+)
+
+// textConversion is how the line endings of a file are converted between
+// the working tree and its blob.
+type textConversion int
+
+const (
+	// convertNone keeps the content as is.
+	convertNone textConversion = iota
+	// convertText always converts the content as text.
+	convertText
+	// convertAuto converts the content unless it looks binary.
+	convertAuto
+)
+
+// binaryCheckSize is the length of the content looked at to decide whether
+// it is binary.
+const binaryCheckSize = 8000
+
+// isBinary report whether the content looks binary: it holds a NUL byte, a
+// carriage return not followed by a line feed, or too many control
+// characters.
+func isBinary(data []byte) bool {
+	if len(data) > binaryCheckSize {
+		data = data[:binaryCheckSize]
+	}
+	printable, nonPrintable := 0, 0
+	for i, c := range data {
+		switch {
+		case c == 0:
+			return true
+		case c == '\r':
+			if i+1 >= len(data) || data[i+1] != '\n' {
+				return true
+			}
+		case c == 127 || c < 32 && c != '\b' && c != '\t' && c != '\n' && c != '\f' && c != '\033':
+			nonPrintable++
+		default:
+			printable++
+		}
+	}
+	return printable>>7 < nonPrintable
+}
+
+// convertFilter converts file content between the working tree and blobs
+// following the text and eol attributes and the core.autocrlf and core.eol
+// settings.
+type convertFilter struct {
+	config *Config
+	attrs  *attributeRules
+	// drivers caches the filter driver configured for each name.
+	mu      sync.Mutex
+	drivers map[string]*filterDriver
+	// autocrlf is "true", "input" or "false" and eol "lf", "crlf" or
+	// "native".
+	autocrlf string
+	eol      string
+}
+
+// newConvertFilter create the filter of the repository settings. A command
+// creates it once and passes it to every file it reads or writes.
+func newConvertFilter() (*convertFilter, error) {
+	config, err := ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := newAttributeRules(config)
+	if err != nil {
+		return nil, err
+	}
+	f := &convertFilter{
+		config:   config,
+		attrs:    attrs,
+		drivers:  make(map[string]*filterDriver),
+		autocrlf: "false",
+		eol:      "native",
+	}
+	if value, ok := config.Get("core.autocrlf"); ok {
+		f.autocrlf = value
+		if value != "input" {
+			if set, err := config.GetBool("core.autocrlf", false); err == nil && set {
+				f.autocrlf = "true"
+			} else {
+				f.autocrlf = "false"
+			}
+		}
+	}
+	if value, ok := config.Get("core.eol"); ok {
+		f.eol = value
+	}
+	return f, nil
+}
+
+// conversion return how the file with the attributes is converted and the
+// line ending it has in the working tree, "lf" or "crlf".
+func (f *convertFilter) conversion(attrs map[string]string) (textConversion, string) {
+	conversion := convertNone
+	switch attrs["text"] {
+	case AttributeSet:
+		conversion = convertText
+	case "auto":
+		conversion = convertAuto
+	case AttributeUnset:
+		return convertNone, "lf"
+	case "":
+		switch {
+		// an eol attribute makes the file text
+		case attrs["eol"] == "lf" || attrs["eol"] == "crlf":
+			conversion = convertText
+		case f.autocrlf == "true" || f.autocrlf == "input":
+			conversion = convertAuto
+		}
+	}
+	switch {
+	case attrs["eol"] == "lf" || attrs["eol"] == "crlf":
+		return conversion, attrs["eol"]
+	case f.autocrlf == "true":
+		return conversion, "crlf"
+	case f.autocrlf == "input":
+		return conversion, "lf"
+	case f.eol == "crlf" || f.eol == "native" && runtime.GOOS == "windows":
+		return conversion, "crlf"
+	}
+	return conversion, "lf"
+}
+
+// runDriver pass the content through the filter driver named by the filter
+// attribute, if any, in the direction. The lfs filter is built in unless it
+// is configured.
+func (f *convertFilter) runDriver(attrs map[string]string, direction, rel string, data []byte) ([]byte, error) {
+	name := attrs["filter"]
+	if name == "" || name == AttributeSet || name == AttributeUnset {
+		return data, nil
+	}
+	driver, err := f.driver(name)
+	switch {
+	case err != nil:
+		return nil, err
+	case driver != nil:
+		return driver.run(direction, rel, data)
+	case name == lfsFilterName && direction == filterClean:
+		return lfsClean(bytes.NewReader(data))
+	case name == lfsFilterName:
+		return lfsSmudge(data)
+	}
+	return data, nil
+}
+
+// driver return the filter driver configured for the name, nil when it is
+// not configured.
+func (f *convertFilter) driver(name string) (*filterDriver, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if driver, ok := f.drivers[name]; ok {
+		return driver, nil
+	}
+	driver, ok, err := readFilterDriver(f.config, name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		driver = nil
+	}
+	f.drivers[name] = driver
+	return driver, nil
+}
+
+// builtinLFS report whether the file is cleaned by the built in lfs filter,
+// which reads the working tree file as a stream.
+func (f *convertFilter) builtinLFS(rel string) (bool, error) {
+	attrs, err := f.attrs.attributes(rel)
+	if err != nil || attrs["filter"] != lfsFilterName {
+		return false, err
+	}
+	driver, err := f.driver(lfsFilterName)
+	return driver == nil, err
+}
+
+// toBlob convert the working tree content of the file to its blob content,
+// running the clean filter then normalizing CRLF line endings of text to LF.
+func (f *convertFilter) toBlob(rel string, data []byte) ([]byte, error) {
+	attrs, err := f.attrs.attributes(rel)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = f.runDriver(attrs, filterClean, rel, data); err != nil {
+		return nil, err
+	}
+	conversion, _ := f.conversion(attrs)
+	if conversion == convertNone || conversion == convertAuto && isBinary(data) ||
+		!bytes.Contains(data, []byte("\r\n")) {
+		return data, nil
+	}
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), nil
+}
+
+// toWorktree convert the blob content of the file to its working tree
+// content, writing CRLF line endings when the file is text checked out with
+// CRLF then running the smudge filter. Content already holding carriage
+// returns is kept as is when the conversion is automatic.
+func (f *convertFilter) toWorktree(rel string, data []byte) ([]byte, error) {
+	attrs, err := f.attrs.attributes(rel)
+	if err != nil {
+		return nil, err
+	}
+	conversion, eol := f.conversion(attrs)
+	if conversion == convertNone || eol != "crlf" || !bytes.Contains(data, []byte("\n")) ||
+		conversion == convertAuto && (isBinary(data) || bytes.Contains(data, []byte("\r"))) {
+		return f.runDriver(attrs, filterSmudge, rel, data)
+	}
+	var b bytes.Buffer
+	b.Grow(len(data) + bytes.Count(data, []byte("\n")))
+	for i, c := range data {
+		if c == '\n' && (i == 0 || data[i-1] != '\r') {
+			b.WriteByte('\r')
+		}
+		b.WriteByte(c)
+	}
+	return f.runDriver(attrs, filterSmudge, rel, b.Bytes())
+}
@@ -0,0 +1,143 @@
+package tinygit
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIsBinary(t *testing.T) {
+	cases := []struct {
+		data string
+		want bool
+	}{
+		{data: "", want: false},
+		{data: "text\n", want: false},
+		{data: "crlf\r\ntext\r\n", want: false},
+		{data: "tab\tand\fform feed\033[0m\n", want: false},
+		{data: "nul\x00byte", want: true},
+		{data: "lone\rcarriage return", want: true},
+		{data: "\x01\x02\x03", want: true},
+	}
+	for _, c := range cases {
+		if got := isBinary([]byte(c.data)); got != c.want {
+			t.Errorf("%q: expected %v, but got %v", c.data, c.want, got)
+		}
+	}
+}
+
+func TestConvertLineEndings(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{
+		".gitattributes": "*.txt text\n*.bat text eol=crlf\n*.bin binary\n*.auto text=auto\n",
+		"a.txt":          "one\r\ntwo\r\n",
+		"run.bat":        "echo\r\n",
+		"data.bin":       "raw\r\n",
+		"plain.auto":     "x\r\ny\r\n",
+		"image.auto":     "x\r\n\x00",
+		"none.c":         "keep\r\n",
+	})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	// the blobs hold LF line endings unless the file is not text
+	blobs := map[string]string{
+		"a.txt":      "one\ntwo\n",
+		"run.bat":    "echo\n",
+		"data.bin":   "raw\r\n",
+		"plain.auto": "x\ny\n",
+		"image.auto": "x\r\n\x00",
+		"none.c":     "keep\r\n",
+	}
+	indexes, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	for p, content := range blobs {
+		i, ok := indexes.Find(p)
+		if !ok {
+			t.Fatalf("expected %s to be added", p)
+		}
+		obj, err := ReadObject(indexes[i].Sha1)
+		if err != nil || string(obj.Data) != content {
+			t.Fatalf("%s: expected blob %q, but got %q, %v", p, content, obj.Data, err)
+		}
+	}
+	// the files stay unchanged for status
+	report, err := Status(StatusParam{})
+	if err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	for _, line := range report.Short(false) {
+		if line[1] != ' ' {
+			t.Fatalf("expected no unstaged change, but got %q", line)
+		}
+	}
+
+	// checkout writes the line endings of the eol settings
+	for p := range blobs {
+		if err := os.Remove(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Restore(RestoreParam{Paths: []string{"."}}); err != nil {
+		t.Fatalf("restore: %+v", err)
+	}
+	assertFiles(t, map[string]string{
+		"a.txt":      "one\ntwo\n",
+		"run.bat":    "echo\r\n",
+		"data.bin":   "raw\r\n",
+		"plain.auto": "x\ny\n",
+		"image.auto": "x\r\n\x00",
+	})
+
+	config, _ := ReadConfig()
+	config.Set("core.eol", "crlf")
+	if err := WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(RestoreParam{Paths: []string{"a.txt"}}); err != nil {
+		t.Fatalf("restore: %+v", err)
+	}
+	assertFiles(t, map[string]string{"a.txt": "one\r\ntwo\r\n"})
+}
+
+func TestConvertAutocrlf(t *testing.T) {
+	setupRepo(t)
+	config, _ := ReadConfig()
+	config.Set("core.autocrlf", "true")
+	if err := WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, map[string]string{"a.c": "int a;\r\nint b;\r\n", "mixed.c": "a\nb\r\n"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if err := os.Remove("a.c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(RestoreParam{Paths: []string{"a.c"}}); err != nil {
+		t.Fatalf("restore: %+v", err)
+	}
+	assertFiles(t, map[string]string{"a.c": "int a;\r\nint b;\r\n"})
+	indexes, _ := ReadIndex()
+	i, _ := indexes.Find("mixed.c")
+	if obj, _ := ReadObject(indexes[i].Sha1); string(obj.Data) != "a\nb\n" {
+		t.Fatalf("expected mixed.c to be normalized, but got %q", obj.Data)
+	}
+
+	// input only normalizes on the way in
+	config.Set("core.autocrlf", "input")
+	if err := WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove("a.c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(RestoreParam{Paths: []string{"a.c"}}); err != nil {
+		t.Fatalf("restore: %+v", err)
+	}
+	assertFiles(t, map[string]string{"a.c": "int a;\nint b;\n"})
+}
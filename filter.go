@@ -0,0 +1,316 @@
+package tinygit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/startdusk/tinygit/shared/pktline"
+)
+
+// Directions of a filter driver, clean runs on the way to a blob and smudge
+// on the way to the working tree.
+const (
+	filterClean  = "clean"
+	filterSmudge = "smudge"
+)
+
+// errFilterUnsupported is returned by a process filter which does not have
+// the capability asked for.
+var errFilterUnsupported = errors.New("filter capability not supported")
+
+// errFilterStopped is returned by a process filter stopped meanwhile.
+var errFilterStopped = errors.New("filter process stopped")
+
+// filterStatusError is a command the process filter reports as failed.
+type filterStatusError struct {
+	status string
+}
+
+func (e *filterStatusError) Error() string {
+	return fmt.Sprintf("filter process returned status '%s'", e.status)
+}
+
+// filterDriver is a filter configured by filter.<name>.clean,
+// filter.<name>.smudge, filter.<name>.process and filter.<name>.required.
+// A process filter takes precedence over the clean and smudge commands.
+type filterDriver struct {
+	name     string
+	clean    string
+	smudge   string
+	process  string
+	required bool
+}
+
+// readFilterDriver return the driver configured for the name, false when it
+// is not configured.
+func readFilterDriver(config *Config, name string) (*filterDriver, bool, error) {
+	d := &filterDriver{name: name}
+	d.clean, _ = config.Get("filter." + name + ".clean")
+	d.smudge, _ = config.Get("filter." + name + ".smudge")
+	d.process, _ = config.Get("filter." + name + ".process")
+	required, err := config.GetBool("filter."+name+".required", false)
+	if err != nil {
+		return nil, false, err
+	}
+	d.required = required
+	return d, d.clean != "" || d.smudge != "" || d.process != "" || required, nil
+}
+
+// run pass the content of the repo relative path through the filter in the
+// direction. A failing filter which is not required leaves the content as is.
+func (d *filterDriver) run(direction, rel string, data []byte) ([]byte, error) {
+	var (
+		out []byte
+		err error
+	)
+	command := d.clean
+	if direction == filterSmudge {
+		command = d.smudge
+	}
+	switch {
+	case d.process != "":
+		out, err = runFilterProcess(d.process, direction, rel, data)
+	case command != "":
+		out, err = runFilterCommand(command, rel, data)
+	default:
+		err = errFilterUnsupported
+	}
+	if err == nil {
+		return out, nil
+	}
+	if d.required {
+		return nil, fmt.Errorf("%s: %s filter '%s' failed: %w", rel, direction, d.name, err)
+	}
+	return data, nil
+}
+
+// shellQuote quote the string for the shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runFilterCommand run the shell command with the content on its standard
+// input and return its standard output, "%f" in the command is replaced by
+// the quoted path.
+func runFilterCommand(command, rel string, data []byte) ([]byte, error) {
+	cmd := exec.Command("sh", "-c", strings.ReplaceAll(command, "%f", shellQuote(rel)))
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
+
+// filterProcess is a running long-running filter process, talking the
+// version 2 of the Git filter protocol over pkt-lines.
+type filterProcess struct {
+	mu           sync.Mutex
+	cmd          *exec.Cmd
+	stdin        io.WriteCloser
+	w            *pktline.Writer
+	r            *pktline.Reader
+	capabilities map[string]bool
+	stopped      bool
+}
+
+// filterProcesses holds the running filter processes by working directory
+// and command, they are started on first use and live until
+// StopFilterProcesses.
+var filterProcesses = struct {
+	sync.Mutex
+	m map[string]*filterProcess
+}{m: make(map[string]*filterProcess)}
+
+// startFilterProcess start the process command and negotiate the protocol
+// version and the capabilities.
+func startFilterProcess(command string) (*filterProcess, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &filterProcess{
+		cmd:          cmd,
+		stdin:        stdin,
+		w:            pktline.NewWriter(stdin),
+		r:            pktline.NewReader(stdout),
+		capabilities: make(map[string]bool),
+	}
+	if err := p.handshake(); err != nil {
+		p.stop()
+		return nil, fmt.Errorf("initialization for external filter '%s' failed: %w", command, err)
+	}
+	return p, nil
+}
+
+// handshake exchange the welcome messages and the capabilities.
+func (p *filterProcess) handshake() error {
+	if err := p.w.WriteLines("git-filter-client", "version=2"); err != nil {
+		return err
+	}
+	lines, err := p.r.ReadLines()
+	if err != nil {
+		return err
+	}
+	if len(lines) != 2 || lines[0] != "git-filter-server" || lines[1] != "version=2" {
+		return fmt.Errorf("unexpected welcome %q", lines)
+	}
+	if err := p.w.WriteLines("capability="+filterClean, "capability="+filterSmudge); err != nil {
+		return err
+	}
+	if lines, err = p.r.ReadLines(); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if capability := strings.TrimPrefix(line, "capability="); capability != line {
+			p.capabilities[capability] = true
+		}
+	}
+	return nil
+}
+
+// StopFilterProcesses close the standard input of the running filter
+// processes and wait for their exit, it is called before tinygit exits so no
+// process is left writing.
+func StopFilterProcesses() {
+	filterProcesses.Lock()
+	running := filterProcesses.m
+	filterProcesses.m = make(map[string]*filterProcess)
+	filterProcesses.Unlock()
+	for _, p := range running {
+		p.mu.Lock()
+		p.stop()
+		p.mu.Unlock()
+	}
+}
+
+// stop close the standard input of the process and wait for its exit. The
+// caller holds p.mu once the process is shared.
+func (p *filterProcess) stop() {
+	if p.stopped {
+		return
+	}
+	p.stopped = true
+	p.stdin.Close()
+	p.cmd.Wait()
+}
+
+// filter send the content of the path to the process for the command and
+// return the filtered content. A process failing to talk the protocol is
+// stopped.
+func (p *filterProcess) filter(command, rel string, data []byte) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return nil, errFilterStopped
+	}
+	out, err := p.request(command, rel, data)
+	if isFilterProtocolError(err) {
+		p.stop()
+	}
+	return out, err
+}
+
+// isFilterProtocolError report whether the error is a failure to talk the
+// protocol, rather than a command the process refused.
+func isFilterProtocolError(err error) bool {
+	var statusErr *filterStatusError
+	return err != nil && !errors.Is(err, errFilterUnsupported) && !errors.Is(err, errFilterStopped) &&
+		!errors.As(err, &statusErr)
+}
+
+// request send a command to the process and read its answer.
+func (p *filterProcess) request(command, rel string, data []byte) ([]byte, error) {
+	if !p.capabilities[command] {
+		return nil, errFilterUnsupported
+	}
+	if err := p.w.WriteLines("command="+command, "pathname="+rel); err != nil {
+		return nil, err
+	}
+	if err := p.w.WriteData(data); err != nil {
+		return nil, err
+	}
+	status, err := p.readStatus("")
+	if err != nil || status != "success" {
+		return nil, p.statusError(command, status, err)
+	}
+	out, err := p.r.ReadData()
+	if err != nil {
+		return nil, err
+	}
+	// the status may change after the content, an empty list keeps it
+	if status, err = p.readStatus(status); err != nil || status != "success" {
+		return nil, p.statusError(command, status, err)
+	}
+	return out, nil
+}
+
+// readStatus read a list of "status=<status>" lines and return the last
+// status, current when the list is empty.
+func (p *filterProcess) readStatus(current string) (string, error) {
+	lines, err := p.r.ReadLines()
+	if err != nil {
+		return "", err
+	}
+	for _, line := range lines {
+		if status := strings.TrimPrefix(line, "status="); status != line {
+			current = status
+		}
+	}
+	return current, nil
+}
+
+// statusError return the error of a failed command, an aborted command is
+// no longer sent to the process.
+func (p *filterProcess) statusError(command, status string, err error) error {
+	if err != nil {
+		return err
+	}
+	if status == "abort" {
+		p.capabilities[command] = false
+	}
+	return &filterStatusError{status: status}
+}
+
+// runFilterProcess filter the content with the process of the command,
+// starting it when it does not run yet. A process failing to talk the
+// protocol is stopped and started again on next use.
+func runFilterProcess(command, direction, rel string, data []byte) ([]byte, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	key := wd + "\x00" + command
+	filterProcesses.Lock()
+	p, ok := filterProcesses.m[key]
+	if !ok {
+		if p, err = startFilterProcess(command); err != nil {
+			filterProcesses.Unlock()
+			return nil, err
+		}
+		filterProcesses.m[key] = p
+	}
+	filterProcesses.Unlock()
+
+	out, err := p.filter(direction, rel, data)
+	if isFilterProtocolError(err) || errors.Is(err, errFilterStopped) {
+		filterProcesses.Lock()
+		if filterProcesses.m[key] == p {
+			delete(filterProcesses.m, key)
+		}
+		filterProcesses.Unlock()
+	}
+	return out, err
+}
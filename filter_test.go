@@ -0,0 +1,256 @@
+package tinygit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/startdusk/tinygit/shared/pktline"
+)
+
+func setConfig(t *testing.T, values map[string]string) {
+	t.Helper()
+	config, err := ReadConfig()
+	if err != nil {
+		t.Fatalf("read config: %+v", err)
+	}
+	for key, value := range values {
+		if err := config.Set(key, value); err != nil {
+			t.Fatalf("set %s: %+v", key, err)
+		}
+	}
+	if err := WriteConfig(config); err != nil {
+		t.Fatalf("write config: %+v", err)
+	}
+}
+
+func blobOf(t *testing.T, p string) string {
+	t.Helper()
+	indexes, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	i, ok := indexes.Find(p)
+	if !ok {
+		t.Fatalf("expected %s in the index", p)
+	}
+	obj, err := ReadObject(indexes[i].Sha1)
+	if err != nil {
+		t.Fatalf("read object: %+v", err)
+	}
+	return string(obj.Data)
+}
+
+func TestFilterDriver(t *testing.T) {
+	setupRepo(t)
+	setConfig(t, map[string]string{
+		"filter.redact.clean":    "sed s/hunter2/REDACTED/",
+		"filter.keyword.clean":   "sed 's/[$]Id:[^$]*[$]/$Id$/'",
+		"filter.keyword.smudge":  "sed 's/[$]Id[$]/$Id: expanded $/'",
+		"filter.path.clean":      "cat >/dev/null; printf %s %f",
+		"filter.optional.clean":  "exit 1",
+		"filter.optional.smudge": "exit 1",
+	})
+	writeFiles(t, map[string]string{
+		".gitattributes": "*.conf filter=redact\n*.c filter=keyword\nname* filter=path\n*.opt filter=optional\n",
+		"app.conf":       "password=hunter2\n",
+		"main.c":         "/* $Id: old $ */\n",
+		"name it.txt":    "content",
+		"a.opt":          "kept\n",
+	})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	blobs := map[string]string{
+		"app.conf":    "password=REDACTED\n",
+		"main.c":      "/* $Id$ */\n",
+		"name it.txt": "name it.txt",
+		// a failing filter which is not required keeps the content
+		"a.opt": "kept\n",
+	}
+	for p, want := range blobs {
+		if got := blobOf(t, p); got != want {
+			t.Fatalf("%s: expected blob %q, but got %q", p, want, got)
+		}
+	}
+
+	for _, p := range []string{"app.conf", "main.c", "a.opt"} {
+		if err := os.Remove(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Restore(RestoreParam{Paths: []string{"."}}); err != nil {
+		t.Fatalf("restore: %+v", err)
+	}
+	assertFiles(t, map[string]string{
+		"app.conf": "password=REDACTED\n",
+		"main.c":   "/* $Id: expanded $ */\n",
+		"a.opt":    "kept\n",
+	})
+	// the smudged file cleans back to its blob
+	report, err := Status(StatusParam{})
+	if err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	for _, line := range report.Short(false) {
+		if line[1] != ' ' {
+			t.Fatalf("expected no unstaged change, but got %q", line)
+		}
+	}
+}
+
+func TestFilterDriverRequired(t *testing.T) {
+	setupRepo(t)
+	setConfig(t, map[string]string{
+		"filter.broken.clean":    "exit 1",
+		"filter.broken.required": "true",
+		"filter.half.clean":      "cat",
+		"filter.half.required":   "true",
+	})
+	writeFiles(t, map[string]string{
+		".gitattributes": "*.bad filter=broken\n*.half filter=half\n",
+		"a.bad":          "a",
+		"a.half":         "half",
+	})
+	err := Add(AddParam{Paths: []string{"a.bad"}})
+	if err == nil || !strings.Contains(err.Error(), "a.bad: clean filter 'broken' failed") {
+		t.Fatalf("expected a clean filter error, but got %v", err)
+	}
+	if err := Add(AddParam{Paths: []string{"a.half"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	// a required filter without a smudge command can not check out
+	if err := os.Remove("a.half"); err != nil {
+		t.Fatal(err)
+	}
+	err = Restore(RestoreParam{Paths: []string{"a.half"}})
+	if err == nil || !strings.Contains(err.Error(), "a.half: smudge filter 'half' failed") {
+		t.Fatalf("expected a smudge filter error, but got %v", err)
+	}
+}
+
+// TestFilterProcessHelper is not a test, it is the long-running filter
+// process started by TestFilterProcess. It upper cases on clean and lower
+// cases on smudge, paths containing "fail" or "abort" fail.
+func TestFilterProcessHelper(t *testing.T) {
+	if os.Getenv("TINYGIT_FILTER_PROCESS") != "1" {
+		return
+	}
+	r, w := pktline.NewReader(os.Stdin), pktline.NewWriter(os.Stdout)
+	if _, err := r.ReadLines(); err != nil {
+		os.Exit(1)
+	}
+	w.WriteLines("git-filter-server", "version=2")
+	if _, err := r.ReadLines(); err != nil {
+		os.Exit(1)
+	}
+	w.WriteLines("capability=clean", "capability=smudge")
+	for {
+		lines, err := r.ReadLines()
+		if err == io.EOF {
+			if eof := os.Getenv("TINYGIT_FILTER_EOF"); eof != "" {
+				os.WriteFile(eof, nil, 0644)
+			}
+			os.Exit(0)
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+		data, err := r.ReadData()
+		if err != nil {
+			os.Exit(1)
+		}
+		var command, pathname string
+		for _, line := range lines {
+			if strings.HasPrefix(line, "command=") {
+				command = strings.TrimPrefix(line, "command=")
+			}
+			if strings.HasPrefix(line, "pathname=") {
+				pathname = strings.TrimPrefix(line, "pathname=")
+			}
+		}
+		switch {
+		case strings.Contains(pathname, "fail"):
+			w.WriteLines("status=error")
+			continue
+		case strings.Contains(pathname, "abort"):
+			w.WriteLines("status=abort")
+			continue
+		case command == "clean":
+			data = bytes.ToUpper(data)
+		default:
+			data = bytes.ToLower(data)
+		}
+		w.WriteLines("status=success")
+		w.WriteData(data)
+		// an empty list keeps the status
+		w.Flush()
+	}
+}
+
+func TestFilterProcess(t *testing.T) {
+	setupRepo(t)
+	command := "TINYGIT_FILTER_PROCESS=1 " + shellQuote(os.Args[0]) + " -test.run=^TestFilterProcessHelper$"
+	setConfig(t, map[string]string{
+		"filter.case.process":  command,
+		"filter.case.required": "true",
+		"filter.loose.process": command,
+	})
+	writeFiles(t, map[string]string{
+		".gitattributes": "*.up filter=case\n*.loose filter=loose\n",
+		"a.up":           "hello\n",
+		"dir/b.up":       "world\n",
+		"fail.up":        "nope\n",
+		"fail.loose":     "kept\n",
+	})
+	err := Add(AddParam{Paths: []string{"fail.up"}})
+	if err == nil || !strings.Contains(err.Error(), "fail.up: clean filter 'case' failed: filter process returned status 'error'") {
+		t.Fatalf("expected a clean filter error, but got %v", err)
+	}
+	if err := Add(AddParam{Paths: []string{"a.up", "dir/b.up", "fail.loose"}, Jobs: 4}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	for p, want := range map[string]string{"a.up": "HELLO\n", "dir/b.up": "WORLD\n", "fail.loose": "kept\n"} {
+		if got := blobOf(t, p); got != want {
+			t.Fatalf("%s: expected blob %q, but got %q", p, want, got)
+		}
+	}
+	if err := os.Remove("a.up"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(RestoreParam{Paths: []string{"a.up"}}); err != nil {
+		t.Fatalf("restore: %+v", err)
+	}
+	assertFiles(t, map[string]string{"a.up": "hello\n"})
+}
+
+func TestStopFilterProcesses(t *testing.T) {
+	setupRepo(t)
+	eof, err := filepath.Abs("eof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	command := "TINYGIT_FILTER_PROCESS=1 TINYGIT_FILTER_EOF=" + shellQuote(eof) + " " +
+		shellQuote(os.Args[0]) + " -test.run=^TestFilterProcessHelper$"
+	setConfig(t, map[string]string{"filter.case.process": command})
+	writeFiles(t, map[string]string{
+		".gitattributes": "*.up filter=case\n",
+		"a.up":           "hello\n",
+	})
+	if err := Add(AddParam{Paths: []string{"a.up"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if _, err := os.Stat(eof); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the process to be running, but got %v", err)
+	}
+	StopFilterProcesses()
+	// the process has exited once StopFilterProcesses returns
+	if _, err := os.Stat(eof); err != nil {
+		t.Fatalf("expected the process to see EOF, but got %v", err)
+	}
+}
@@ -0,0 +1,399 @@
+package tinygit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/startdusk/tinygit/shared/ewah"
+)
+
+// With core.fsmonitor set, a daemon watching the working tree reports the
+// paths changed since a token, so entries known unchanged are not checked
+// with lstat. The "FSMN" extension of the index stores the token and a
+// EWAH bitmap of the entries which were not known unchanged at that token,
+// with Git's layout.
+const (
+	fsmonitorSignature = "FSMN"
+	fsmonitorVersion   = 2
+)
+
+// cookie files are created in the repository folder by the daemon to know it
+// has seen every event before answering a query
+const fsmonitorCookiePrefix = "fsmonitor--cookie."
+
+func fsmonitorSocket() string {
+	return filepath.Join(gitDir(), "fsmonitor.sock")
+}
+
+// fsmonitorTimeout bounds the time waited for the daemon.
+const fsmonitorTimeout = 5 * time.Second
+
+// fsmonitorEnabled report whether core.fsmonitor is set.
+func fsmonitorEnabled(config *Config) bool {
+	enabled, err := config.GetBool("core.fsmonitor", false)
+	return err == nil && enabled
+}
+
+// encodeFSMonitor pack the token and the EWAH bitmap of the entries which
+// are not fsmonitor valid, preceded by its length, as Git does.
+func encodeFSMonitor(token string, indexes []Index) []byte {
+	data := binary.BigEndian.AppendUint32(nil, fsmonitorVersion)
+	data = append(data, token...)
+	data = append(data, 0)
+	bitmap := ewah.New(len(indexes))
+	for i, index := range indexes {
+		if !index.FSMonitorValid() {
+			bitmap.Set(i)
+		}
+	}
+	encoded := bitmap.Encode()
+	data = binary.BigEndian.AppendUint32(data, uint32(len(encoded)))
+	return append(data, encoded...)
+}
+
+// decodeFSMonitor return the token and the dirty bitmap of the extension.
+// The bitmap may be shorter than the entries, the others are dirty.
+func decodeFSMonitor(data []byte) (string, []bool, error) {
+	if len(data) < 4 || binary.BigEndian.Uint32(data) != fsmonitorVersion {
+		return "", nil, errors.New("bad fsmonitor version")
+	}
+	data = data[4:]
+	end := bytes.IndexByte(data, 0)
+	if end < 0 || len(data) < end+5 {
+		return "", nil, errors.New("invalid fsmonitor extension")
+	}
+	token := string(data[:end])
+	size := int(binary.BigEndian.Uint32(data[end+1:]))
+	if len(data[end+5:]) != size {
+		return "", nil, errors.New("invalid fsmonitor extension")
+	}
+	bitmap, err := ewah.Decode(data[end+5:])
+	if err != nil {
+		return "", nil, errors.New("invalid fsmonitor extension bitmap")
+	}
+	dirty := make([]bool, bitmap.Len())
+	for i := range dirty {
+		dirty[i] = bitmap.Get(i)
+	}
+	return token, dirty, nil
+}
+
+// fsmonitorTokens holds by working directory the first token the daemon
+// answered in this process. Entries read since were checked against changes
+// after it, so asking for the changes since it again before writing the
+// index catches every change the valid bits may have missed.
+var fsmonitorTokens = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// rememberFSMonitorToken record the token unless one is already known.
+func rememberFSMonitorToken(token string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	fsmonitorTokens.Lock()
+	defer fsmonitorTokens.Unlock()
+	if _, ok := fsmonitorTokens.m[wd]; !ok {
+		fsmonitorTokens.m[wd] = token
+	}
+}
+
+// rememberedFSMonitorToken return the first token answered in this process.
+func rememberedFSMonitorToken() string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	fsmonitorTokens.Lock()
+	defer fsmonitorTokens.Unlock()
+	return fsmonitorTokens.m[wd]
+}
+
+// invalidatePaths clear the fsmonitor valid bit of the entries of the
+// changed paths and of the entries below them, "/" stands for every path.
+func invalidatePaths(indexes Indexes, paths []string) {
+	for _, p := range paths {
+		p = strings.TrimSuffix(p, "/")
+		start := 0
+		if p != "" {
+			start, _ = indexes.Find(p)
+		}
+		for i := start; i < len(indexes); i++ {
+			if p != "" && indexes[i].Path != p && !strings.HasPrefix(indexes[i].Path, p+"/") {
+				// the entries below p follow p, except for the paths
+				// sorting between p and p+"/" like "p.txt"
+				if indexes[i].Path > p+"/" {
+					break
+				}
+				continue
+			}
+			indexes[i].SetFSMonitorValid(false)
+		}
+	}
+}
+
+// applyFSMonitor mark the entries which did not change since the token of
+// the extension as fsmonitor valid. Nothing is marked when the monitor is
+// disabled or the daemon can not be queried.
+func applyFSMonitor(indexes Indexes, ext indexExtension) Indexes {
+	config, err := ReadConfig()
+	if err != nil || !fsmonitorEnabled(config) {
+		return indexes
+	}
+	token, dirty, err := decodeFSMonitor(ext.Data)
+	if err != nil || len(dirty) > len(indexes) {
+		return indexes
+	}
+	newToken, paths, err := queryFSMonitor(token)
+	if err != nil {
+		return indexes
+	}
+	rememberFSMonitorToken(newToken)
+	for i := range indexes {
+		indexes[i].SetFSMonitorValid(i < len(dirty) && !dirty[i])
+	}
+	invalidatePaths(indexes, paths)
+	return indexes
+}
+
+// fsmonitorExtension return the extension to write with the entries, whose
+// valid bits are first cleared for the paths changed since the remembered
+// token. No extension is written when the monitor is disabled or the daemon
+// can not be queried.
+func fsmonitorExtension(indexes Indexes, config *Config) []indexExtension {
+	if !fsmonitorEnabled(config) {
+		return nil
+	}
+	token := rememberedFSMonitorToken()
+	newToken, paths, err := queryFSMonitor(token)
+	if err != nil {
+		return nil
+	}
+	if token == "" {
+		rememberFSMonitorToken(newToken)
+		paths = []string{"/"}
+	}
+	invalidatePaths(indexes, paths)
+	return []indexExtension{{Signature: fsmonitorSignature, Data: encodeFSMonitor(newToken, indexes)}}
+}
+
+// fsmonitorRequest send the request line to the daemon and return its
+// answer.
+func fsmonitorRequest(request string) (string, error) {
+	conn, err := net.DialTimeout("unix", fsmonitorSocket(), fsmonitorTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(fsmonitorTimeout))
+	if _, err := io.WriteString(conn, request+"\n"); err != nil {
+		return "", err
+	}
+	answer, err := io.ReadAll(conn)
+	return string(answer), err
+}
+
+// queryFSMonitor return a new token and the paths changed since the token,
+// directories end with a slash and stand for every path below them.
+func queryFSMonitor(token string) (string, []string, error) {
+	answer, err := fsmonitorRequest("query " + token)
+	if err != nil {
+		return "", nil, err
+	}
+	fields := strings.Split(answer, "\x00")
+	if fields[0] == "" {
+		return "", nil, errors.New("invalid fsmonitor answer")
+	}
+	var paths []string
+	for _, p := range fields[1:] {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return fields[0], paths, nil
+}
+
+// FSMonitorStatus return a message telling whether the daemon watches the
+// working tree, an error when it does not.
+func FSMonitorStatus() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	if answer, err := fsmonitorRequest("ping"); err != nil || answer != "ok" {
+		return "", fmt.Errorf("fsmonitor-daemon is not watching '%s'", wd)
+	}
+	return fmt.Sprintf("fsmonitor-daemon is watching '%s'", wd), nil
+}
+
+// FSMonitorStop ask the daemon to stop.
+func FSMonitorStop() error {
+	if _, err := FSMonitorStatus(); err != nil {
+		return err
+	}
+	_, err := fsmonitorRequest("quit")
+	return err
+}
+
+// fsmonitorDaemon holds the changes seen by the daemon. Each change takes
+// the next sequence number, a token is the epoch of the daemon and the last
+// sequence number known to the client.
+type fsmonitorDaemon struct {
+	mu    sync.Mutex
+	epoch string
+	seq   uint64
+	// changes holds the sequence number of the last change of each path
+	changes map[string]uint64
+	cookies map[string]chan struct{}
+	cookie  int
+}
+
+func newFSMonitorDaemon() *fsmonitorDaemon {
+	return &fsmonitorDaemon{
+		epoch:   fmt.Sprintf("%d.%d", os.Getpid(), time.Now().UnixNano()),
+		changes: make(map[string]uint64),
+		cookies: make(map[string]chan struct{}),
+	}
+}
+
+// record note a change of the path.
+func (d *fsmonitorDaemon) record(p string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seq++
+	d.changes[p] = d.seq
+}
+
+// sawCookie wake up the query waiting for the cookie file.
+func (d *fsmonitorDaemon) sawCookie(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ch, ok := d.cookies[name]; ok {
+		close(ch)
+		delete(d.cookies, name)
+	}
+}
+
+// reset forget every change, events were lost so every token of the
+// previous epoch is answered with every path.
+func (d *fsmonitorDaemon) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.epoch = fmt.Sprintf("%d.%d", os.Getpid(), time.Now().UnixNano())
+	d.seq = 0
+	d.changes = make(map[string]uint64)
+}
+
+// changedSince return the current token and the sorted paths changed after
+// the token, "/" when the token is not of the current epoch.
+func (d *fsmonitorDaemon) changedSince(token string) (string, []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	current := d.epoch + ":" + strconv.FormatUint(d.seq, 10)
+	epoch, s, _ := strings.Cut(token, ":")
+	seq, err := strconv.ParseUint(s, 10, 64)
+	if epoch != d.epoch || err != nil || seq > d.seq {
+		return current, []string{"/"}
+	}
+	var paths []string
+	for p, changed := range d.changes {
+		if changed > seq {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return current, paths
+}
+
+// sync create a cookie file and wait for its event, so every change made
+// before the query was recorded.
+func (d *fsmonitorDaemon) sync() error {
+	d.mu.Lock()
+	d.cookie++
+	name := fmt.Sprintf("%s%d", fsmonitorCookiePrefix, d.cookie)
+	ch := make(chan struct{})
+	d.cookies[name] = ch
+	d.mu.Unlock()
+
+	file := filepath.Join(gitDir(), name)
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		return err
+	}
+	defer os.Remove(file)
+	select {
+	case <-ch:
+		return nil
+	case <-time.After(fsmonitorTimeout):
+		d.sawCookie(name)
+		return errors.New("fsmonitor cookie timed out")
+	}
+}
+
+// serve answer the requests of the clients until asked to quit, each
+// connection in its own goroutine so a slow client does not hold the others.
+func (d *fsmonitorDaemon) serve(l net.Listener) error {
+	quit := make(chan struct{})
+	var once sync.Once
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-quit:
+				return nil
+			default:
+				return err
+			}
+		}
+		go func() {
+			if d.handle(conn) {
+				once.Do(func() {
+					close(quit)
+					l.Close()
+				})
+			}
+		}()
+	}
+}
+
+// handle answer the request of the connection and report whether it asked
+// the daemon to quit. A client which does not send its request or read the
+// answer in time is dropped.
+func (d *fsmonitorDaemon) handle(conn net.Conn) bool {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(fsmonitorTimeout))
+	request, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	request = strings.TrimSuffix(request, "\n")
+	switch {
+	case request == "ping":
+		io.WriteString(conn, "ok")
+	case request == "quit":
+		io.WriteString(conn, "ok")
+		return true
+	case strings.HasPrefix(request, "query "):
+		token, paths := d.changedSince("")
+		// a query not synchronized with the events answers every path
+		if d.sync() == nil {
+			token, paths = d.changedSince(strings.TrimPrefix(request, "query "))
+		}
+		conn.SetDeadline(time.Now().Add(fsmonitorTimeout))
+		io.WriteString(conn, token+"\x00"+strings.Join(paths, "\x00"))
+	}
+	return false
+}
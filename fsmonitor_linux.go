@@ -0,0 +1,185 @@
+//go:build linux
+
+package tinygit
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask are the events watched on each directory of the working tree.
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF |
+	syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW
+
+// inotifyWatcher records the changes of the working tree reported by inotify
+// into the daemon. Each directory has its own watch, the repository folder
+// is only watched for the creation of cookie files.
+type inotifyWatcher struct {
+	daemon *fsmonitorDaemon
+	fd     int
+	file   *os.File
+	// dirs holds the repo relative directory of each watch
+	dirs      map[int32]string
+	cookiesWd int32
+}
+
+// FSMonitorRun watch the working tree and answer the queries of tinygit
+// until FSMonitorStop is called.
+func FSMonitorRun() error {
+	if _, err := FSMonitorStatus(); err == nil {
+		return errors.New("fsmonitor-daemon is already running")
+	}
+	// the socket of a daemon which did not stop cleanly is in the way
+	if err := os.Remove(fsmonitorSocket()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	d := newFSMonitorDaemon()
+	w, err := newInotifyWatcher(d)
+	if err != nil {
+		return err
+	}
+	defer w.file.Close()
+	l, err := net.Listen("unix", fsmonitorSocket())
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	go w.run()
+	return d.serve(l)
+}
+
+// newInotifyWatcher watch every directory of the working tree.
+func newInotifyWatcher(d *fsmonitorDaemon) (*inotifyWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// a non blocking file goes through the runtime poller, so closing it
+	// ends a pending read
+	w := &inotifyWatcher{
+		daemon: d,
+		fd:     fd,
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		dirs:   make(map[int32]string),
+	}
+	cookiesWd, err := syscall.InotifyAddWatch(fd, gitDir(), syscall.IN_CREATE|syscall.IN_ONLYDIR)
+	if err != nil {
+		w.file.Close()
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+	w.cookiesWd = int32(cookiesWd)
+	if err := w.addTree(""); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// addTree watch the directory and the directories below it, except the
+// repository folders.
+func (w *inotifyWatcher) addTree(dir string) error {
+	root := "."
+	if dir != "" {
+		root = filepath.FromSlash(dir)
+	}
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// the directory may be gone already, its removal is an event
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if d.Name() == RepoRootPath {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, p, inotifyMask)
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ENOTDIR) {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		rel := filepath.ToSlash(p)
+		if rel == "." {
+			rel = ""
+		}
+		w.dirs[int32(wd)] = rel
+		return nil
+	})
+}
+
+// run read the events until the watcher is closed.
+func (w *inotifyWatcher) run() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			start := off + syscall.SizeofInotifyEvent
+			off = start + int(event.Len)
+			if off > n {
+				break
+			}
+			name := string(bytes.TrimRight(buf[start:off], "\x00"))
+			w.handle(event.Wd, event.Mask, name)
+		}
+	}
+}
+
+// handle record the change of the event.
+func (w *inotifyWatcher) handle(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		// events were lost, every path may have changed
+		w.daemon.reset()
+		return
+	}
+	if wd == w.cookiesWd {
+		if strings.HasPrefix(name, fsmonitorCookiePrefix) {
+			w.daemon.sawCookie(name)
+		}
+		return
+	}
+	dir, ok := w.dirs[wd]
+	if !ok {
+		return
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, wd)
+		return
+	}
+	if name == "" {
+		// the watched directory itself was removed or moved
+		w.daemon.record(dir + "/")
+		return
+	}
+	p := path.Join(dir, name)
+	if p == RepoRootPath {
+		return
+	}
+	if mask&syscall.IN_ISDIR == 0 {
+		w.daemon.record(p)
+		return
+	}
+	if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		// files may be created in the directory before it is watched, the
+		// whole directory is recorded once the watches are in place
+		w.addTree(p)
+	}
+	w.daemon.record(p + "/")
+}
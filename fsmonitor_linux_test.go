@@ -0,0 +1,97 @@
+//go:build linux
+
+package tinygit
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFSMonitor(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a", "dir/b.txt": "b", "c.txt": "c"})
+	setConfig(t, map[string]string{"core.fsmonitor": "true"})
+
+	done := make(chan error, 1)
+	go func() { done <- FSMonitorRun() }()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if _, err := FSMonitorStatus(); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("fsmonitor-daemon did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Cleanup(func() {
+		if err := FSMonitorStop(); err != nil {
+			t.Errorf("stop: %+v", err)
+		}
+		if err := <-done; err != nil {
+			t.Errorf("run: %+v", err)
+		}
+	})
+
+	// a client which does not send its request does not hold the others
+	idle, err := net.Dial("unix", fsmonitorSocket())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	if _, err := FSMonitorStatus(); err != nil {
+		t.Fatalf("status with an idle client: %+v", err)
+	}
+
+	valid := func() map[string]bool {
+		t.Helper()
+		indexes, err := ReadIndex()
+		if err != nil {
+			t.Fatalf("read index: %+v", err)
+		}
+		valid := make(map[string]bool)
+		for _, index := range indexes {
+			valid[index.Path] = index.FSMonitorValid()
+		}
+		return valid
+	}
+
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	commitIndex(t, "initial")
+	if got := valid(); got["a.txt"] || got["dir/b.txt"] {
+		t.Fatalf("expected added entries not to be valid, but got %v", got)
+	}
+	// status records the unchanged files as valid
+	if _, err := Status(StatusParam{}); err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	if got := valid(); !got["a.txt"] || !got["dir/b.txt"] || !got["c.txt"] {
+		t.Fatalf("expected every entry to be valid, but got %v", got)
+	}
+
+	writeFiles(t, map[string]string{"a.txt": "changed"})
+	if err := os.RemoveAll("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if got := valid(); got["a.txt"] || got["dir/b.txt"] || !got["c.txt"] {
+		t.Fatalf("expected only c.txt to be valid, but got %v", got)
+	}
+	report, err := Status(StatusParam{})
+	if err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	if short := report.Short(false); len(short) != 2 || short[0] != " M a.txt" || short[1] != " D dir/b.txt" {
+		t.Fatalf("unexpected status %q", short)
+	}
+
+	// add picks the reported change up
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if got := blobOf(t, "a.txt"); got != "changed" {
+		t.Fatalf("expected the changed content to be staged, but got %q", got)
+	}
+}
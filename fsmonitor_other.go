@@ -0,0 +1,11 @@
+//go:build !linux
+
+package tinygit
+
+import "errors"
+
+// FSMonitorRun watch the working tree and answer the queries of tinygit
+// until FSMonitorStop is called.
+func FSMonitorRun() error {
+	return errors.New("fsmonitor-daemon is only supported on Linux")
+}
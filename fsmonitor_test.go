@@ -0,0 +1,93 @@
+package tinygit
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFSMonitorExtension(t *testing.T) {
+	indexes := make([]Index, 10)
+	for i := range indexes {
+		indexes[i].SetFSMonitorValid(i%3 != 0)
+	}
+	token, dirty, err := decodeFSMonitor(encodeFSMonitor("1.2:3", indexes))
+	if err != nil {
+		t.Fatalf("decode: %+v", err)
+	}
+	if token != "1.2:3" {
+		t.Fatalf("expected token 1.2:3, but got %q", token)
+	}
+	for i := range indexes {
+		if dirty[i] != !indexes[i].FSMonitorValid() {
+			t.Fatalf("expected entry %d dirty to be %v", i, !indexes[i].FSMonitorValid())
+		}
+	}
+	// written by git update-index with the entries 23, 62 and 69 of 70 dirty
+	data, _ := hex.DecodeString("00000002746f6b310000000024000000460000000300000004000000004000000000800000000000000000002000000000")
+	token, dirty, err = decodeFSMonitor(data)
+	if err != nil {
+		t.Fatalf("decode: %+v", err)
+	}
+	var dirtyEntries []int
+	for i := range dirty {
+		if dirty[i] {
+			dirtyEntries = append(dirtyEntries, i)
+		}
+	}
+	if token != "tok1" || len(dirty) != 70 || !reflect.DeepEqual(dirtyEntries, []int{23, 62, 69}) {
+		t.Fatalf("unexpected token %q and dirty entries %v of %d", token, dirtyEntries, len(dirty))
+	}
+	if _, _, err := decodeFSMonitor([]byte{0, 0, 0, 1}); err == nil {
+		t.Fatal("expected an unknown version to fail")
+	}
+}
+
+func TestFSMonitorDaemon(t *testing.T) {
+	d := newFSMonitorDaemon()
+	d.record("a.txt")
+	first, _ := d.changedSince("")
+	d.record("dir/")
+	d.record("b.txt")
+	d.record("a.txt")
+	second, paths := d.changedSince(first)
+	if want := []string{"a.txt", "b.txt", "dir/"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("expected %q, but got %q", want, paths)
+	}
+	if _, paths := d.changedSince(second); len(paths) != 0 {
+		t.Fatalf("expected no change, but got %q", paths)
+	}
+	for _, token := range []string{"", "bad", "other:1", strings.Split(first, ":")[0] + ":99"} {
+		if _, paths := d.changedSince(token); !reflect.DeepEqual(paths, []string{"/"}) {
+			t.Fatalf("expected every path for token %q, but got %q", token, paths)
+		}
+	}
+	d.reset()
+	if _, paths := d.changedSince(second); !reflect.DeepEqual(paths, []string{"/"}) {
+		t.Fatalf("expected every path after a reset, but got %q", paths)
+	}
+}
+
+func TestInvalidatePaths(t *testing.T) {
+	var indexes Indexes
+	for _, p := range []string{"a", "a.txt", "a/b", "a/c/d", "b"} {
+		index := Index{Path: p}
+		index.SetFSMonitorValid(true)
+		indexes = append(indexes, index)
+	}
+	invalidatePaths(indexes, []string{"a/", "b"})
+	var valid []string
+	for _, index := range indexes {
+		if index.FSMonitorValid() {
+			valid = append(valid, index.Path)
+		}
+	}
+	if want := []string{"a.txt"}; !reflect.DeepEqual(valid, want) {
+		t.Fatalf("expected %q to stay valid, but got %q", want, valid)
+	}
+	invalidatePaths(indexes, []string{"/"})
+	if indexes[1].FSMonitorValid() {
+		t.Fatal("expected every entry to be invalidated")
+	}
+}
@@ -0,0 +1,411 @@
+package tinygit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFileName is the name of the per directory ignore files.
+const IgnoreFileName = ".tinygitignore"
+
+func infoExcludeFile() string {
+	return filepath.Join(commonDir(), "info", "exclude")
+}
+
+// ignorePattern is a single pattern of an ignore file, following the
+// gitignore semantics.
+type ignorePattern struct {
+	// source is the file the pattern was read from, empty for patterns given
+	// on the command line, and line its line number.
+	source string
+	line   int
+	text   string
+	// base is the directory of the ignore file the pattern is relative to.
+	base    string
+	negate  bool
+	dirOnly bool
+	// basename patterns have no slash and match the name at any depth.
+	basename bool
+	re       *regexp.Regexp
+}
+
+// parseIgnorePattern parse a line of an ignore file, returning nil for blank
+// lines and comments.
+func parseIgnorePattern(text, base, source string, line int) (*ignorePattern, error) {
+	text = strings.TrimSuffix(text, "\r")
+	p := &ignorePattern{source: source, line: line, base: base}
+	// trailing spaces are ignored unless escaped with a backslash
+	for strings.HasSuffix(text, " ") && !strings.HasSuffix(text, "\\ ") {
+		text = text[:len(text)-1]
+	}
+	if text == "" || strings.HasPrefix(text, "#") {
+		return nil, nil
+	}
+	p.text = text
+	if strings.HasPrefix(text, "!") {
+		p.negate = true
+		text = text[1:]
+	}
+	if strings.HasSuffix(text, "/") {
+		p.dirOnly = true
+		text = strings.TrimSuffix(text, "/")
+	}
+	if strings.Contains(text, "/") {
+		text = strings.TrimPrefix(text, "/")
+	} else {
+		p.basename = true
+	}
+	if text == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(globToRegexp(text))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ignore pattern '%s': %w", p.text, err)
+	}
+	p.re = re
+	return p, nil
+}
+
+// globToRegexp translate a gitignore glob into an anchored regular
+// expression. "*", "?" and brackets never match a slash, while "**" as a
+// whole path component matches any number of directories.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**") && (i == 0 || glob[i-1] == '/') {
+				if i+2 == len(glob) {
+					b.WriteString(".*")
+					i++
+					continue
+				}
+				if glob[i+2] == '/' {
+					b.WriteString("(?:.*/)?")
+					i += 2
+					continue
+				}
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := i + 1
+			if end < len(glob) && (glob[end] == '!' || glob[end] == '^') {
+				end++
+			}
+			if end < len(glob) && glob[end] == ']' {
+				end++
+			}
+			for end < len(glob) && glob[end] != ']' {
+				end++
+			}
+			if end >= len(glob) {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i = end
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// match report whether the repo relative path matches the pattern.
+func (p *ignorePattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.base != "" {
+		if !strings.HasPrefix(rel, p.base+"/") {
+			return false
+		}
+		rel = rel[len(p.base)+1:]
+	}
+	if p.basename {
+		rel = path.Base(rel)
+	}
+	return p.re.MatchString(rel)
+}
+
+// readIgnoreFile read the patterns of an ignore file, an absent file has no
+// patterns.
+func readIgnoreFile(file, base, source string) ([]*ignorePattern, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read ignore file: %w", err)
+	}
+	var patterns []*ignorePattern
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		p, err := parseIgnorePattern(scanner.Text(), base, source, line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", source, line, err)
+		}
+		if p != nil {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+// globalExcludesFile return the file named by core.excludesFile, by default
+// $XDG_CONFIG_HOME/tinygit/ignore or ~/.config/tinygit/ignore.
+func globalExcludesFile(config *Config) string {
+	if file, ok := config.Get("core.excludesFile"); ok {
+		if strings.HasPrefix(file, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				file = filepath.Join(home, file[2:])
+			}
+		}
+		return file
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "tinygit", "ignore")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "tinygit", "ignore")
+}
+
+// ignoreRules decides which paths are ignored. From the lowest to the
+// highest precedence the patterns come from the global excludes file,
+// .tinygit/info/exclude, the .tinygitignore files from the root down to the
+// directory of the path, and the command line. The last matching pattern
+// wins.
+type ignoreRules struct {
+	standard []*ignorePattern
+	extra    []*ignorePattern
+	perDir   bool
+	// dirs caches the .tinygitignore patterns of each directory, and
+	// dirMatches the pattern deciding whether a directory is ignored.
+	dirs       map[string][]*ignorePattern
+	dirMatches map[string]*ignorePattern
+}
+
+// newIgnoreRules create the rules of the given command line patterns, and
+// when standard is set of the ignore files of the repository.
+func newIgnoreRules(patterns []string, standard bool) (*ignoreRules, error) {
+	r := &ignoreRules{
+		perDir:     standard,
+		dirs:       make(map[string][]*ignorePattern),
+		dirMatches: make(map[string]*ignorePattern),
+	}
+	for _, text := range patterns {
+		p, err := parseIgnorePattern(text, "", "", 0)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			r.extra = append(r.extra, p)
+		}
+	}
+	if !standard {
+		return r, nil
+	}
+	config, err := ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if file := globalExcludesFile(config); file != "" {
+		global, err := readIgnoreFile(file, "", file)
+		if err != nil {
+			return nil, err
+		}
+		r.standard = append(r.standard, global...)
+	}
+	file := infoExcludeFile()
+	exclude, err := readIgnoreFile(file, "", filepath.ToSlash(file))
+	if err != nil {
+		return nil, err
+	}
+	r.standard = append(r.standard, exclude...)
+	return r, nil
+}
+
+// standardIgnoreRules create the rules of the ignore files of the repository.
+func standardIgnoreRules() (*ignoreRules, error) {
+	return newIgnoreRules(nil, true)
+}
+
+// dirPatterns return the patterns of the .tinygitignore file of the directory.
+func (r *ignoreRules) dirPatterns(dir string) ([]*ignorePattern, error) {
+	if patterns, ok := r.dirs[dir]; ok {
+		return patterns, nil
+	}
+	source := IgnoreFileName
+	if dir != "" {
+		source = dir + "/" + IgnoreFileName
+	}
+	patterns, err := readIgnoreFile(filepath.FromSlash(source), dir, source)
+	if err != nil {
+		return nil, err
+	}
+	r.dirs[dir] = patterns
+	return patterns, nil
+}
+
+// matchPath return the last pattern of the highest precedence matching the
+// path itself, nil when none matches.
+func (r *ignoreRules) matchPath(rel string, isDir bool) (*ignorePattern, error) {
+	for i := len(r.extra) - 1; i >= 0; i-- {
+		if r.extra[i].match(rel, isDir) {
+			return r.extra[i], nil
+		}
+	}
+	if r.perDir {
+		// deeper ignore files take precedence over the ones above them
+		for dir := path.Dir(rel); ; dir = path.Dir(dir) {
+			if dir == "." {
+				dir = ""
+			}
+			patterns, err := r.dirPatterns(dir)
+			if err != nil {
+				return nil, err
+			}
+			for i := len(patterns) - 1; i >= 0; i-- {
+				if patterns[i].match(rel, isDir) {
+					return patterns[i], nil
+				}
+			}
+			if dir == "" {
+				break
+			}
+		}
+	}
+	for i := len(r.standard) - 1; i >= 0; i-- {
+		if r.standard[i].match(rel, isDir) {
+			return r.standard[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// match return the pattern deciding whether the path is ignored, nil when no
+// pattern matches. Everything inside an ignored directory is ignored, a
+// negated pattern can not include a path again when its directory is
+// excluded.
+func (r *ignoreRules) match(rel string, isDir bool) (*ignorePattern, error) {
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		dir := strings.Join(parts[:i], "/")
+		p, ok := r.dirMatches[dir]
+		if !ok {
+			var err error
+			if p, err = r.matchPath(dir, true); err != nil {
+				return nil, err
+			}
+			r.dirMatches[dir] = p
+		}
+		if p != nil && !p.negate {
+			return p, nil
+		}
+	}
+	return r.matchPath(rel, isDir)
+}
+
+// ignored report whether the path is ignored.
+func (r *ignoreRules) ignored(rel string, isDir bool) (bool, error) {
+	p, err := r.match(rel, isDir)
+	return p != nil && !p.negate, err
+}
+
+// CheckIgnoreParam check-ignore command params.
+type CheckIgnoreParam struct {
+	Paths []string
+	// NoIndex also checks tracked paths, which are never ignored otherwise.
+	NoIndex bool
+}
+
+// IgnoreMatch is the pattern matching a path checked by CheckIgnore.
+type IgnoreMatch struct {
+	Path string
+	// Source is the file holding the pattern and Line its line number,
+	// Pattern is empty when no pattern matches.
+	Source  string
+	Line    int
+	Pattern string
+}
+
+// Ignored report whether the path is ignored, a matching negated pattern
+// keeps it.
+func (m IgnoreMatch) Ignored() bool {
+	return m.Pattern != "" && !strings.HasPrefix(m.Pattern, "!")
+}
+
+// Verbose format the match as "<source>:<line>:<pattern>\t<path>".
+func (m IgnoreMatch) Verbose() string {
+	if m.Pattern == "" {
+		return "::\t" + m.Path
+	}
+	return fmt.Sprintf("%s:%d:%s\t%s", m.Source, m.Line, m.Pattern, m.Path)
+}
+
+// CheckIgnore return the ignore pattern matching each path, in the order of
+// the paths.
+func CheckIgnore(param CheckIgnoreParam) ([]IgnoreMatch, error) {
+	if len(param.Paths) == 0 {
+		return nil, errors.New("no path specified")
+	}
+	rules, err := standardIgnoreRules()
+	if err != nil {
+		return nil, err
+	}
+	var indexes Indexes
+	if !param.NoIndex {
+		if indexes, err = ReadIndex(); err != nil {
+			return nil, err
+		}
+	}
+	matches := make([]IgnoreMatch, 0, len(param.Paths))
+	for _, p := range param.Paths {
+		m := IgnoreMatch{Path: p}
+		rel, err := repoRelPath(p)
+		if err != nil {
+			return nil, err
+		}
+		if _, tracked := indexes.Find(rel); tracked || rel == "." {
+			matches = append(matches, m)
+			continue
+		}
+		isDir := strings.HasSuffix(p, "/")
+		if info, err := os.Stat(filepath.FromSlash(rel)); err == nil && info.IsDir() {
+			isDir = true
+		}
+		pattern, err := rules.match(rel, isDir)
+		if err != nil {
+			return nil, err
+		}
+		if pattern != nil {
+			m.Source, m.Line, m.Pattern = pattern.source, pattern.line, pattern.text
+		}
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
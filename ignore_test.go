@@ -0,0 +1,181 @@
+package tinygit
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIgnorePatternMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		base    string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{pattern: "*.log", path: "a.log", want: true},
+		{pattern: "*.log", path: "dir/sub/a.log", want: true},
+		{pattern: "*.log", path: "a.logs", want: false},
+		{pattern: "/a.log", path: "dir/a.log", want: false},
+		{pattern: "/a.log", path: "a.log", want: true},
+		{pattern: "dir/*.o", path: "dir/a.o", want: true},
+		{pattern: "dir/*.o", path: "dir/sub/a.o", want: false},
+		{pattern: "dir/*.o", path: "top/dir/a.o", want: false},
+		{pattern: "build/", path: "build", want: false},
+		{pattern: "build/", path: "build", isDir: true, want: true},
+		{pattern: "build/", path: "src/build", isDir: true, want: true},
+		{pattern: "**/foo", path: "foo", want: true},
+		{pattern: "**/foo", path: "a/b/foo", want: true},
+		{pattern: "**/foo/bar", path: "a/foo/bar", want: true},
+		{pattern: "abc/**", path: "abc/x/y", want: true},
+		{pattern: "abc/**", path: "abc", isDir: true, want: false},
+		{pattern: "a/**/b", path: "a/b", want: true},
+		{pattern: "a/**/b", path: "a/x/y/b", want: true},
+		{pattern: "a/**/b", path: "ab/b", want: false},
+		{pattern: "a**b", path: "a/b", want: false},
+		{pattern: "file?.txt", path: "file1.txt", want: true},
+		{pattern: "file?.txt", path: "file10.txt", want: false},
+		{pattern: "[!a-c]*.txt", path: "d.txt", want: true},
+		{pattern: "[!a-c]*.txt", path: "b.txt", want: false},
+		{pattern: `\#hash`, path: "#hash", want: true},
+		{pattern: `\!bang`, path: "!bang", want: true},
+		{pattern: `trailing\ `, path: "trailing ", want: true},
+		{pattern: "*.tmp", base: "dir", path: "dir/sub/a.tmp", want: true},
+		{pattern: "*.tmp", base: "dir", path: "other/a.tmp", want: false},
+		{pattern: "/a.tmp", base: "dir", path: "dir/a.tmp", want: true},
+		{pattern: "/a.tmp", base: "dir", path: "dir/sub/a.tmp", want: false},
+	}
+	for _, c := range cases {
+		p, err := parseIgnorePattern(c.pattern, c.base, "", 0)
+		if err != nil {
+			t.Fatalf("parse %q: %+v", c.pattern, err)
+		}
+		if got := p.match(c.path, c.isDir); got != c.want {
+			t.Errorf("pattern %q on %q (dir %v): expected %v, but got %v", c.pattern, c.path, c.isDir, c.want, got)
+		}
+	}
+
+	for _, line := range []string{"", "   ", "# comment", "/"} {
+		if p, err := parseIgnorePattern(line, "", "", 0); p != nil || err != nil {
+			t.Errorf("expected no pattern for %q, but got %+v, %v", line, p, err)
+		}
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	setupRepo(t)
+	global := filepath.Join(t.TempDir(), "ignore")
+	if err := os.WriteFile(global, []byte("*.swp\n*.bak\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := ReadConfig()
+	config.Set("core.excludesFile", global)
+	if err := WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, map[string]string{
+		".tinygit/info/exclude": "secret.txt\n",
+		".tinygitignore":        "*.log\n!keep.log\nbuild/\n",
+		"dir/.tinygitignore":    "!*.bak\n/local.txt\n",
+		"a.txt":                 "a",
+		"a.swp":                 "swap",
+		"a.bak":                 "backup",
+		"a.log":                 "log",
+		"keep.log":              "keep",
+		"secret.txt":            "secret",
+		"build/out.bin":         "out",
+		"build/keep.log":        "keep",
+		"dir/b.txt":             "b",
+		"dir/b.bak":             "backup",
+		"dir/local.txt":         "local",
+		"dir/sub/local.txt":     "local",
+	})
+
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	want := []string{
+		".tinygitignore",
+		"a.txt",
+		"dir/.tinygitignore",
+		"dir/b.bak",
+		"dir/b.txt",
+		"dir/sub/local.txt",
+		"keep.log",
+	}
+	if got := mustLsFiles(t, LsFilesParam{}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+
+	matches, err := CheckIgnore(CheckIgnoreParam{Paths: []string{
+		"a.swp", "a.log", "keep.log", "secret.txt", "build/keep.log", "dir/b.bak", "dir/local.txt", "a.txt",
+	}, NoIndex: true})
+	if err != nil {
+		t.Fatalf("check ignore: %+v", err)
+	}
+	var lines []string
+	for _, m := range matches {
+		lines = append(lines, m.Verbose())
+	}
+	want = []string{
+		global + ":1:*.swp\ta.swp",
+		".tinygitignore:1:*.log\ta.log",
+		".tinygitignore:2:!keep.log\tkeep.log",
+		".tinygit/info/exclude:1:secret.txt\tsecret.txt",
+		".tinygitignore:3:build/\tbuild/keep.log",
+		"dir/.tinygitignore:1:!*.bak\tdir/b.bak",
+		"dir/.tinygitignore:2:/local.txt\tdir/local.txt",
+		"::\ta.txt",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("expected %q, but got %q", want, lines)
+	}
+	if matches[1].Ignored() != true || matches[2].Ignored() != false {
+		t.Fatalf("unexpected ignored state %+v", matches)
+	}
+
+	// tracked files are never ignored
+	matches, err = CheckIgnore(CheckIgnoreParam{Paths: []string{"keep.log"}})
+	if err != nil {
+		t.Fatalf("check ignore: %+v", err)
+	}
+	if matches[0].Pattern != "" {
+		t.Fatalf("expected no pattern for a tracked file, but got %+v", matches[0])
+	}
+
+	// ignored files given explicitly are refused unless forced
+	err = Add(AddParam{Paths: []string{"a.log", "a.txt"}})
+	if err == nil || !strings.Contains(err.Error(), "a.log") {
+		t.Fatalf("expected ignored path error, but got %v", err)
+	}
+	if got := mustLsFiles(t, LsFilesParam{Paths: []string{"a.log"}}); len(got) != 0 {
+		t.Fatalf("expected a.log not added, but got %q", got)
+	}
+	if err := Add(AddParam{Paths: []string{"a.log"}, Force: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if got := mustLsFiles(t, LsFilesParam{Paths: []string{"a.log"}}); len(got) != 1 {
+		t.Fatalf("expected a.log added, but got %q", got)
+	}
+
+	want = []string{"a.bak", "a.swp", "build/keep.log", "build/out.bin", "dir/local.txt", "secret.txt"}
+	if got := mustLsFiles(t, LsFilesParam{Others: true, Ignored: true, ExcludeStandard: true}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+	if got := mustLsFiles(t, LsFilesParam{Others: true, ExcludeStandard: true}); len(got) != 0 {
+		t.Fatalf("expected no untracked files, but got %q", got)
+	}
+
+	report, err := Status(StatusParam{})
+	if err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	for _, entry := range report.Entries {
+		if entry.Untracked() {
+			t.Fatalf("expected no untracked entries, but got %+v", entry)
+		}
+	}
+}
@@ -10,8 +10,11 @@ import (
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/startdusk/tinygit/shared/binarypack"
+	"github.com/startdusk/tinygit/shared/filestat"
 )
 
 // Index represents a index struct.
@@ -50,96 +53,384 @@ func (i Index) Head() ([]byte, error) {
 	return binarypack.Pack(headFormat, values, binary.BigEndian)
 }
 
+// newIndex build the index entry of path from its blob sha1 and file state.
+func newIndex(path, sha1 string, st filestat.FileStat) Index {
+	return Index{
+		CTimeS: st.CreateTime,
+		CTimeN: st.CreateTimeN,
+		MTimeS: st.ModifyTime,
+		MTimeN: st.ModifyTimeN,
+		Dev:    st.Dev,
+		INO:    st.INO,
+		Mode:   st.Mode,
+		UID:    st.UID,
+		GID:    st.GID,
+		Size:   st.Size,
+		Sha1:   sha1,
+		Path:   path,
+	}
+}
+
+// MatchStat report whether the file state is the same as the one recorded in
+// the index entry, in that case the file content does not need to be rehashed.
+func (i Index) MatchStat(st filestat.FileStat) bool {
+	return i.Size == st.Size &&
+		i.MTimeS == st.ModifyTime && i.MTimeN == st.ModifyTimeN &&
+		i.CTimeS == st.CreateTime && i.CTimeN == st.CreateTimeN &&
+		i.INO == st.INO &&
+		i.Mode == st.Mode
+}
+
+// isRacy report whether the entry was modified in the same instant the index
+// file was written, so a later change may keep the recorded state.
+func (i Index) isRacy(indexTime time.Time) bool {
+	if indexTime.IsZero() {
+		return false
+	}
+	mtime := time.Unix(i.MTimeS, i.MTimeN)
+	return !mtime.Before(indexTime)
+}
+
+// File modes recorded in index entries. A gitlink entry records the commit
+// checked out in a submodule.
+const (
+	ModeRegular    uint16 = filestat.ModeRegular
+	ModeExecutable uint16 = filestat.ModeExecutable
+	ModeSymlink    uint16 = filestat.ModeSymlink
+	ModeGitlink    uint16 = 0160000
+)
+
+// Index flags bits, the stage takes the two bits above the 12 bits Git
+// reserves for the path length.
+const (
+	indexStageMask  = 0x3000
+	indexStageShift = 12
+)
+
+// Index flags bits of the per entry states. The assume-valid bit is part of
+// Git's 16 bits flags, the others live in the extended flags Git stores in the
+// upper half. The fsmonitor-valid bit is never written, it is stored as the
+// bitmap of the FSMN extension instead.
+const (
+	indexAssumeValid    = 0x8000
+	indexSkipWorktree   = 0x4000 << 16
+	indexIntentToAdd    = 0x2000 << 16
+	indexFSMonitorValid = 0x1000 << 16
+)
+
+// AssumeUnchanged report whether the working tree file is assumed to match
+// the entry, so it is never checked for modifications.
+func (i Index) AssumeUnchanged() bool {
+	return i.Flags&indexAssumeValid != 0
+}
+
+// SetAssumeUnchanged set or clear the assume-unchanged bit.
+func (i *Index) SetAssumeUnchanged(on bool) {
+	i.setFlag(indexAssumeValid, on)
+}
+
+// SkipWorktree report whether the entry is left out of the working tree, the
+// index version is used as is.
+func (i Index) SkipWorktree() bool {
+	return i.Flags&indexSkipWorktree != 0
+}
+
+// SetSkipWorktree set or clear the skip-worktree bit.
+func (i *Index) SetSkipWorktree(on bool) {
+	i.setFlag(indexSkipWorktree, on)
+}
+
+// IntentToAdd report whether the entry only records that the path will be
+// added later, its content is not staged yet.
+func (i Index) IntentToAdd() bool {
+	return i.Flags&indexIntentToAdd != 0
+}
+
+// SetIntentToAdd set or clear the intent-to-add bit.
+func (i *Index) SetIntentToAdd(on bool) {
+	i.setFlag(indexIntentToAdd, on)
+}
+
+// FSMonitorValid report whether the file system monitor knows the working
+// tree file did not change since the entry was checked.
+func (i Index) FSMonitorValid() bool {
+	return i.Flags&indexFSMonitorValid != 0
+}
+
+// SetFSMonitorValid set or clear the fsmonitor-valid bit.
+func (i *Index) SetFSMonitorValid(on bool) {
+	i.setFlag(indexFSMonitorValid, on)
+}
+
+func (i *Index) setFlag(flag uint32, on bool) {
+	if on {
+		i.Flags |= flag
+	} else {
+		i.Flags &^= flag
+	}
+}
+
+// Merge stages of index entries. Stage 0 is a normal entry, stages 1, 2 and 3
+// hold the common ancestor, our and their versions of a conflicting path.
+const (
+	StageMerged = 0
+	StageBase   = 1
+	StageOurs   = 2
+	StageTheirs = 3
+)
+
+// Stage return the merge stage of the entry.
+func (i Index) Stage() int {
+	return int(i.Flags&indexStageMask) >> indexStageShift
+}
+
+// SetStage set the merge stage of the entry.
+func (i *Index) SetStage(stage int) {
+	i.Flags = i.Flags&^indexStageMask | uint32(stage<<indexStageShift)&indexStageMask
+}
+
 // Indexes represents a index slice for sort.
 type Indexes []Index
 
-func (idxs Indexes) Len() int           { return len(idxs) }
-func (idxs Indexes) Swap(i, j int)      { idxs[i], idxs[j] = idxs[j], idxs[i] }
-func (idxs Indexes) Less(i, j int) bool { return idxs[i].Path < idxs[j].Path }
+func (idxs Indexes) Len() int      { return len(idxs) }
+func (idxs Indexes) Swap(i, j int) { idxs[i], idxs[j] = idxs[j], idxs[i] }
+func (idxs Indexes) Less(i, j int) bool {
+	return indexLess(idxs[i], idxs[j])
+}
+
+// indexLess report whether a sorts before b, by path then stage.
+func indexLess(a, b Index) bool {
+	if a.Path != b.Path {
+		return a.Path < b.Path
+	}
+	return a.Stage() < b.Stage()
+}
 
-// Sort sort the index.
+// Sort sort the index by path then stage.
 func (idxs Indexes) Sort() []Index {
-	sort.Sort(idxs)
+	sort.Stable(idxs)
 	return idxs
 }
 
+// Find return the position of the first entry of path in the sorted indexes
+// and whether it exists, otherwise the position where it would be inserted.
+func (idxs Indexes) Find(path string) (int, bool) {
+	i := sort.Search(len(idxs), func(i int) bool { return idxs[i].Path >= path })
+	return i, i < len(idxs) && idxs[i].Path == path
+}
+
+// entries return the bounds of the entries of path in the sorted indexes.
+func (idxs Indexes) entries(path string) (int, int) {
+	start, _ := idxs.Find(path)
+	end := start
+	for end < len(idxs) && idxs[end].Path == path {
+		end++
+	}
+	return start, end
+}
+
+// Set insert the entry into the sorted indexes. A stage 0 entry replaces
+// every entry of the same path, resolving a conflict, while a conflict stage
+// replaces the stage 0 entry and the entry of the same stage.
+func (idxs Indexes) Set(index Index) Indexes {
+	start, end := idxs.entries(index.Path)
+	kept := make(Indexes, 0, end-start+1)
+	if index.Stage() != StageMerged {
+		for _, entry := range idxs[start:end] {
+			if entry.Stage() != StageMerged && entry.Stage() != index.Stage() {
+				kept = append(kept, entry)
+			}
+		}
+	}
+	kept = append(kept, index)
+	kept.Sort()
+
+	result := make(Indexes, 0, len(idxs)-(end-start)+len(kept))
+	result = append(result, idxs[:start]...)
+	result = append(result, kept...)
+	return append(result, idxs[end:]...)
+}
+
+// Remove delete every entry of path from the sorted indexes.
+func (idxs Indexes) Remove(path string) Indexes {
+	start, end := idxs.entries(path)
+	return append(idxs[:start], idxs[end:]...)
+}
+
+// Unmerged return the sorted paths having conflict stages.
+func (idxs Indexes) Unmerged() []string {
+	var paths []string
+	for _, index := range idxs {
+		if index.Stage() == StageMerged {
+			continue
+		}
+		if len(paths) == 0 || paths[len(paths)-1] != index.Path {
+			paths = append(paths, index.Path)
+		}
+	}
+	return paths
+}
+
+// Stages return the conflict entries of path indexed by stage, a missing
+// stage is left nil.
+func (idxs Indexes) Stages(path string) [4]*Index {
+	var stages [4]*Index
+	start, end := idxs.entries(path)
+	for i := start; i < end; i++ {
+		stages[idxs[i].Stage()] = &idxs[i]
+	}
+	return stages
+}
+
+// Resolve resolve the conflict of path by keeping the entry of the given
+// stage as the stage 0 entry. When the stage is missing, for example a path
+// deleted on that side, the path is removed from the index.
+func (idxs Indexes) Resolve(path string, stage int) (Indexes, error) {
+	if stage < StageBase || stage > StageTheirs {
+		return nil, fmt.Errorf("invalid stage %d", stage)
+	}
+	stages := idxs.Stages(path)
+	if stages[StageBase] == nil && stages[StageOurs] == nil && stages[StageTheirs] == nil {
+		return nil, fmt.Errorf("path '%s' is not unmerged", path)
+	}
+	if stages[stage] == nil {
+		return idxs.Remove(path), nil
+	}
+	resolved := *stages[stage]
+	resolved.SetStage(StageMerged)
+	return idxs.Set(resolved), nil
+}
+
 const (
 	indexSignature = "DIRC"
 	indexVersion   = int32(1)
 
-	headSize     = 108
+	headSize     = 128
 	headerSize   = 12
 	checkSumSize = 40
 )
 
-var indexFile = filepath.Join(RepoRootPath, "index")
+func indexFile() string {
+	return filepath.Join(gitDir(), "index")
+}
 
-var headFormat = []string{"Q", "Q", "Q", "Q", "Q", "Q", "Q", "Q", "Q", "Q", "20s", "Q"}
+// the sha1 is stored as its 40 characters hex string, padded with NUL bytes.
+var headFormat = []string{"Q", "Q", "Q", "Q", "Q", "Q", "Q", "Q", "Q", "Q", "40s", "Q"}
 
 var headerFormat = []string{"4s", "L", "L"}
 
+// indexModTime return the last modification time of the index file, or the
+// zero time if the index does not exist yet.
+func indexModTime() time.Time {
+	info, err := os.Stat(indexFile())
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// indexExtension is an optional section stored after the index entries,
+// identified by its 4 bytes signature.
+type indexExtension struct {
+	Signature string
+	Data      []byte
+}
+
+var extensionFormat = []string{"4s", "L"}
+
+const extensionHeaderSize = 8
+
 // ReadIndex read tinygit index file and return list of Index objects.
 func ReadIndex() (Indexes, error) {
-	indexes := make([]Index, 0)
-	data, err := os.ReadFile(indexFile)
+	indexes, exts, err := readIndexFile(indexFile())
+	if err != nil {
+		return nil, err
+	}
+	for _, ext := range exts {
+		if ext.Signature == linkSignature {
+			if indexes, err = readSplitIndex(indexes, ext); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, ext := range exts {
+		if ext.Signature == fsmonitorSignature {
+			indexes = applyFSMonitor(indexes, ext)
+		}
+	}
+	return indexes, nil
+}
+
+func readIndexFile(path string) (Indexes, []indexExtension, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		switch {
 		case errors.Is(err, fs.ErrNotExist):
-			return indexes, nil
+			return make([]Index, 0), nil, nil
 		default:
-			return nil, fmt.Errorf("read index file: %w", err)
+			return nil, nil, fmt.Errorf("read index file: %w", err)
 		}
 	}
+	return decodeIndex(data)
+}
 
+func decodeIndex(data []byte) (Indexes, []indexExtension, error) {
+	indexes := make([]Index, 0)
+	if len(data) < headerSize+checkSumSize {
+		return nil, nil, errors.New("index file smaller than expected")
+	}
 	digest := sha1Hash(data[:len(data)-checkSumSize])
 	if !reflect.DeepEqual([]byte(digest), data[len(data)-checkSumSize:]) {
-		return nil, errors.New("invalid index checksum")
+		return nil, nil, errors.New("invalid index checksum")
 	}
 
 	header, err := binarypack.UnPack(headerFormat, data[:headerSize], binary.LittleEndian)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if len(header) != 3 {
-		return nil, fmt.Errorf("invalid header: %v", header)
+		return nil, nil, fmt.Errorf("invalid header: %v", header)
 	}
 
 	signature, ok := header[0].(string)
 	if !ok {
-		return nil, fmt.Errorf("invalid signature type")
+		return nil, nil, fmt.Errorf("invalid signature type")
 	}
 	if signature != indexSignature {
-		return nil, fmt.Errorf("invalid signature: `%s`", signature)
+		return nil, nil, fmt.Errorf("invalid signature: `%s`", signature)
 	}
 
 	version, ok := header[1].(int)
 	if !ok {
-		return nil, fmt.Errorf("invalid version type")
+		return nil, nil, fmt.Errorf("invalid version type")
 	}
 	if int32(version) != indexVersion {
-		return nil, fmt.Errorf("unknown index version %d", version)
+		return nil, nil, fmt.Errorf("unknown index version %d", version)
 	}
 
 	indexNum, ok := header[2].(int)
 	if !ok {
-		return nil, fmt.Errorf("invalid index object lenght type")
+		return nil, nil, fmt.Errorf("invalid index object lenght type")
 	}
 
 	indexesBytes := data[headerSize : len(data)-checkSumSize]
 
 	var i int
-	for i+headSize < len(indexesBytes) {
+	for len(indexes) < indexNum && i+headSize < len(indexesBytes) {
 		fieldsEnd := i + headSize
 		fields, err := binarypack.UnPack(headFormat, indexesBytes[i:fieldsEnd], binary.LittleEndian)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		pathEnd := bytes.IndexByte(indexesBytes[fieldsEnd:], '\x00')
+		if pathEnd < 0 {
+			return nil, nil, errors.New("invalid index entry path")
+		}
 		path := indexesBytes[fieldsEnd : fieldsEnd+pathEnd]
 		index, err := parseFieldsToIndex(fields)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		index.Path = string(path)
 		indexes = append(indexes, index)
@@ -147,34 +438,149 @@ func ReadIndex() (Indexes, error) {
 		i += indexLen
 	}
 	if len(indexes) != int(indexNum) {
-		return nil, fmt.Errorf("invalid index num")
+		return nil, nil, fmt.Errorf("invalid index num")
 	}
-	return indexes, nil
+
+	var exts []indexExtension
+	for i < len(indexesBytes) {
+		if i+extensionHeaderSize > len(indexesBytes) {
+			return nil, nil, errors.New("invalid index extension")
+		}
+		fields, err := binarypack.UnPack(extensionFormat, indexesBytes[i:i+extensionHeaderSize], binary.LittleEndian)
+		if err != nil {
+			return nil, nil, err
+		}
+		signature, _ := fields[0].(string)
+		size, _ := fields[1].(int)
+		start := i + extensionHeaderSize
+		if size < 0 || start+size > len(indexesBytes) {
+			return nil, nil, fmt.Errorf("invalid index extension %s", signature)
+		}
+		exts = append(exts, indexExtension{Signature: signature, Data: indexesBytes[start : start+size]})
+		i = start + size
+	}
+	return indexes, exts, nil
 }
 
 // WriteIndex write list of Index objects to tinygit index file.
 func WriteIndex(indexes []Index) error {
+	lock, err := lockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.abort()
+	return writeIndex(lock, indexes)
+}
+
+// writeIndex write the entries through the held lock of the index.
+func writeIndex(lock *indexLock, indexes []Index) error {
+	config, err := ReadConfig()
+	if err != nil {
+		return err
+	}
+	split, err := config.GetBool("core.splitIndex", false)
+	if err != nil {
+		return err
+	}
+	// the fsmonitor-valid bits are written as the extension bitmap only
+	indexes = append(Indexes(nil), indexes...).Sort()
+	exts := fsmonitorExtension(indexes, config)
+	for i := range indexes {
+		indexes[i].SetFSMonitorValid(false)
+	}
+	if split {
+		return writeSplitIndex(lock, indexes, config, exts)
+	}
+	if err := lock.commit(indexes, exts); err != nil {
+		return err
+	}
+	return removeSharedIndexes("")
+}
+
+// indexLockSuffix is appended to the index file name to lock it.
+const indexLockSuffix = ".lock"
+
+// indexLock is the lock of the index, its new content is written to the lock
+// file then renamed over the index. A command reading the entries to write
+// them back holds it in between so no other command writes meanwhile.
+type indexLock struct {
+	file *os.File
+	done bool
+}
+
+// lockIndex create the lock file of the index, failing with fs.ErrExist when
+// another command holds it.
+func lockIndex() (*indexLock, error) {
+	path := indexFile() + indexLockSuffix
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("unable to create '%s': %w, another process seems to be updating the index", path, fs.ErrExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &indexLock{file: f}, nil
+}
+
+// commit write the entries and the extensions to the lock file and rename it
+// over the index.
+func (l *indexLock) commit(indexes []Index, exts []indexExtension) error {
+	data, err := encodeIndex(indexes, exts)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(data); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(l.file.Name(), indexFile()); err != nil {
+		return err
+	}
+	l.done = true
+	return nil
+}
+
+// abort remove the lock file unless it was committed.
+func (l *indexLock) abort() {
+	if l.done {
+		return
+	}
+	l.file.Close()
+	os.Remove(l.file.Name())
+}
+
+func encodeIndex(indexes []Index, exts []indexExtension) ([]byte, error) {
 	var packeds [][]byte
 	for _, index := range indexes {
 		packed, err := makePacked(index)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		packeds = append(packeds, packed)
 	}
 	header, err := binarypack.Pack(headerFormat, []any{indexSignature, indexVersion,
 		int32(len(indexes))}, binary.BigEndian)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	allData := []byte(header)
 	for _, packed := range packeds {
 		allData = append(allData, packed...)
 	}
+	for _, ext := range exts {
+		extHeader, err := binarypack.Pack(extensionFormat, []any{ext.Signature, len(ext.Data)}, binary.BigEndian)
+		if err != nil {
+			return nil, err
+		}
+		allData = append(allData, extHeader...)
+		allData = append(allData, ext.Data...)
+	}
 	digest := sha1Hash(allData)
 	allData = append(allData, []byte(digest)...)
-	return os.WriteFile(indexFile, allData, os.ModePerm)
+	return allData, nil
 }
 
 func makePacked(index Index) ([]byte, error) {
@@ -198,7 +604,6 @@ func makePacked(index Index) ([]byte, error) {
 }
 
 func parseFieldsToIndex(data []any) (Index, error) {
-	fmt.Printf("%v", data)
 	index := Index{}
 	if len(data) != 12 {
 		return index, fmt.Errorf("invalid index params")
@@ -267,7 +672,7 @@ func parseFieldsToIndex(data []any) (Index, error) {
 	if !ok {
 		return index, fmt.Errorf("invalid sha1 type")
 	}
-	index.Sha1 = sha1
+	index.Sha1 = strings.TrimRight(sha1, "\x00")
 
 	flags, ok := data[11].(int)
 	if !ok {
@@ -1,7 +1,13 @@
 package tinygit
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -42,3 +48,177 @@ func TestIndexLifecycle(t *testing.T) {
 		t.Fatalf("write index %+v, but read index %+v", indexes[0], readed[0])
 	}
 }
+
+func stagedIndex(path, sha1 string, stage int) Index {
+	index := Index{Mode: ModeRegular, Sha1: sha1, Path: path}
+	index.SetStage(stage)
+	return index
+}
+
+func TestIndexStages(t *testing.T) {
+	base := strings.Repeat("1", 40)
+	ours := strings.Repeat("2", 40)
+	theirs := strings.Repeat("3", 40)
+
+	indexes := Indexes{
+		stagedIndex("b.txt", theirs, StageTheirs),
+		stagedIndex("c.txt", base, StageMerged),
+		stagedIndex("b.txt", base, StageBase),
+		stagedIndex("a.txt", base, StageMerged),
+		stagedIndex("b.txt", ours, StageOurs),
+	}
+	indexes.Sort()
+	var order []string
+	for _, index := range indexes {
+		order = append(order, fmt.Sprintf("%s:%d", index.Path, index.Stage()))
+	}
+	wantOrder := []string{"a.txt:0", "b.txt:1", "b.txt:2", "b.txt:3", "c.txt:0"}
+	if !reflect.DeepEqual(order, wantOrder) {
+		t.Fatalf("expected order %v, but got %v", wantOrder, order)
+	}
+
+	if err := WriteIndex(indexes); err != nil {
+		t.Fatalf("write index: %+v", err)
+	}
+	readed, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	if !reflect.DeepEqual(readed, indexes) {
+		t.Fatalf("write index %+v, but read index %+v", indexes, readed)
+	}
+
+	if got, want := readed.Unmerged(), []string{"b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected unmerged %v, but got %v", want, got)
+	}
+	stages := readed.Stages("b.txt")
+	if stages[StageMerged] != nil || stages[StageOurs] == nil || stages[StageOurs].Sha1 != ours {
+		t.Fatalf("unexpected stages %+v", stages)
+	}
+
+	resolved, err := readed.Resolve("b.txt", StageTheirs)
+	if err != nil {
+		t.Fatalf("resolve: %+v", err)
+	}
+	if len(resolved.Unmerged()) != 0 {
+		t.Fatalf("expected no unmerged paths, but got %v", resolved.Unmerged())
+	}
+	i, ok := resolved.Find("b.txt")
+	if !ok || resolved[i].Sha1 != theirs || resolved[i].Stage() != StageMerged {
+		t.Fatalf("expected b.txt resolved to theirs, but got %+v", resolved)
+	}
+	if _, err := resolved.Resolve("b.txt", StageOurs); err == nil {
+		t.Fatal("expected error resolving a merged path")
+	}
+
+	// adding a conflict stage replaces the merged entry
+	conflicted := resolved.Set(stagedIndex("a.txt", ours, StageOurs))
+	if got, want := conflicted.Unmerged(), []string{"a.txt"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected unmerged %v, but got %v", want, got)
+	}
+	if len(conflicted) != 3 {
+		t.Fatalf("expected 3 entries, but got %+v", conflicted)
+	}
+}
+
+func TestAddResolvesConflict(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "resolved"})
+	indexes := Indexes{
+		stagedIndex("a.txt", strings.Repeat("1", 40), StageBase),
+		stagedIndex("a.txt", strings.Repeat("2", 40), StageOurs),
+		stagedIndex("a.txt", strings.Repeat("3", 40), StageTheirs),
+	}
+	if err := WriteIndex(indexes); err != nil {
+		t.Fatalf("write index: %+v", err)
+	}
+	if err := Add(AddParam{Paths: []string{"a.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	readed, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	if len(readed) != 1 || readed[0].Stage() != StageMerged {
+		t.Fatalf("expected a single merged entry, but got %+v", readed)
+	}
+}
+
+func TestIndexLockConcurrentCommands(t *testing.T) {
+	setupRepo(t)
+	const n = 8
+	files := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		files[fmt.Sprintf("f%d.txt", i)] = fmt.Sprint(i)
+	}
+	writeFiles(t, files)
+
+	// each add retries while another one holds the lock, none of the
+	// entries is lost by a concurrent write
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for name := range files {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for {
+				err := Add(AddParam{Paths: []string{name}})
+				if !errors.Is(err, fs.ErrExist) {
+					errs <- err
+					return
+				}
+			}
+		}(name)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("add: %+v", err)
+		}
+	}
+	readed, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	if len(readed) != n {
+		t.Fatalf("expected %d entries, but got %+v", n, readed)
+	}
+	commitIndex(t, "init")
+
+	// the commands writing the index fail while the lock is held
+	writeFiles(t, map[string]string{filepath.Join(RepoRootPath, "index.lock"): "held"})
+	commands := map[string]func() error{
+		"add": func() error { return Add(AddParam{All: true}) },
+		"update-index": func() error {
+			return UpdateIndex(UpdateIndexParam{Refresh: true})
+		},
+		"rm": func() error {
+			_, err := Rm(RmParam{Paths: []string{"f0.txt"}})
+			return err
+		},
+		"mv": func() error {
+			_, err := Mv(MvParam{Sources: []string{"f0.txt"}, Destination: "g.txt"})
+			return err
+		},
+		"reset": func() error {
+			_, err := Reset(ResetParam{Mode: "hard"})
+			return err
+		},
+		"restore": func() error {
+			return Restore(RestoreParam{Paths: []string{"f0.txt"}, Staged: true})
+		},
+		"stash": func() error {
+			_, err := StashPush(StashPushParam{})
+			return err
+		},
+	}
+	for name, command := range commands {
+		if err := command(); !errors.Is(err, fs.ErrExist) {
+			t.Fatalf("%s: expected the held lock to be refused, but got %v", name, err)
+		}
+	}
+	if got := mustLsFiles(t, LsFilesParam{}); len(got) != n {
+		t.Fatalf("expected the index to be kept, but got %q", got)
+	}
+}
@@ -0,0 +1,308 @@
+package tinygit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lfsFilterName is the filter attribute value of the files stored as large
+// file pointers, handled by tinygit itself unless filter.lfs is configured.
+const lfsFilterName = "lfs"
+
+// lfsSpecVersion is the version line of the pointers, as written by Git LFS.
+const lfsSpecVersion = "https://git-lfs.github.com/spec/v1"
+
+// lfsMaxPointerSize bounds the size of a blob parsed as a pointer.
+const lfsMaxPointerSize = 1024
+
+// lfsAttributes are the attributes given to the tracked patterns.
+const lfsAttributes = "filter=lfs diff=lfs merge=lfs -text"
+
+func lfsObjectsPath() string {
+	return filepath.Join(commonDir(), "lfs", "objects")
+}
+
+// lfsPointer is the blob standing for a large file, the content itself is
+// stored under .tinygit/lfs/objects by its sha256.
+type lfsPointer struct {
+	Oid  string
+	Size int64
+}
+
+// Bytes return the pointer blob content.
+func (p lfsPointer) Bytes() []byte {
+	return []byte(fmt.Sprintf("version %s\noid sha256:%s\nsize %d\n", lfsSpecVersion, p.Oid, p.Size))
+}
+
+// parseLFSPointer parse the blob content as a pointer. The version comes
+// first and the other keys are sorted, unknown keys are allowed.
+func parseLFSPointer(data []byte) (lfsPointer, bool) {
+	var p lfsPointer
+	if len(data) > lfsMaxPointerSize || !bytes.HasPrefix(data, []byte("version ")) {
+		return p, false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	previous := ""
+	hasOid, hasSize := false, false
+	for line := 0; scanner.Scan(); line++ {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			return p, false
+		}
+		switch {
+		case line == 0:
+			if key != "version" || value != lfsSpecVersion {
+				return p, false
+			}
+			continue
+		case key <= previous:
+			return p, false
+		case key == "oid":
+			oid := strings.TrimPrefix(value, "sha256:")
+			if oid == value || len(oid) != 64 || strings.Trim(oid, "0123456789abcdef") != "" {
+				return p, false
+			}
+			p.Oid, hasOid = oid, true
+		case key == "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return p, false
+			}
+			p.Size, hasSize = size, true
+		}
+		previous = key
+	}
+	return p, hasOid && hasSize && bytes.HasSuffix(data, []byte("\n"))
+}
+
+// lfsObjectFile return the file of the large file content of the oid.
+func lfsObjectFile(oid string) string {
+	return filepath.Join(lfsObjectsPath(), oid[:2], oid[2:4], oid)
+}
+
+// lfsClean store the content read from r in the large file store and return
+// its pointer. A pointer is kept as is. The content is streamed through its
+// sha256 into a temporary file, never held in memory.
+func lfsClean(r io.Reader) ([]byte, error) {
+	br := bufio.NewReaderSize(r, lfsMaxPointerSize+1)
+	head, err := br.Peek(lfsMaxPointerSize + 1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if _, ok := parseLFSPointer(head); ok {
+		return append([]byte(nil), head...), nil
+	}
+	tmpDir := filepath.Join(commonDir(), "lfs", "tmp")
+	if err := os.MkdirAll(tmpDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	// the content is written aside then renamed, never leaving a partial
+	// object under its name
+	tmp, err := os.CreateTemp(tmpDir, "tmp_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), br)
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	p := lfsPointer{Oid: hex.EncodeToString(hash.Sum(nil)), Size: size}
+	file := lfsObjectFile(p.Oid)
+	if _, err := os.Stat(file); err == nil {
+		return p.Bytes(), nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return nil, err
+	}
+	return p.Bytes(), nil
+}
+
+// lfsSmudge return the content of the pointer from the large file store.
+// Content which is not a pointer, and pointers whose content is not in the
+// store, are kept as is.
+func lfsSmudge(data []byte) ([]byte, error) {
+	file, p, err := lfsOpen(data)
+	if err != nil || file == nil {
+		return data, err
+	}
+	defer file.Close()
+	var b bytes.Buffer
+	if err := lfsCopy(&b, file, p); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// lfsOpen open the store file of the pointer held by the data, nil when the
+// data is not a pointer or its content is not in the store.
+func lfsOpen(data []byte) (*os.File, lfsPointer, error) {
+	p, ok := parseLFSPointer(data)
+	if !ok {
+		return nil, p, nil
+	}
+	file, err := os.Open(lfsObjectFile(p.Oid))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, p, nil
+	}
+	return file, p, err
+}
+
+// lfsCopy stream the content of the store file to w through its sha256,
+// failing when its size or its sha256 does not match the pointer.
+func lfsCopy(w io.Writer, r io.Reader, p lfsPointer) error {
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w, hash), r)
+	if err != nil {
+		return err
+	}
+	if size != p.Size {
+		return fmt.Errorf("large file %s has size %d, expected %d", p.Oid, size, p.Size)
+	}
+	if oid := hex.EncodeToString(hash.Sum(nil)); oid != p.Oid {
+		return fmt.Errorf("large file %s is corrupt, its content has sha256 %s", p.Oid, oid)
+	}
+	return nil
+}
+
+// LFSTracked return the patterns of the top level .gitattributes file stored
+// as large file pointers.
+func LFSTracked() ([]string, error) {
+	file, err := readAttributesFile(AttributesFileName, "", AttributesFileName, true)
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, line := range file.lines {
+		for _, state := range line.states {
+			if state == "filter="+lfsFilterName {
+				patterns = append(patterns, line.pattern.text)
+				break
+			}
+		}
+	}
+	return patterns, nil
+}
+
+// LFSTrack add the patterns to the top level .gitattributes file so the
+// matching files are stored as large file pointers, and return a message
+// for each pattern.
+func LFSTrack(patterns []string) ([]string, error) {
+	tracked, err := LFSTracked()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(AttributesFileName)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	var messages []string
+	for _, pattern := range patterns {
+		if contains(tracked, pattern) {
+			messages = append(messages, fmt.Sprintf("\"%s\" already supported", pattern))
+			continue
+		}
+		data = append(data, pattern+" "+lfsAttributes+"\n"...)
+		tracked = append(tracked, pattern)
+		messages = append(messages, fmt.Sprintf("Tracking \"%s\"", pattern))
+	}
+	return messages, os.WriteFile(AttributesFileName, data, 0644)
+}
+
+// LFSUntrack remove the lines of the patterns from the top level
+// .gitattributes file and return a message for each removed pattern.
+func LFSUntrack(patterns []string) ([]string, error) {
+	data, err := os.ReadFile(AttributesFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var kept []string
+	var messages []string
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && contains(patterns, fields[0]) && contains(fields[1:], "filter="+lfsFilterName) {
+			messages = append(messages, fmt.Sprintf("Untracking \"%s\"", fields[0]))
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return messages, os.WriteFile(AttributesFileName, []byte(strings.Join(kept, "")), 0644)
+}
+
+// LFSFile is an index entry stored as a large file pointer.
+type LFSFile struct {
+	Path string
+	Oid  string
+	Size int64
+	// Present reports whether the content is in the large file store.
+	Present bool
+}
+
+// String format the file as "<short oid> <*|-> <path>", the star telling
+// the content is present.
+func (f LFSFile) String() string {
+	mark := "-"
+	if f.Present {
+		mark = "*"
+	}
+	return fmt.Sprintf("%s %s %s", f.Oid[:10], mark, f.Path)
+}
+
+// LFSLsFiles return the index entries whose blob is a large file pointer.
+func LFSLsFiles() ([]LFSFile, error) {
+	indexes, err := ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	var files []LFSFile
+	for _, index := range indexes {
+		if index.Stage() != StageMerged || index.Mode&0170000 != 0100000 {
+			continue
+		}
+		obj, err := ReadObject(index.Sha1)
+		if err != nil {
+			return nil, err
+		}
+		p, ok := parseLFSPointer(obj.Data)
+		if !ok {
+			continue
+		}
+		_, err = os.Stat(lfsObjectFile(p.Oid))
+		files = append(files, LFSFile{Path: index.Path, Oid: p.Oid, Size: p.Size, Present: err == nil})
+	}
+	return files, nil
+}
+
+// contains report whether the string is one of the list.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package tinygit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseLFSPointer(t *testing.T) {
+	oid := strings.Repeat("ab", 32)
+	valid := "version https://git-lfs.github.com/spec/v1\noid sha256:" + oid + "\nsize 12345\n"
+	if p, ok := parseLFSPointer([]byte(valid)); !ok || p.Oid != oid || p.Size != 12345 {
+		t.Fatalf("expected a pointer, but got %+v, %v", p, ok)
+	}
+	if got := (lfsPointer{Oid: oid, Size: 12345}).Bytes(); string(got) != valid {
+		t.Fatalf("expected %q, but got %q", valid, got)
+	}
+	for _, data := range []string{
+		"",
+		"plain text\n",
+		"version https://git-lfs.github.com/spec/v1\noid sha256:" + oid + "\n",
+		"version https://git-lfs.github.com/spec/v1\nsize 1\noid sha256:" + oid + "\n",
+		"version https://git-lfs.github.com/spec/v1\noid sha256:xyz\nsize 1\n",
+		"version https://git-lfs.github.com/spec/v1\noid sha256:" + oid + "\nsize -1\n",
+		"version https://example.com/v2\noid sha256:" + oid + "\nsize 1\n",
+	} {
+		if _, ok := parseLFSPointer([]byte(data)); ok {
+			t.Errorf("expected %q not to be a pointer", data)
+		}
+	}
+}
+
+func TestLFS(t *testing.T) {
+	setupRepo(t)
+	messages, err := LFSTrack([]string{"*.bin", "*.bin"})
+	if err != nil {
+		t.Fatalf("track: %+v", err)
+	}
+	if want := []string{`Tracking "*.bin"`, `"*.bin" already supported`}; !reflect.DeepEqual(messages, want) {
+		t.Fatalf("expected %q, but got %q", want, messages)
+	}
+	if tracked, _ := LFSTracked(); !reflect.DeepEqual(tracked, []string{"*.bin"}) {
+		t.Fatalf("expected *.bin to be tracked, but got %q", tracked)
+	}
+
+	content := strings.Repeat("\x00large\r\n", 1000)
+	writeFiles(t, map[string]string{"data.bin": content, "a.txt": "a"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	oid := hex.EncodeToString(sum[:])
+	pointer := lfsPointer{Oid: oid, Size: int64(len(content))}
+	if got := blobOf(t, "data.bin"); got != string(pointer.Bytes()) {
+		t.Fatalf("expected the pointer blob, but got %q", got)
+	}
+	if data, err := os.ReadFile(lfsObjectFile(oid)); err != nil || string(data) != content {
+		t.Fatalf("expected the content in the store, but got %d bytes, %v", len(data), err)
+	}
+	files, err := LFSLsFiles()
+	if err != nil {
+		t.Fatalf("ls-files: %+v", err)
+	}
+	if len(files) != 1 || files[0].String() != oid[:10]+" * data.bin" {
+		t.Fatalf("unexpected large files %+v", files)
+	}
+
+	// checkout materializes the content back
+	if err := os.Remove("data.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(RestoreParam{Paths: []string{"data.bin"}}); err != nil {
+		t.Fatalf("restore: %+v", err)
+	}
+	assertFiles(t, map[string]string{"data.bin": content})
+	report, err := Status(StatusParam{})
+	if err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	if got := report.Short(false); !reflect.DeepEqual(got, []string{"A  .gitattributes", "A  a.txt", "A  data.bin"}) {
+		t.Fatalf("unexpected status %q", got)
+	}
+
+	// content which does not hash to the oid is refused
+	corrupt := strings.Repeat("\x00LARGE\r\n", 1000)
+	if err := os.WriteFile(lfsObjectFile(oid), []byte(corrupt), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove("data.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(RestoreParam{Paths: []string{"data.bin"}}); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Fatalf("expected the corrupt content to be refused, but got %v", err)
+	}
+	if _, err := os.Lstat("data.bin"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected no partial file, but got %v", err)
+	}
+
+	// without its content the pointer is checked out
+	if err := os.RemoveAll(lfsObjectsPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(RestoreParam{Paths: []string{"data.bin"}}); err != nil {
+		t.Fatalf("restore: %+v", err)
+	}
+	assertFiles(t, map[string]string{"data.bin": string(pointer.Bytes())})
+	if files, _ := LFSLsFiles(); len(files) != 1 || files[0].Present {
+		t.Fatalf("expected the content to be missing, but got %+v", files)
+	}
+
+	messages, err = LFSUntrack([]string{"*.bin"})
+	if err != nil {
+		t.Fatalf("untrack: %+v", err)
+	}
+	if want := []string{`Untracking "*.bin"`}; !reflect.DeepEqual(messages, want) {
+		t.Fatalf("expected %q, but got %q", want, messages)
+	}
+	if tracked, _ := LFSTracked(); len(tracked) != 0 {
+		t.Fatalf("expected no tracked pattern, but got %q", tracked)
+	}
+}
+
+func TestLFSClean(t *testing.T) {
+	setupRepo(t)
+	content := strings.Repeat("large file\n", 1000)
+	sum := sha256.Sum256([]byte(content))
+	want := lfsPointer{Oid: hex.EncodeToString(sum[:]), Size: int64(len(content))}.Bytes()
+	// the content is read in small pieces, never as a whole
+	got, err := lfsClean(iotest.OneByteReader(strings.NewReader(content)))
+	if err != nil {
+		t.Fatalf("clean: %+v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("expected pointer %q, but got %q", want, got)
+	}
+	if data, err := os.ReadFile(lfsObjectFile(hex.EncodeToString(sum[:]))); err != nil || string(data) != content {
+		t.Fatalf("expected the content in the store, but got %d bytes, %v", len(data), err)
+	}
+	if tmps, _ := os.ReadDir(filepath.Join(commonDir(), "lfs", "tmp")); len(tmps) != 0 {
+		t.Fatalf("expected no temporary file left, but got %d", len(tmps))
+	}
+	// a pointer is kept as is
+	if got, err = lfsClean(strings.NewReader(string(want))); err != nil || string(got) != string(want) {
+		t.Fatalf("expected the pointer to be kept, but got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,169 @@
+package tinygit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// LsFilesParam ls-files command params.
+type LsFilesParam struct {
+	Paths []string
+	// Cached shows the files in the index, the default when nothing else is asked.
+	Cached bool
+	// Deleted shows the index entries whose file is missing.
+	Deleted bool
+	// Modified shows the index entries whose file differs, including deleted ones.
+	Modified bool
+	// Others shows the untracked files of the working tree.
+	Others bool
+	// Ignored shows only the files matching the exclude patterns.
+	Ignored bool
+	// Stage shows the mode, sha1 and stage number of index entries.
+	Stage bool
+	// Unmerged shows only the conflict stages of unmerged paths, implies Stage.
+	Unmerged bool
+	// Tags prefixes each line with the status tag of the file.
+	Tags bool
+	// Verbose is like Tags but uses lowercase tags for assume-unchanged entries.
+	Verbose bool
+	// Excludes are ignore patterns of files to skip, given on the command line.
+	Excludes []string
+	// ExcludeStandard also skips the files ignored by the ignore files of the
+	// repository.
+	ExcludeStandard bool
+}
+
+// LsFiles show information about files in the index and the working tree,
+// one line per file.
+func LsFiles(param LsFilesParam) ([]string, error) {
+	if param.Ignored && !param.Others && !param.Cached {
+		return nil, errors.New("ls-files -i must be used with either -o or -c")
+	}
+	if !param.Cached && !param.Deleted && !param.Modified && !param.Others {
+		param.Cached = true
+	}
+	if param.Unmerged {
+		param.Stage = true
+	}
+	if param.Verbose {
+		param.Tags = true
+	}
+	tag := func(tag string, index Index, line string) string {
+		if !param.Tags {
+			return line
+		}
+		if param.Verbose && index.AssumeUnchanged() {
+			tag = strings.ToLower(tag)
+		}
+		return tag + " " + line
+	}
+	if param.Stage {
+		param.Cached = true
+	}
+	specs := []string{"."}
+	if len(param.Paths) > 0 {
+		specs = make([]string, len(param.Paths))
+		for i, p := range param.Paths {
+			rel, err := repoRelPath(p)
+			if err != nil {
+				return nil, err
+			}
+			specs[i] = rel
+		}
+	}
+	rules, err := newIgnoreRules(param.Excludes, param.ExcludeStandard)
+	if err != nil {
+		return nil, err
+	}
+	// without --ignored ignored untracked files are hidden, with it only the
+	// ignored files are shown
+	wanted := func(p string, tracked bool) (bool, error) {
+		if !matchAnyPathspec(specs, p) {
+			return false, nil
+		}
+		if tracked && !param.Ignored {
+			return true, nil
+		}
+		ignored, err := rules.ignored(p, false)
+		return ignored == param.Ignored, err
+	}
+
+	indexes, err := ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	if param.Others {
+		walkRules := rules
+		if param.Ignored {
+			// ignored directories must be walked to list their files
+			walkRules = nil
+		}
+		var others []string
+		err := walkWorktree(walkRules, func(p string, info fs.FileInfo) error {
+			if _, tracked := indexes.Find(p); tracked {
+				return nil
+			}
+			if info.IsDir() {
+				p += "/"
+			}
+			ok, err := wanted(p, false)
+			if ok {
+				others = append(others, tag("?", Index{}, p))
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(others)
+		lines = append(lines, others...)
+	}
+
+	filter, err := newConvertFilter()
+	if err != nil {
+		return nil, err
+	}
+	indexTime := indexModTime()
+	for _, index := range indexes {
+		ok, err := wanted(index.Path, true)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || param.Unmerged && index.Stage() == StageMerged {
+			continue
+		}
+		line := index.Path
+		if param.Stage {
+			line = fmt.Sprintf("%06o %s %d\t%s", index.Mode, index.Sha1, index.Stage(), index.Path)
+		}
+		if param.Cached {
+			switch {
+			case index.Stage() != StageMerged:
+				lines = append(lines, tag("M", index, line))
+			case index.SkipWorktree():
+				lines = append(lines, tag("S", index, line))
+			default:
+				lines = append(lines, tag("H", index, line))
+			}
+		}
+		if !param.Deleted && !param.Modified {
+			continue
+		}
+		change, err := index.worktreeChange(filter, indexTime)
+		if err != nil {
+			return nil, err
+		}
+		if param.Deleted && change == worktreeDeleted {
+			lines = append(lines, tag("R", index, line))
+		}
+		if param.Modified && change != worktreeUnchanged {
+			lines = append(lines, tag("C", index, line))
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,86 @@
+package tinygit
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLsFiles(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{
+		"a.txt":     "a",
+		"b.txt":     "b",
+		"dir/c.txt": "c",
+	})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	writeFiles(t, map[string]string{
+		"b.txt":     "changed",
+		"new.txt":   "new",
+		"dir/d.log": "log",
+	})
+	if err := os.Remove("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	sha1, _, _ := HashObject(HashParam{Data: []byte("c"), ObjType: Blob})
+
+	cases := []struct {
+		name  string
+		param LsFilesParam
+		want  []string
+	}{
+		{
+			name:  "cached",
+			param: LsFilesParam{},
+			want:  []string{"a.txt", "b.txt", "dir/c.txt"},
+		},
+		{
+			name:  "deleted",
+			param: LsFilesParam{Deleted: true},
+			want:  []string{"a.txt"},
+		},
+		{
+			name:  "modified",
+			param: LsFilesParam{Modified: true},
+			want:  []string{"a.txt", "b.txt"},
+		},
+		{
+			name:  "others",
+			param: LsFilesParam{Others: true},
+			want:  []string{"dir/d.log", "new.txt"},
+		},
+		{
+			name:  "others_exclude",
+			param: LsFilesParam{Others: true, Excludes: []string{"*.log"}},
+			want:  []string{"new.txt"},
+		},
+		{
+			name:  "others_ignored",
+			param: LsFilesParam{Others: true, Ignored: true, Excludes: []string{"*.log"}},
+			want:  []string{"dir/d.log"},
+		},
+		{
+			name:  "stage_pathspec",
+			param: LsFilesParam{Stage: true, Paths: []string{"dir"}},
+			want:  []string{"100644 " + sha1 + " 0\tdir/c.txt"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := LsFiles(c.param)
+			if err != nil {
+				t.Fatalf("ls-files: %+v", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("expected %q, but got %q", c.want, got)
+			}
+		})
+	}
+
+	if _, err := LsFiles(LsFilesParam{Ignored: true}); err == nil {
+		t.Fatal("expected error for --ignored without -o or -c")
+	}
+}
@@ -0,0 +1,146 @@
+package tinygit
+
+import (
+	"bytes"
+	"strings"
+)
+
+// maxDiffCells bounds the size of the table used to diff two files, beyond
+// it the differing middle parts of the files are not matched line by line.
+const maxDiffCells = 1 << 24
+
+// splitLines split the data into lines which keep their line ending.
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n') + 1
+		if end == 0 {
+			end = len(data)
+		}
+		lines = append(lines, string(data[:end]))
+		data = data[end:]
+	}
+	return lines
+}
+
+// lcsMatches return for each line of a the index of the line of b it is
+// matched with in a longest common subsequence of the lines, -1 when the line
+// is not matched.
+func lcsMatches(a, b []string) []int {
+	matches := make([]int, len(a))
+	for i := range matches {
+		matches[i] = -1
+	}
+	// common prefix and suffix are matched without the table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		matches[prefix] = prefix
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		matches[len(a)-1-suffix] = len(b) - 1 - suffix
+		suffix++
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(ma) == 0 || len(mb) == 0 || len(ma)*len(mb) > maxDiffCells {
+		return matches
+	}
+
+	// lengths[i][j] is the length of the LCS of ma[i:] and mb[j:]
+	width := len(mb) + 1
+	lengths := make([]int32, (len(ma)+1)*width)
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			switch {
+			case ma[i] == mb[j]:
+				lengths[i*width+j] = lengths[(i+1)*width+j+1] + 1
+			case lengths[(i+1)*width+j] >= lengths[i*width+j+1]:
+				lengths[i*width+j] = lengths[(i+1)*width+j]
+			default:
+				lengths[i*width+j] = lengths[i*width+j+1]
+			}
+		}
+	}
+	for i, j := 0, 0; i < len(ma) && j < len(mb); {
+		switch {
+		case ma[i] == mb[j]:
+			matches[prefix+i] = prefix + j
+			i++
+			j++
+		case lengths[(i+1)*width+j] >= lengths[i*width+j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return matches
+}
+
+// mergeFile merge the changes made from base to ours and from base to
+// theirs line by line. Changes of both sides to the same lines are
+// conflicts, written between conflict markers with the labels of the sides.
+// Binary content is never merged, ours is returned as a conflict.
+func mergeFile(base, ours, theirs []byte, oursLabel, theirsLabel string) ([]byte, bool) {
+	if bytes.IndexByte(base, 0) >= 0 || bytes.IndexByte(ours, 0) >= 0 || bytes.IndexByte(theirs, 0) >= 0 {
+		return ours, !bytes.Equal(ours, theirs)
+	}
+	b, o, t := splitLines(base), splitLines(ours), splitLines(theirs)
+	mo, mt := lcsMatches(b, o), lcsMatches(b, t)
+
+	var out strings.Builder
+	conflict := false
+	equal := func(x, y []string) bool {
+		return strings.Join(x, "") == strings.Join(y, "")
+	}
+	write := func(lines []string) {
+		for _, line := range lines {
+			out.WriteString(line)
+		}
+	}
+	writeSide := func(lines []string) {
+		write(lines)
+		if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+			out.WriteString("\n")
+		}
+	}
+
+	lb, lo, lt := 0, 0, 0
+	for lb < len(b) || lo < len(o) || lt < len(t) {
+		// lines unchanged on both sides are copied
+		stable := 0
+		for lb+stable < len(b) && mo[lb+stable] == lo+stable && mt[lb+stable] == lt+stable {
+			stable++
+		}
+		if stable > 0 {
+			write(b[lb : lb+stable])
+			lb, lo, lt = lb+stable, lo+stable, lt+stable
+			continue
+		}
+		// the changed chunk ends at the next base line kept by both sides
+		next := lb
+		for next < len(b) && (mo[next] < 0 || mt[next] < 0) {
+			next++
+		}
+		endO, endT := len(o), len(t)
+		if next < len(b) {
+			endO, endT = mo[next], mt[next]
+		}
+		cb, co, ct := b[lb:next], o[lo:endO], t[lt:endT]
+		switch {
+		case equal(co, cb):
+			write(ct)
+		case equal(ct, cb), equal(co, ct):
+			write(co)
+		default:
+			conflict = true
+			out.WriteString("<<<<<<< " + oursLabel + "\n")
+			writeSide(co)
+			out.WriteString("=======\n")
+			writeSide(ct)
+			out.WriteString(">>>>>>> " + theirsLabel + "\n")
+		}
+		lb, lo, lt = next, endO, endT
+	}
+	return []byte(out.String()), conflict
+}
@@ -0,0 +1,52 @@
+package tinygit
+
+import "testing"
+
+func TestMergeFile(t *testing.T) {
+	base := "1\n2\n3\n4\n5\n"
+	cases := []struct {
+		name     string
+		ours     string
+		theirs   string
+		want     string
+		conflict bool
+	}{
+		{name: "unchanged", ours: base, theirs: base, want: base},
+		{name: "ours", ours: "1\ntwo\n3\n4\n5\n", theirs: base, want: "1\ntwo\n3\n4\n5\n"},
+		{name: "theirs", ours: base, theirs: "1\n2\n3\n4\n5\n6\n", want: "1\n2\n3\n4\n5\n6\n"},
+		{
+			name:   "both",
+			ours:   "0\n1\ntwo\n3\n4\n5\n",
+			theirs: "1\n2\n3\nfour\n5\n",
+			want:   "0\n1\ntwo\n3\nfour\n5\n",
+		},
+		{name: "same change", ours: "1\n2\nthree\n4\n5\n", theirs: "1\n2\nthree\n4\n5\n", want: "1\n2\nthree\n4\n5\n"},
+		{name: "deletions", ours: "2\n3\n4\n5\n", theirs: "1\n2\n3\n4\n", want: "2\n3\n4\n"},
+		{
+			name:     "conflict",
+			ours:     "1\n2\nours\n4\n5\n",
+			theirs:   "1\n2\ntheirs\n4\n5\n",
+			want:     "1\n2\n<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\n4\n5\n",
+			conflict: true,
+		},
+		{
+			name:     "no trailing newline",
+			ours:     "1\n2\n3\n4\nours",
+			theirs:   "1\n2\n3\n4\ntheirs",
+			want:     "1\n2\n3\n4\n<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\n",
+			conflict: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, conflict := mergeFile([]byte(base), []byte(c.ours), []byte(c.theirs), "ours", "theirs")
+			if string(got) != c.want || conflict != c.conflict {
+				t.Fatalf("expected %q (conflict %v), but got %q (conflict %v)", c.want, c.conflict, got, conflict)
+			}
+		})
+	}
+
+	if got, conflict := mergeFile([]byte("a\x00"), []byte("b\x00"), []byte("c\x00"), "ours", "theirs"); !conflict || string(got) != "b\x00" {
+		t.Fatalf("expected binary conflict keeping ours, but got %q, %v", got, conflict)
+	}
+}
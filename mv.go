@@ -0,0 +1,154 @@
+package tinygit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/startdusk/tinygit/shared/filestat"
+)
+
+// MvParam mv command params.
+type MvParam struct {
+	// Sources are moved to Destination, which must be a directory when
+	// there are several sources.
+	Sources     []string
+	Destination string
+	// Force overwrites an existing destination file.
+	Force bool
+	// DryRun only reports the renames which would be done.
+	DryRun bool
+}
+
+// Rename is a move done by Mv.
+type Rename struct {
+	Source      string
+	Destination string
+}
+
+// Mv move or rename tracked files and directories in the working tree and
+// the index. The moved entries keep their sha1 and are not rehashed.
+func Mv(param MvParam) ([]Rename, error) {
+	if len(param.Sources) == 0 || param.Destination == "" {
+		return nil, errors.New("usage: tinygit mv [<options>] <source>... <destination>")
+	}
+	dst, err := repoRelPath(param.Destination)
+	if err != nil {
+		return nil, err
+	}
+	dstInfo, err := os.Stat(filepath.FromSlash(dst))
+	intoDir := err == nil && dstInfo.IsDir()
+	if len(param.Sources) > 1 && !intoDir {
+		return nil, fmt.Errorf("destination '%s' is not a directory", param.Destination)
+	}
+	lock, err := lockIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.abort()
+	indexes, err := ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var renames []Rename
+	for _, source := range param.Sources {
+		src, err := repoRelPath(source)
+		if err != nil {
+			return nil, err
+		}
+		target := dst
+		if intoDir {
+			target = path.Join(dst, path.Base(src))
+		}
+		if err := checkMove(indexes, src, target, param.Force); err != nil {
+			return nil, fmt.Errorf("%w, source=%s, destination=%s", err, src, target)
+		}
+		renames = append(renames, Rename{Source: src, Destination: target})
+	}
+	if param.DryRun {
+		return renames, nil
+	}
+
+	for _, rename := range renames {
+		var moved Indexes
+		for _, index := range indexes {
+			if matchPathspec(rename.Source, index.Path) {
+				moved = append(moved, index)
+			}
+		}
+		// the stat information is kept for files unchanged before the move
+		indexTime := indexModTime()
+		unchanged := make(map[string]bool)
+		for _, index := range moved {
+			st, err := filestat.Stat(filepath.FromSlash(index.Path))
+			unchanged[index.Path] = err == nil && index.MatchStat(st) && !index.isRacy(indexTime)
+		}
+		if err := os.Rename(filepath.FromSlash(rename.Source), filepath.FromSlash(rename.Destination)); err != nil {
+			return nil, err
+		}
+		for _, index := range moved {
+			indexes = indexes.Remove(index.Path)
+		}
+		// an existing destination entry is overwritten
+		indexes = indexes.Remove(rename.Destination)
+		for _, index := range moved {
+			renamed := index
+			renamed.Path = rename.Destination + index.Path[len(rename.Source):]
+			if unchanged[index.Path] {
+				st, err := filestat.Stat(filepath.FromSlash(renamed.Path))
+				if err != nil {
+					return nil, err
+				}
+				renamed = newIndex(renamed.Path, index.Sha1, st)
+				renamed.Mode = index.Mode
+				renamed.Flags = index.Flags
+			}
+			indexes = append(indexes, renamed)
+		}
+		indexes = Indexes(indexes.Sort())
+	}
+	return renames, writeIndex(lock, indexes)
+}
+
+// checkMove report why the source can not be moved to the destination.
+func checkMove(indexes Indexes, src, dst string, force bool) error {
+	if src == "." {
+		return errors.New("bad source")
+	}
+	srcInfo, err := os.Lstat(filepath.FromSlash(src))
+	if errors.Is(err, fs.ErrNotExist) {
+		return errors.New("bad source")
+	}
+	if err != nil {
+		return err
+	}
+	tracked := false
+	for _, index := range indexes {
+		if !matchPathspec(src, index.Path) {
+			continue
+		}
+		if index.Stage() != StageMerged {
+			return errors.New("conflicted")
+		}
+		tracked = true
+	}
+	if !tracked {
+		return errors.New("not under version control")
+	}
+	if matchPathspec(src, dst) {
+		return errors.New("can not move directory into itself")
+	}
+	if _, err := os.Stat(filepath.Dir(filepath.FromSlash(dst))); err != nil {
+		return errors.New("destination directory does not exist")
+	}
+	if dstInfo, err := os.Lstat(filepath.FromSlash(dst)); err == nil {
+		if srcInfo.IsDir() || dstInfo.IsDir() || !force {
+			return errors.New("destination exists")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package tinygit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMv(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{
+		"a.txt":       "a",
+		"b.txt":       "b",
+		"dir/c.txt":   "c",
+		"dir/d.txt":   "d",
+		"other/.keep": "",
+		"untracked":   "u",
+	})
+	if err := Add(AddParam{Paths: []string{"a.txt", "b.txt", "dir", "other"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	indexes, _ := ReadIndex()
+	i, _ := indexes.Find("a.txt")
+	sha1 := indexes[i].Sha1
+
+	renames, err := Mv(MvParam{Sources: []string{"a.txt"}, Destination: "renamed.txt"})
+	if err != nil {
+		t.Fatalf("mv: %+v", err)
+	}
+	if !reflect.DeepEqual(renames, []Rename{{Source: "a.txt", Destination: "renamed.txt"}}) {
+		t.Fatalf("unexpected renames %+v", renames)
+	}
+	assertFiles(t, map[string]string{"renamed.txt": "a"})
+	indexes, _ = ReadIndex()
+	if i, ok := indexes.Find("renamed.txt"); !ok || indexes[i].Sha1 != sha1 {
+		t.Fatalf("expected renamed.txt entry with %s, but got %+v", sha1, indexes)
+	}
+
+	if _, err := Mv(MvParam{Sources: []string{"dir"}, Destination: "other"}); err != nil {
+		t.Fatalf("mv: %+v", err)
+	}
+	if _, err := Mv(MvParam{Sources: []string{"renamed.txt", "b.txt"}, Destination: "other/dir"}); err != nil {
+		t.Fatalf("mv: %+v", err)
+	}
+	want := []string{"other/.keep", "other/dir/b.txt", "other/dir/c.txt", "other/dir/d.txt", "other/dir/renamed.txt"}
+	if got := mustLsFiles(t, LsFilesParam{}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+	assertFiles(t, map[string]string{"other/dir/c.txt": "c", "other/dir/b.txt": "b"})
+	// the moved entries keep valid stat information
+	if got := mustLsFiles(t, LsFilesParam{Modified: true}); len(got) != 0 {
+		t.Fatalf("expected no modified files, but got %q", got)
+	}
+
+	for _, c := range []struct {
+		param MvParam
+		want  string
+	}{
+		{MvParam{Sources: []string{"untracked"}, Destination: "x"}, "not under version control"},
+		{MvParam{Sources: []string{"missing"}, Destination: "x"}, "bad source"},
+		{MvParam{Sources: []string{"other/dir/b.txt"}, Destination: "other/dir/c.txt"}, "destination exists"},
+		{MvParam{Sources: []string{"other"}, Destination: "other/dir/sub"}, "into itself"},
+		{MvParam{Sources: []string{"other/.keep"}, Destination: "no/such/dir"}, "destination directory does not exist"},
+	} {
+		if _, err := Mv(c.param); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("expected error %q for %+v, but got %v", c.want, c.param, err)
+		}
+	}
+
+	if _, err := Mv(MvParam{Sources: []string{"other/dir/b.txt"}, Destination: "other/dir/c.txt", Force: true}); err != nil {
+		t.Fatalf("mv: %+v", err)
+	}
+	assertFiles(t, map[string]string{"other/dir/c.txt": "b"})
+	if got := mustLsFiles(t, LsFilesParam{Paths: []string{"other/dir/b.txt"}}); len(got) != 0 {
+		t.Fatalf("expected b.txt entry moved, but got %q", got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -58,6 +59,9 @@ const (
 	Tree   ObjType = "tree"
 )
 
+// emptyBlobSha1 is the sha1 of the blob without content.
+const emptyBlobSha1 = "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+
 // HashParam hash object params.
 type HashParam struct {
 	Data      []byte
@@ -81,34 +85,90 @@ func HashObject(param HashParam) (string, string, error) {
 				return sha1, objFile, fmt.Errorf("create path: %w", err)
 			}
 		}
+		objFile = genObjectFile(path, sha1[2:])
+		// objects are immutable, an existing file already holds the same data
+		if _, err := os.Stat(objFile); err == nil {
+			return sha1, objFile, nil
+		}
 		compressed, err := zlibCompress(fullData)
 		if err != nil {
-			return sha1, objFile, fmt.Errorf("zlib compress: %w", err)
+			return sha1, "", fmt.Errorf("zlib compress: %w", err)
 		}
-		objFile = genObjectFile(path, sha1[2:])
-		if err := os.WriteFile(objFile, compressed, os.ModePerm); err != nil {
-			return sha1, objFile, fmt.Errorf("write file: %w", err)
+		if err := writeObjectFile(objFile, compressed); err != nil {
+			return sha1, "", fmt.Errorf("write file: %w", err)
 		}
 	}
 	return sha1, objFile, nil
 }
 
+// writeObjectFile write the object file aside then rename it, so concurrent
+// readers and crashes never see a partial object under its name.
+func writeObjectFile(file string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), "tmp_obj_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		// another writer stored the same object first
+		if _, statErr := os.Stat(file); statErr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
 // FindObject find object with given SHA-1 prefix and return path to object in object
 // store, or raise ValueError if there are no objects or multiple objects
 // with this prefix.
 func FindObject(sha1Prefix string) (string, error) {
+	sha1, err := expandSha1(sha1Prefix)
+	if err != nil {
+		return "", err
+	}
+	return genObjectFile(genObjectPath(sha1[:2]), sha1[2:]), nil
+}
+
+// expandSha1 return the full sha1 of the only object with given SHA-1 prefix.
+func expandSha1(sha1Prefix string) (string, error) {
 	if len(sha1Prefix) < 2 {
 		return "", errors.New("hash prefix must be 2 or more characters")
 	}
-
 	objPath := genObjectPath(sha1Prefix[:2])
-	objFile := genObjectFile(objPath, sha1Prefix[2:])
-	_, err := os.Stat(objFile)
+	if len(sha1Prefix) == 40 {
+		if _, err := os.Stat(genObjectFile(objPath, sha1Prefix[2:])); err != nil {
+			return "", fmt.Errorf("find object: %w", err)
+		}
+		return sha1Prefix, nil
+	}
+	entries, err := os.ReadDir(objPath)
 	if err != nil {
 		return "", fmt.Errorf("find object: %w", err)
 	}
-
-	return objFile, nil
+	var found []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), sha1Prefix[2:]) {
+			found = append(found, sha1Prefix[:2]+entry.Name())
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("find object: no object with prefix %s: %w", sha1Prefix, fs.ErrNotExist)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("find object: multiple objects (%d) with prefix %s", len(found), sha1Prefix)
 }
 
 // ReadObject read object with given SHA-1 prefix.
@@ -157,5 +217,5 @@ func genObjectFile(path, filename string) string {
 }
 
 func genObjectPath(filename string) string {
-	return filepath.Join(RepoRootPath, ObjectsFolder, filename)
+	return filepath.Join(commonDir(), ObjectsFolder, filename)
 }
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -132,3 +133,32 @@ func TestObjectLifecycle(t *testing.T) {
 		})
 	}
 }
+
+func TestHashObjectConcurrent(t *testing.T) {
+	setupRepo(t)
+	data := []byte(strings.Repeat("concurrent ", 1000))
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for n := range errs {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			_, _, errs[n] = HashObject(HashParam{Data: data, ObjType: Blob, WriteFile: true})
+		}(n)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("hash object: %+v", err)
+		}
+	}
+	sha1, objFile, _ := HashObject(HashParam{Data: data, ObjType: Blob, WriteFile: true})
+	if obj, err := ReadObject(sha1); err != nil || string(obj.Data) != string(data) {
+		t.Fatalf("expected the object back, but got %v", err)
+	}
+	// only the object is left in its folder, no temporary file
+	entries, err := os.ReadDir(filepath.Join(RepoRootPath, ObjectsFolder, sha1[:2]))
+	if err != nil || len(entries) != 1 || entries[0].Name() != sha1[2:] {
+		t.Fatalf("expected only %s, but got %v, %v", objFile, entries, err)
+	}
+}
@@ -0,0 +1,87 @@
+package tinygit
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// parallelEach call fn for every i in [0, n) using at most jobs goroutines.
+// When jobs is not positive the number of CPUs is used.
+func parallelEach(n, jobs int, fn func(i int)) {
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	if jobs > n {
+		jobs = n
+	}
+	ch := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+}
+
+// PathErrors collects the errors of several paths processed together.
+type PathErrors []error
+
+func (e PathErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the wrapped errors.
+func (e PathErrors) Unwrap() []error {
+	return e
+}
+
+// Is report whether one of the errors matches the target. errors.Is only
+// follows Unwrap() []error from Go 1.20 on.
+func (e PathErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As find the first of the errors matching the target like errors.As.
+func (e PathErrors) As(target any) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectErrors return the non-nil errors in order as PathErrors, or nil when
+// every error is nil.
+func collectErrors(errs []error) error {
+	var collected PathErrors
+	for _, err := range errs {
+		if err != nil {
+			collected = append(collected, err)
+		}
+	}
+	if len(collected) == 0 {
+		return nil
+	}
+	return collected
+}
@@ -0,0 +1,26 @@
+package tinygit
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestPathErrors(t *testing.T) {
+	_, statErr := os.Stat("missing.txt")
+	err := collectErrors([]error{nil, errors.New("a.txt: invalid"), statErr})
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected %v to match fs.ErrNotExist", err)
+	}
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "missing.txt" {
+		t.Fatalf("expected %v to hold a path error", err)
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("expected %v not to match fs.ErrPermission", err)
+	}
+	if collectErrors([]error{nil, nil}) != nil {
+		t.Fatal("expected no error")
+	}
+}
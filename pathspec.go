@@ -0,0 +1,53 @@
+package tinygit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// repoRelPath convert the given path into a slash separated path relative
+// to the repository root (the current working directory).
+func repoRelPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return "", err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("'%s' is outside repository", path)
+	}
+	return rel, nil
+}
+
+// matchPathspec report whether the repo relative path is the pathspec itself
+// or lies below it. The pathspec "." matches everything.
+func matchPathspec(spec, path string) bool {
+	if spec == "." || spec == "" {
+		return true
+	}
+	return path == spec || strings.HasPrefix(path, spec+"/")
+}
+
+// matchAnyPathspec report whether the repo relative path matches one of the
+// pathspecs, no pathspec matches every path.
+func matchAnyPathspec(specs []string, path string) bool {
+	if len(specs) == 0 {
+		return true
+	}
+	for _, spec := range specs {
+		if matchPathspec(spec, path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package tinygit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/startdusk/tinygit/shared/refs"
+)
+
+// reflogEntry is a line of a reflog, recording an update of the ref.
+type reflogEntry struct {
+	Old       string
+	New       string
+	Committer string
+	Message   string
+}
+
+// reflogFile return the reflog file of the ref, next to the ref itself.
+func reflogFile(ref string) string {
+	return filepath.Join(refStore().Dir(ref), "logs", filepath.FromSlash(ref))
+}
+
+func (e reflogEntry) String() string {
+	return fmt.Sprintf("%s %s %s\t%s\n", e.Old, e.New, e.Committer, e.Message)
+}
+
+// readReflog read the entries of the reflog of the ref, oldest first. A ref
+// without reflog has no entries.
+func readReflog(ref string) ([]reflogEntry, error) {
+	data, err := os.ReadFile(reflogFile(ref))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read reflog %s: %w", ref, err)
+	}
+	var entries []reflogEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		head, message, _ := strings.Cut(line, "\t")
+		fields := strings.SplitN(head, " ", 3)
+		if len(fields) != 3 || !refs.IsSha1(fields[0]) || !refs.IsSha1(fields[1]) {
+			return nil, fmt.Errorf("invalid reflog %s entry '%s'", ref, line)
+		}
+		entries = append(entries, reflogEntry{Old: fields[0], New: fields[1], Committer: fields[2], Message: message})
+	}
+	return entries, scanner.Err()
+}
+
+// writeReflog replace the reflog of the ref, an empty reflog is removed.
+func writeReflog(ref string, entries []reflogEntry) error {
+	file := reflogFile(ref)
+	if len(entries) == 0 {
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		buf.WriteString(entry.String())
+	}
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(file, buf.Bytes(), 0644)
+}
+
+// appendReflog record an update of the ref in its reflog, an empty old sha1
+// is written as zeros.
+func appendReflog(ref string, entry reflogEntry) error {
+	if entry.Old == "" {
+		entry.Old = refs.ZeroSha1
+	}
+	file := reflogFile(ref)
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(entry.String()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,117 @@
+package tinygit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/startdusk/tinygit/shared/refs"
+)
+
+// refStore return the refs of the repository of the working tree.
+func refStore() refs.Store {
+	return refs.Store{GitDir: gitDir(), CommonDir: commonDir()}
+}
+
+// readHead return the branch HEAD points to, empty when HEAD is detached,
+// and the commit sha1 of HEAD, empty when the branch has no commits yet.
+func readHead() (string, string, error) {
+	return readHeadOf(refStore())
+}
+
+// readHeadOf read the HEAD of another working tree or repository like
+// readHead.
+func readHeadOf(store refs.Store) (string, string, error) {
+	ref, sha1, err := store.Resolve("HEAD")
+	if err != nil {
+		return "", "", err
+	}
+	if ref == "HEAD" {
+		return "", sha1, nil
+	}
+	return strings.TrimPrefix(ref, "refs/heads/"), sha1, nil
+}
+
+// readRef return the sha1 the ref points to, following symbolic refs, empty
+// when it does not exist.
+func readRef(ref string) (string, error) {
+	_, sha1, err := refStore().Resolve(ref)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	return sha1, err
+}
+
+// headTree return the files of the tree of the HEAD commit, empty when there
+// are no commits yet.
+func headTree() (Indexes, error) {
+	_, sha1, err := readHead()
+	if err != nil || sha1 == "" {
+		return nil, err
+	}
+	commit, err := readCommit(sha1)
+	if err != nil {
+		return nil, err
+	}
+	return flattenTree(commit.Tree)
+}
+
+// updateRef point the ref to the sha1, or delete it when sha1 is empty, if it
+// still points to the old sha1. An empty old sha1 requires the ref not to
+// exist, like a branch created from a new commit.
+func updateRef(ref, sha1, old string) error {
+	if old == "" {
+		old = refs.ZeroSha1
+	}
+	return refStore().Update(refs.UpdateParam{Name: ref, New: sha1, Old: old})
+}
+
+// writeHead point HEAD to the branch, or detach it at the commit sha1 when
+// branch is empty.
+func writeHead(branch, sha1 string) error {
+	if branch != "" {
+		return refStore().WriteSymbolic("HEAD", "refs/heads/"+branch)
+	}
+	return refStore().Update(refs.UpdateParam{Name: "HEAD", New: sha1, NoDeref: true})
+}
+
+// resolveRevision return the sha1 of the object named by the revision: HEAD,
+// a full ref, a tag, a branch or an abbreviated object name, in that order.
+func resolveRevision(rev string) (string, error) {
+	if rev == "HEAD" {
+		_, sha1, err := readHead()
+		if err == nil && sha1 == "" {
+			err = errors.New("HEAD does not point to a commit yet")
+		}
+		return sha1, err
+	}
+	if !strings.Contains(rev, "..") {
+		for _, ref := range []string{rev, "refs/" + rev, "refs/tags/" + rev, "refs/heads/" + rev} {
+			if !strings.HasPrefix(ref, "refs/") {
+				continue
+			}
+			sha1, err := readRef(ref)
+			if err != nil {
+				return "", err
+			}
+			if sha1 != "" {
+				return sha1, nil
+			}
+		}
+	}
+	if len(rev) >= 4 && len(rev) <= 40 && strings.Trim(rev, "0123456789abcdef") == "" {
+		if sha1, err := expandSha1(rev); err == nil {
+			return sha1, nil
+		}
+	}
+	return "", fmt.Errorf("unknown revision '%s'", rev)
+}
+
+// checkBranchName report an error when the name can not be used for a branch.
+func checkBranchName(name string) error {
+	if name == "HEAD" || strings.HasPrefix(name, "-") || refs.CheckFormat("refs/heads/"+name, 0) != nil {
+		return fmt.Errorf("'%s' is not a valid branch name", name)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package tinygit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/startdusk/tinygit/shared/refs"
+)
+
+// ResetParam reset command params.
+type ResetParam struct {
+	// Commit is the commit to reset to, HEAD when empty.
+	Commit string
+	// Mode is "soft" to only move the branch, "mixed", the default, to also
+	// reset the index, or "hard" to also reset the working tree.
+	Mode string
+	// Paths resets only the index entries of the paths, HEAD does not move.
+	Paths []string
+}
+
+// Reset reset the current branch, the index and the working tree to the
+// commit according to the mode, or only the index entries of the paths, and
+// return a message describing the result.
+func Reset(param ResetParam) (string, error) {
+	switch param.Mode {
+	case "":
+		param.Mode = "mixed"
+	case "soft", "mixed", "hard":
+	default:
+		return "", fmt.Errorf("invalid reset mode '%s'", param.Mode)
+	}
+	if len(param.Paths) > 0 && param.Mode != "mixed" {
+		return "", fmt.Errorf("cannot do %s reset with paths", param.Mode)
+	}
+	branch, head, err := readHead()
+	if err != nil {
+		return "", err
+	}
+	lock, err := lockIndex()
+	if err != nil {
+		return "", err
+	}
+	defer lock.abort()
+	filter, err := newConvertFilter()
+	if err != nil {
+		return "", err
+	}
+
+	// resetting to HEAD on an unborn branch only empties the index
+	sha1 := head
+	var target Indexes
+	var commit commitObject
+	if param.Commit != "" && param.Commit != "HEAD" || head != "" {
+		if param.Commit != "" {
+			if sha1, err = resolveRevision(param.Commit); err != nil {
+				return "", err
+			}
+		}
+		if commit, err = readCommit(sha1); err != nil {
+			return "", err
+		}
+		if target, err = flattenTree(commit.Tree); err != nil {
+			return "", err
+		}
+	}
+
+	if len(param.Paths) > 0 {
+		specs := make([]string, len(param.Paths))
+		for i, p := range param.Paths {
+			if specs[i], err = repoRelPath(p); err != nil {
+				return "", err
+			}
+		}
+		if err := resetIndex(lock, filter, target, specs); err != nil {
+			return "", err
+		}
+		return unstagedChanges(filter)
+	}
+
+	switch param.Mode {
+	case "soft":
+		indexes, err := ReadIndex()
+		if err != nil {
+			return "", err
+		}
+		if len(indexes.Unmerged()) > 0 {
+			return "", errors.New("cannot do a soft reset in the middle of a merge")
+		}
+	case "mixed":
+		if err := resetIndex(lock, filter, target, []string{"."}); err != nil {
+			return "", err
+		}
+	case "hard":
+		// the index is what the working tree is compared with
+		if err := checkoutTree(lock, filter, nil, target, true); err != nil {
+			return "", err
+		}
+	}
+	if sha1 != "" {
+		if err := moveHead(branch, head, sha1); err != nil {
+			return "", err
+		}
+	}
+
+	if param.Mode == "hard" {
+		if sha1 == "" {
+			return "", nil
+		}
+		return fmt.Sprintf("HEAD is now at %s %s", sha1[:7], commit.subject()), nil
+	}
+	if param.Mode == "mixed" {
+		return unstagedChanges(filter)
+	}
+	return "", nil
+}
+
+// moveHead point the branch, or the detached HEAD, to the sha1 and record the
+// previous commit in ORIG_HEAD.
+func moveHead(branch, previous, sha1 string) error {
+	if previous != "" {
+		if err := refStore().Update(refs.UpdateParam{Name: "ORIG_HEAD", New: previous, NoDeref: true}); err != nil {
+			return err
+		}
+	}
+	if branch == "" {
+		return writeHead("", sha1)
+	}
+	return updateRef("refs/heads/"+branch, sha1, previous)
+}
+
+// resetIndex replace the index entries matching the pathspecs by the target
+// files. Entries whose content does not change keep their stat information,
+// the others take it from the working tree file when it matches. The entries
+// are written through the lock of the index held by the caller.
+func resetIndex(lock *indexLock, filter *convertFilter, target Indexes, specs []string) error {
+	indexes, err := ReadIndex()
+	if err != nil {
+		return err
+	}
+	cone, sparse, err := readSparseCone()
+	if err != nil {
+		return err
+	}
+	var result Indexes
+	for _, index := range indexes {
+		if !matchAnyPathspec(specs, index.Path) {
+			result = append(result, index)
+		}
+	}
+	for _, index := range target {
+		if !matchAnyPathspec(specs, index.Path) {
+			continue
+		}
+		if i, ok := indexes.Find(index.Path); ok {
+			if current := indexes[i]; current.Stage() == StageMerged && sameEntry(&current, &index) {
+				result = append(result, current)
+				continue
+			}
+		}
+		if sparse && !cone.includes(index.Path) {
+			index.SetSkipWorktree(true)
+		} else if index, err = refreshEntry(filter, index); err != nil {
+			return err
+		}
+		result = append(result, index)
+	}
+	return writeIndex(lock, result.Sort())
+}
+
+// unstagedChanges list the index entries which differ from the working tree
+// as git reset reports them.
+func unstagedChanges(filter *convertFilter) (string, error) {
+	indexes, err := ReadIndex()
+	if err != nil {
+		return "", err
+	}
+	indexTime := indexModTime()
+	var lines []string
+	for _, index := range indexes {
+		if index.Stage() != StageMerged {
+			continue
+		}
+		change, err := index.worktreeChange(filter, indexTime)
+		if err != nil {
+			return "", err
+		}
+		switch change {
+		case worktreeModified:
+			lines = append(lines, "M\t"+index.Path)
+		case worktreeDeleted:
+			lines = append(lines, "D\t"+index.Path)
+		}
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return "Unstaged changes after reset:\n" + strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,121 @@
+package tinygit
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReset(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	first := commitIndex(t, "first")
+	writeFiles(t, map[string]string{"a.txt": "a2", "c.txt": "c"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	second := commitIndex(t, "second")
+
+	shortStatus := func() []string {
+		t.Helper()
+		report, err := Status(StatusParam{})
+		if err != nil {
+			t.Fatalf("status: %+v", err)
+		}
+		return report.Short(false)
+	}
+
+	// soft only moves the branch
+	if _, err := Reset(ResetParam{Commit: first, Mode: "soft"}); err != nil {
+		t.Fatalf("reset: %+v", err)
+	}
+	if _, head, _ := readHead(); head != first {
+		t.Fatalf("expected HEAD at %s, but got %s", first, head)
+	}
+	if data, _ := os.ReadFile(refStore().File("ORIG_HEAD")); string(data) != second+"\n" {
+		t.Fatalf("expected ORIG_HEAD %s, but got %q", second, data)
+	}
+	want := []string{"M  a.txt", "A  c.txt"}
+	if got := shortStatus(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+
+	// mixed also resets the index
+	message, err := Reset(ResetParam{})
+	if err != nil {
+		t.Fatalf("reset: %+v", err)
+	}
+	if message != "Unstaged changes after reset:\nM\ta.txt" {
+		t.Fatalf("unexpected message %q", message)
+	}
+	want = []string{" M a.txt", "?? c.txt"}
+	if got := shortStatus(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+	// entries matching the working tree are refreshed
+	indexes, _ := ReadIndex()
+	if i, _ := indexes.Find("b.txt"); indexes[i].Size != 1 || indexes[i].INO == 0 {
+		t.Fatalf("expected b.txt to have stat information, but got %+v", indexes[i])
+	}
+
+	// the path form resets single entries without moving HEAD
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if _, err := Reset(ResetParam{Paths: []string{"c.txt"}}); err != nil {
+		t.Fatalf("reset: %+v", err)
+	}
+	want = []string{"M  a.txt", "?? c.txt"}
+	if got := shortStatus(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+	if _, err := Reset(ResetParam{Commit: second, Paths: []string{"a.txt"}}); err != nil {
+		t.Fatalf("reset: %+v", err)
+	}
+	if _, head, _ := readHead(); head != first {
+		t.Fatalf("expected HEAD to stay at %s, but got %s", first, head)
+	}
+
+	// hard also resets the working tree
+	writeFiles(t, map[string]string{"b.txt": "local"})
+	if err := Add(AddParam{Paths: []string{"c.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	message, err = Reset(ResetParam{Mode: "hard"})
+	if err != nil {
+		t.Fatalf("reset: %+v", err)
+	}
+	if message != "HEAD is now at "+first[:7]+" first" {
+		t.Fatalf("unexpected message %q", message)
+	}
+	assertFiles(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	if _, err := os.Stat("c.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expected c.txt removed, but got %v", err)
+	}
+	if got := shortStatus(); len(got) != 0 {
+		t.Fatalf("expected clean status, but got %q", got)
+	}
+	if _, err := Reset(ResetParam{Commit: "nosuch", Mode: "hard"}); err == nil {
+		t.Fatal("expected error for an unknown commit")
+	}
+	if _, err := Reset(ResetParam{Mode: "hard", Paths: []string{"a.txt"}}); err == nil {
+		t.Fatal("expected error for hard reset with paths")
+	}
+}
+
+func TestResetUnborn(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if _, err := Reset(ResetParam{}); err != nil {
+		t.Fatalf("reset: %+v", err)
+	}
+	if got := mustLsFiles(t, LsFilesParam{}); len(got) != 0 {
+		t.Fatalf("expected empty index, but got %q", got)
+	}
+}
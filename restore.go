@@ -0,0 +1,205 @@
+package tinygit
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// RestoreParam restore command params.
+type RestoreParam struct {
+	Paths []string
+	// Source is the commit to restore from. By default the working tree is
+	// restored from the index and the index from HEAD.
+	Source string
+	// Staged restores the index entries.
+	Staged bool
+	// Worktree restores the working tree files, the default when Staged is
+	// not set.
+	Worktree bool
+	// Ours and Theirs restore the working tree files of unmerged paths from
+	// stage #2 or #3 of the index.
+	Ours   bool
+	Theirs bool
+}
+
+// Restore restore the working tree files and/or the index entries matching
+// the paths from the source, leaving everything else untouched. Tracked
+// paths absent from the source are removed.
+func Restore(param RestoreParam) error {
+	if len(param.Paths) == 0 {
+		return errors.New("you must specify path(s) to restore")
+	}
+	if !param.Staged && !param.Worktree {
+		param.Worktree = true
+	}
+	if param.Ours && param.Theirs {
+		return errors.New("--ours and --theirs are incompatible")
+	}
+	fromIndex := param.Source == "" && !param.Staged
+	if (param.Ours || param.Theirs) && !fromIndex {
+		return errors.New("--ours and --theirs only restore the working tree from the index")
+	}
+	specs := make([]string, len(param.Paths))
+	for i, p := range param.Paths {
+		rel, err := repoRelPath(p)
+		if err != nil {
+			return err
+		}
+		specs[i] = rel
+	}
+
+	lock, err := lockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.abort()
+	indexes, err := ReadIndex()
+	if err != nil {
+		return err
+	}
+	var source Indexes
+	if !fromIndex {
+		rev := param.Source
+		if rev == "" {
+			rev = "HEAD"
+		}
+		_, head, err := readHead()
+		if err != nil {
+			return err
+		}
+		// restoring the index from an unborn HEAD removes the entries
+		if rev != "HEAD" || head != "" {
+			sha1, err := resolveRevision(rev)
+			if err != nil {
+				return err
+			}
+			commit, err := readCommit(sha1)
+			if err != nil {
+				return err
+			}
+			if source, err = flattenTree(commit.Tree); err != nil {
+				return err
+			}
+		}
+	}
+	for _, spec := range specs {
+		matched := false
+		for _, list := range []Indexes{indexes, source} {
+			for _, index := range list {
+				if matchPathspec(spec, index.Path) {
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			return fmt.Errorf("pathspec '%s' did not match any file(s) known to tinygit", spec)
+		}
+	}
+
+	// the stage 0 entries, or the paths of unmerged entries, to restore
+	var paths []string
+	seen := make(map[string]bool)
+	for _, list := range []Indexes{indexes, source} {
+		for _, index := range list {
+			if matchAnyPathspec(specs, index.Path) && !seen[index.Path] {
+				seen[index.Path] = true
+				paths = append(paths, index.Path)
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	stage := StageMerged
+	if param.Ours {
+		stage = StageOurs
+	} else if param.Theirs {
+		stage = StageTheirs
+	}
+	filter, err := newConvertFilter()
+	if err != nil {
+		return err
+	}
+	indexTime := indexModTime()
+	// Remove works in place, the entries read are kept intact for lookups
+	result := append(Indexes(nil), indexes...)
+	for _, p := range paths {
+		stages := indexes.Stages(p)
+		current := stages[StageMerged]
+		if fromIndex {
+			if current == nil {
+				if stage == StageMerged {
+					return fmt.Errorf("path '%s' is unmerged", p)
+				}
+				if stages[stage] == nil {
+					version := "our"
+					if stage == StageTheirs {
+						version = "their"
+					}
+					return fmt.Errorf("path '%s' does not have %s version", p, version)
+				}
+				// the conflict stays in the index
+				if _, err := checkoutIndex(filter, *stages[stage]); err != nil {
+					return err
+				}
+				continue
+			}
+			if current.SkipWorktree() || current.IntentToAdd() {
+				continue
+			}
+			change, err := current.worktreeChange(filter, indexTime)
+			if err != nil {
+				return err
+			}
+			if change == worktreeUnchanged {
+				continue
+			}
+			restored, err := checkoutIndex(filter, *current)
+			if err != nil {
+				return err
+			}
+			result = result.Set(restored)
+			continue
+		}
+
+		var src *Index
+		if i, ok := source.Find(p); ok {
+			src = &source[i]
+		}
+		if src == nil {
+			if param.Worktree && current != nil {
+				if err := removeWorktreeFile(p); err != nil {
+					return err
+				}
+			}
+			if param.Staged {
+				result = result.Remove(p)
+			}
+			continue
+		}
+		// the index keeps an entry with the same content to refresh its stat data
+		same := current != nil && sameEntry(current, src)
+		entry := *src
+		if same {
+			entry = *current
+		}
+		if param.Worktree && !entry.SkipWorktree() {
+			restored, err := checkoutIndex(filter, entry)
+			if err != nil {
+				return err
+			}
+			if param.Staged || same {
+				result = result.Set(restored)
+			}
+			continue
+		}
+		if param.Staged && !same {
+			if entry, err = refreshEntry(filter, entry); err != nil {
+				return err
+			}
+			result = result.Set(entry)
+		}
+	}
+	return writeIndex(lock, result.Sort())
+}
@@ -0,0 +1,109 @@
+package tinygit
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRestore(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a", "b.txt": "b", "dir/c.txt": "c"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	first := commitIndex(t, "first")
+	writeFiles(t, map[string]string{"a.txt": "a2", "new.txt": "new"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	commitIndex(t, "second")
+
+	shortStatus := func() []string {
+		t.Helper()
+		report, err := Status(StatusParam{})
+		if err != nil {
+			t.Fatalf("status: %+v", err)
+		}
+		return report.Short(false)
+	}
+
+	// the working tree is restored from the index by default
+	writeFiles(t, map[string]string{"a.txt": "local", "b.txt": "local"})
+	if err := os.Remove("dir/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(RestoreParam{Paths: []string{"a.txt", "dir"}}); err != nil {
+		t.Fatalf("restore: %+v", err)
+	}
+	assertFiles(t, map[string]string{"a.txt": "a2", "b.txt": "local", "dir/c.txt": "c"})
+	want := []string{" M b.txt"}
+	if got := shortStatus(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+
+	// --staged restores the index from HEAD and keeps the working tree
+	if err := Add(AddParam{Paths: []string{"b.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if err := Restore(RestoreParam{Paths: []string{"b.txt"}, Staged: true}); err != nil {
+		t.Fatalf("restore: %+v", err)
+	}
+	if got := shortStatus(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+	assertFiles(t, map[string]string{"b.txt": "local"})
+
+	// --source restores from an older commit, removing paths it lacks
+	if err := Restore(RestoreParam{Paths: []string{"a.txt", "new.txt"}, Source: first[:10]}); err != nil {
+		t.Fatalf("restore: %+v", err)
+	}
+	assertFiles(t, map[string]string{"a.txt": "a"})
+	if _, err := os.Stat("new.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expected new.txt removed, but got %v", err)
+	}
+	want = []string{" M a.txt", " M b.txt", " D new.txt"}
+	if got := shortStatus(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+	if err := Restore(RestoreParam{Paths: []string{"."}, Source: "master", Staged: true, Worktree: true}); err != nil {
+		t.Fatalf("restore: %+v", err)
+	}
+	if got := shortStatus(); len(got) != 0 {
+		t.Fatalf("expected clean status, but got %q", got)
+	}
+	if err := Restore(RestoreParam{Paths: []string{"a.txt"}, Source: first, Staged: true, Worktree: true}); err != nil {
+		t.Fatalf("restore: %+v", err)
+	}
+	want = []string{"M  a.txt"}
+	if got := shortStatus(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+
+	err := Restore(RestoreParam{Paths: []string{"missing.txt"}})
+	if err == nil || !strings.Contains(err.Error(), "did not match") {
+		t.Fatalf("expected pathspec error, but got %v", err)
+	}
+}
+
+func TestRestoreUnmerged(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "conflict"})
+	ours, _, _ := HashObject(HashParam{Data: []byte("ours"), ObjType: Blob, WriteFile: true})
+	theirs, _, _ := HashObject(HashParam{Data: []byte("theirs"), ObjType: Blob, WriteFile: true})
+	input := "100644 " + ours + " 2\ta.txt\n" + "100644 " + theirs + " 3\ta.txt\n"
+	if err := UpdateIndex(UpdateIndexParam{IndexInfo: strings.NewReader(input)}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	if err := Restore(RestoreParam{Paths: []string{"a.txt"}}); err == nil {
+		t.Fatal("expected error for an unmerged path")
+	}
+	if err := Restore(RestoreParam{Paths: []string{"a.txt"}, Theirs: true}); err != nil {
+		t.Fatalf("restore: %+v", err)
+	}
+	assertFiles(t, map[string]string{"a.txt": "theirs"})
+	if got := mustLsFiles(t, LsFilesParam{Unmerged: true}); len(got) != 2 {
+		t.Fatalf("expected the conflict to stay, but got %q", got)
+	}
+}
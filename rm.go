@@ -0,0 +1,150 @@
+package tinygit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RmParam rm command params.
+type RmParam struct {
+	Paths []string
+	// Cached only removes the index entries, the files stay in the working tree.
+	Cached bool
+	// Recursive allows removing the entries below a directory.
+	Recursive bool
+	// Force skips the checks protecting local changes.
+	Force bool
+	// IgnoreUnmatch does not fail on paths matching no entry.
+	IgnoreUnmatch bool
+	// DryRun only reports the paths which would be removed.
+	DryRun bool
+}
+
+// Rm remove the index entries matching the paths, and their working tree
+// files unless Cached is set, and return the removed paths. Paths whose
+// local changes would be lost are refused unless forced.
+func Rm(param RmParam) ([]string, error) {
+	if len(param.Paths) == 0 {
+		return nil, errors.New("no pathspec given, which files should I remove?")
+	}
+	lock, err := lockIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.abort()
+	indexes, err := ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	seen := make(map[string]bool)
+	for _, p := range param.Paths {
+		spec, err := repoRelPath(p)
+		if err != nil {
+			return nil, err
+		}
+		matched := false
+		for _, index := range indexes {
+			if !matchPathspec(spec, index.Path) {
+				continue
+			}
+			if index.Path != spec && !param.Recursive {
+				return nil, fmt.Errorf("not removing '%s' recursively without -r", p)
+			}
+			matched = true
+			if !seen[index.Path] {
+				seen[index.Path] = true
+				paths = append(paths, index.Path)
+			}
+		}
+		if !matched && !param.IgnoreUnmatch {
+			return nil, fmt.Errorf("pathspec '%s' did not match any files", p)
+		}
+	}
+
+	if !param.Force {
+		if err := checkRemovable(indexes, paths, param.Cached); err != nil {
+			return nil, err
+		}
+	}
+	if param.DryRun {
+		return paths, nil
+	}
+	for _, p := range paths {
+		indexes = indexes.Remove(p)
+		if !param.Cached {
+			if err := removeWorktreeFile(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return paths, writeIndex(lock, indexes)
+}
+
+// checkRemovable report the paths whose content would be lost by removing
+// them: staged content matching neither HEAD nor the file, and unless only
+// the index entries are removed, staged changes or local modifications.
+func checkRemovable(indexes Indexes, paths []string, cached bool) error {
+	head, err := headTree()
+	if err != nil {
+		return err
+	}
+	filter, err := newConvertFilter()
+	if err != nil {
+		return err
+	}
+	indexTime := indexModTime()
+	var both, staged, local []string
+	for _, p := range paths {
+		i, _ := indexes.Find(p)
+		index := indexes[i]
+		// removing a conflict loses nothing which is not in its stages
+		if index.Stage() != StageMerged {
+			continue
+		}
+		var h *Index
+		if j, ok := head.Find(p); ok {
+			h = &head[j]
+		}
+		change, err := index.worktreeChange(filter, indexTime)
+		if err != nil {
+			return err
+		}
+		matchesHead := sameEntry(h, &index)
+		matchesFile := change != worktreeModified
+		switch {
+		case !matchesHead && !matchesFile:
+			both = append(both, p)
+		case cached:
+		case !matchesHead:
+			staged = append(staged, p)
+		case !matchesFile:
+			local = append(local, p)
+		}
+	}
+	var messages []string
+	report := func(paths []string, singular, plural, hint string) {
+		if len(paths) == 0 {
+			return
+		}
+		what := singular
+		if len(paths) > 1 {
+			what = plural
+		}
+		messages = append(messages, fmt.Sprintf("%s:\n    %s\n(%s)", what, strings.Join(paths, "\n    "), hint))
+	}
+	report(both, "the following file has staged content different from both the\nfile and the HEAD",
+		"the following files have staged content different from both the\nfile and the HEAD",
+		"use -f to force removal")
+	report(staged, "the following file has changes staged in the index",
+		"the following files have changes staged in the index",
+		"use --cached to keep the file, or -f to force removal")
+	report(local, "the following file has local modifications",
+		"the following files have local modifications",
+		"use --cached to keep the file, or -f to force removal")
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "\n"))
+	}
+	return nil
+}
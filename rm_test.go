@@ -0,0 +1,95 @@
+package tinygit
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRm(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{
+		"a.txt":       "a",
+		"b.txt":       "b",
+		"c.txt":       "c",
+		"dir/d.txt":   "d",
+		"dir/e/f.txt": "f",
+	})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	commitIndex(t, "first")
+
+	removed, err := Rm(RmParam{Paths: []string{"a.txt"}})
+	if err != nil {
+		t.Fatalf("rm: %+v", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"a.txt"}) {
+		t.Fatalf("unexpected removed paths %q", removed)
+	}
+	if _, err := os.Stat("a.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expected a.txt removed, but got %v", err)
+	}
+
+	if _, err := Rm(RmParam{Paths: []string{"dir"}}); err == nil || !strings.Contains(err.Error(), "without -r") {
+		t.Fatalf("expected recursive error, but got %v", err)
+	}
+	if _, err := Rm(RmParam{Paths: []string{"missing"}}); err == nil {
+		t.Fatal("expected pathspec error")
+	}
+	if _, err := Rm(RmParam{Paths: []string{"missing"}, IgnoreUnmatch: true}); err != nil {
+		t.Fatalf("rm: %+v", err)
+	}
+
+	// local modifications are protected
+	writeFiles(t, map[string]string{"b.txt": "local"})
+	_, err = Rm(RmParam{Paths: []string{"b.txt"}})
+	if err == nil || !strings.Contains(err.Error(), "local modifications") {
+		t.Fatalf("expected local modifications error, but got %v", err)
+	}
+	// staged changes too, unless only the entry is removed
+	if err := Add(AddParam{Paths: []string{"b.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	_, err = Rm(RmParam{Paths: []string{"b.txt"}})
+	if err == nil || !strings.Contains(err.Error(), "changes staged in the index") {
+		t.Fatalf("expected staged changes error, but got %v", err)
+	}
+	if _, err := Rm(RmParam{Paths: []string{"b.txt"}, Cached: true}); err != nil {
+		t.Fatalf("rm: %+v", err)
+	}
+	assertFiles(t, map[string]string{"b.txt": "local"})
+	// content matching neither HEAD nor the file needs force even when cached
+	writeFiles(t, map[string]string{"c.txt": "staged"})
+	if err := Add(AddParam{Paths: []string{"c.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	writeFiles(t, map[string]string{"c.txt": "local"})
+	_, err = Rm(RmParam{Paths: []string{"c.txt"}, Cached: true})
+	if err == nil || !strings.Contains(err.Error(), "different from both") {
+		t.Fatalf("expected staged content error, but got %v", err)
+	}
+	if _, err := Rm(RmParam{Paths: []string{"c.txt"}, Force: true}); err != nil {
+		t.Fatalf("rm: %+v", err)
+	}
+
+	removed, err = Rm(RmParam{Paths: []string{"dir"}, Recursive: true, DryRun: true})
+	if err != nil {
+		t.Fatalf("rm: %+v", err)
+	}
+	want := []string{"dir/d.txt", "dir/e/f.txt"}
+	if !reflect.DeepEqual(removed, want) {
+		t.Fatalf("expected %q, but got %q", want, removed)
+	}
+	assertFiles(t, map[string]string{"dir/d.txt": "d"})
+	if _, err := Rm(RmParam{Paths: []string{"dir"}, Recursive: true}); err != nil {
+		t.Fatalf("rm: %+v", err)
+	}
+	if _, err := os.Stat("dir"); !os.IsNotExist(err) {
+		t.Fatalf("expected dir removed, but got %v", err)
+	}
+	if got := mustLsFiles(t, LsFilesParam{}); len(got) != 0 {
+		t.Fatalf("expected empty index, but got %q", got)
+	}
+}
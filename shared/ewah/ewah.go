@@ -0,0 +1,141 @@
+// Package ewah reads and writes the EWAH compressed bitmaps of the Git index
+// extensions. A bitmap is serialized as its size in bits, the number of
+// 64-bit words, the words and the position of the last marker word, all big
+// endian. The words are runs of marker words, each counting the clean words,
+// all zeros or all ones, it stands for and the literal words following it.
+package ewah
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	wordBits = 64
+	// runningBits and literalBits are the widths of the clean words count
+	// and of the literal words count of a marker word, after its clean bit.
+	runningBits = 32
+	literalBits = 31
+
+	maxRunning = 1<<runningBits - 1
+	maxLiteral = 1<<literalBits - 1
+)
+
+// ErrInvalid is returned for data which is not a serialized bitmap.
+var ErrInvalid = errors.New("invalid ewah bitmap")
+
+// Bitmap is an uncompressed bitmap of a fixed size.
+type Bitmap struct {
+	words []uint64
+	size  int
+}
+
+// New create a bitmap of size bits, all unset.
+func New(size int) *Bitmap {
+	return &Bitmap{words: make([]uint64, (size+wordBits-1)/wordBits), size: size}
+}
+
+// Len return the size of the bitmap in bits.
+func (b *Bitmap) Len() int {
+	return b.size
+}
+
+// Set set the bit i.
+func (b *Bitmap) Set(i int) {
+	b.words[i/wordBits] |= 1 << (i % wordBits)
+}
+
+// Get report whether the bit i is set.
+func (b *Bitmap) Get(i int) bool {
+	return b.words[i/wordBits]&(1<<(i%wordBits)) != 0
+}
+
+// Encode return the serialized compressed bitmap.
+func (b *Bitmap) Encode() []byte {
+	var out []uint64
+	last := 0
+	for i := 0; ; {
+		last = len(out)
+		out = append(out, 0)
+		var clean uint64
+		running := 0
+		if i < len(b.words) && (b.words[i] == 0 || b.words[i] == ^uint64(0)) {
+			clean = b.words[i] & 1
+			for i < len(b.words) && b.words[i] == -clean && running < maxRunning {
+				running++
+				i++
+			}
+		}
+		literal := 0
+		for i < len(b.words) && b.words[i] != 0 && b.words[i] != ^uint64(0) && literal < maxLiteral {
+			out = append(out, b.words[i])
+			literal++
+			i++
+		}
+		out[last] = clean | uint64(running)<<1 | uint64(literal)<<(1+runningBits)
+		if i == len(b.words) {
+			break
+		}
+	}
+	data := binary.BigEndian.AppendUint32(nil, uint32(b.size))
+	data = binary.BigEndian.AppendUint32(data, uint32(len(out)))
+	for _, word := range out {
+		data = binary.BigEndian.AppendUint64(data, word)
+	}
+	return binary.BigEndian.AppendUint32(data, uint32(last))
+}
+
+// Decode return the bitmap serialized in data, which holds nothing else.
+func Decode(data []byte) (*Bitmap, error) {
+	b, rest, err := Read(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, ErrInvalid
+	}
+	return b, nil
+}
+
+// Read return the bitmap serialized at the start of data and the data
+// following it.
+func Read(data []byte) (*Bitmap, []byte, error) {
+	if len(data) < 8 {
+		return nil, nil, ErrInvalid
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	count := int(binary.BigEndian.Uint32(data[4:]))
+	data = data[8:]
+	if len(data) < count*8+4 {
+		return nil, nil, ErrInvalid
+	}
+	rest := data[count*8+4:]
+	b := New(size)
+	n := 0
+	for pos := 0; pos < count; {
+		marker := binary.BigEndian.Uint64(data[pos*8:])
+		clean := -(marker & 1)
+		running := int(marker >> 1 & maxRunning)
+		literal := int(marker >> (1 + runningBits))
+		if n+running > len(b.words) || pos+1+literal > count {
+			return nil, nil, ErrInvalid
+		}
+		for ; running > 0; running-- {
+			b.words[n] = clean
+			n++
+		}
+		for pos++; literal > 0; literal-- {
+			if n == len(b.words) {
+				return nil, nil, ErrInvalid
+			}
+			b.words[n] = binary.BigEndian.Uint64(data[pos*8:])
+			n++
+			pos++
+		}
+	}
+	// a run of ones may cover the bits past the size
+	if bits := size % wordBits; bits != 0 {
+		b.words[len(b.words)-1] &= 1<<bits - 1
+	}
+	return b, rest, nil
+}
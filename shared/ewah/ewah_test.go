@@ -0,0 +1,80 @@
+package ewah
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	// written by git update-index for the bits 23, 62 and 69 of 70
+	b := New(70)
+	for _, i := range []int{23, 62, 69} {
+		b.Set(i)
+	}
+	want := "000000460000000300000004000000004000000000800000000000000000002000000000"
+	if got := hex.EncodeToString(b.Encode()); got != want {
+		t.Fatalf("expected %s, but got %s", want, got)
+	}
+	// an empty bitmap is a single marker word
+	want = "00000000000000010000000000000000" + "00000000"
+	if got := hex.EncodeToString(New(0).Encode()); got != want {
+		t.Fatalf("expected %s, but got %s", want, got)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, size := range []int{0, 1, 63, 64, 65, 300, 1000} {
+		b := New(size)
+		for i := 0; i < size; i++ {
+			// runs of zeros, of ones and literal words
+			if i >= 128 && i < 320 || i >= 500 && i%7 == 0 {
+				b.Set(i)
+			}
+		}
+		decoded, err := Decode(b.Encode())
+		if err != nil {
+			t.Fatalf("%d: decode: %v", size, err)
+		}
+		if decoded.Len() != size {
+			t.Fatalf("%d: expected the size, but got %d", size, decoded.Len())
+		}
+		for i := 0; i < size; i++ {
+			if decoded.Get(i) != b.Get(i) {
+				t.Fatalf("%d: expected bit %d to be %v", size, i, b.Get(i))
+			}
+		}
+		if !bytes.Equal(decoded.Encode(), b.Encode()) {
+			t.Fatalf("%d: expected the same encoding", size)
+		}
+	}
+	for _, data := range []string{
+		"",
+		"0000004600000003",
+		// more literal words than the data holds
+		"00000001000000010000000400000000" + "00000000",
+	} {
+		raw, _ := hex.DecodeString(data)
+		if _, err := Decode(raw); err != ErrInvalid {
+			t.Fatalf("%q: expected ErrInvalid, but got %v", data, err)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	first, second := New(10), New(200)
+	first.Set(3)
+	second.Set(150)
+	data := append(first.Encode(), second.Encode()...)
+	b, rest, err := Read(data)
+	if err != nil || b.Len() != 10 || !b.Get(3) {
+		t.Fatalf("expected the first bitmap, but got %v", err)
+	}
+	b, rest, err = Read(rest)
+	if err != nil || b.Len() != 200 || !b.Get(150) || len(rest) != 0 {
+		t.Fatalf("expected the second bitmap, but got %v", err)
+	}
+	if _, err := Decode(data); err != ErrInvalid {
+		t.Fatalf("expected trailing data to be refused, but got %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package filestat
+
+import "io/fs"
+
+// Modes of the files as Git records them, the permission bits of regular
+// files are reduced to the executable bit.
+const (
+	ModeDir        uint16 = 0040000
+	ModeRegular    uint16 = 0100644
+	ModeExecutable uint16 = 0100755
+	ModeSymlink    uint16 = 0120000
+)
+
+// gitMode return the Git mode of the file mode.
+func gitMode(mode fs.FileMode) uint16 {
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return ModeSymlink
+	case mode.IsDir():
+		return ModeDir
+	case mode&0111 != 0:
+		return ModeExecutable
+	default:
+		return ModeRegular
+	}
+}
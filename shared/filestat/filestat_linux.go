@@ -29,21 +29,23 @@ import (
 
 // FileStat indicates the file status of the specified system.
 type FileStat struct {
-	CreateTime int64
-	ModifyTime int64
-	Dev        int32
-	INO        uint64
-	Mode       uint16
-	UID        uint32
-	GID        uint32
-	Size       int64
-	Flags      uint32
+	CreateTime  int64
+	CreateTimeN int64
+	ModifyTime  int64
+	ModifyTimeN int64
+	Dev         int32
+	INO         uint64
+	Mode        uint16
+	UID         uint32
+	GID         uint32
+	Size        int64
+	Flags       uint32
 }
 
-// Stat query file state information.
+// Stat query file state information, symbolic links are not followed.
 func Stat(path string) (FileStat, error) {
 	filestat := FileStat{}
-	fileinfo, err := os.Stat(path)
+	fileinfo, err := os.Lstat(path)
 	if err != nil {
 		return filestat, err
 	}
@@ -53,11 +55,15 @@ func Stat(path string) (FileStat, error) {
 	}
 	filestat.Dev = int32(stat.Dev)
 	filestat.INO = stat.Ino
-	filestat.Mode = uint16(stat.Mode)
+	filestat.Mode = gitMode(fileinfo.Mode())
 	filestat.UID = stat.Uid
 	filestat.GID = stat.Gid
 	filestat.Size = stat.Size
-	filestat.CreateTime = time.Unix(stat.Ctim.Sec, stat.Ctim.Nsec).Unix()
-	filestat.ModifyTime = time.Unix(stat.Mtim.Sec, stat.Mtim.Nsec).Unix()
+	ctime := time.Unix(stat.Ctim.Sec, stat.Ctim.Nsec)
+	filestat.CreateTime = ctime.Unix()
+	filestat.CreateTimeN = int64(ctime.Nanosecond())
+	mtime := time.Unix(stat.Mtim.Sec, stat.Mtim.Nsec)
+	filestat.ModifyTime = mtime.Unix()
+	filestat.ModifyTimeN = int64(mtime.Nanosecond())
 	return filestat, nil
 }
@@ -3,6 +3,8 @@
 package filestat
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -15,3 +17,37 @@ func TestFileStat(t *testing.T) {
 
 	t.Logf("%+v", st)
 }
+
+func TestFileStatMode(t *testing.T) {
+	dir := t.TempDir()
+	regular := filepath.Join(dir, "regular")
+	executable := filepath.Join(dir, "executable")
+	link := filepath.Join(dir, "link")
+	if err := os.WriteFile(regular, []byte("data"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(executable, []byte("data"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("executable", link); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]uint16{
+		dir:        ModeDir,
+		regular:    ModeRegular,
+		executable: ModeExecutable,
+		link:       ModeSymlink,
+	} {
+		st, err := Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Mode != want {
+			t.Fatalf("%s: expected mode %o, but got %o", path, want, st.Mode)
+		}
+	}
+	// the size of a link is the length of its target
+	if st, _ := Stat(link); st.Size != int64(len("executable")) {
+		t.Fatalf("expected the link size, but got %d", st.Size)
+	}
+}
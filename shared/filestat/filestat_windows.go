@@ -11,21 +11,23 @@ import (
 
 // FileStat indicates the file status of the specified system.
 type FileStat struct {
-	CreateTime int64
-	ModifyTime int64
-	Dev        int32
-	INO        uint64
-	Mode       uint16
-	UID        uint32
-	GID        uint32
-	Size       int64
-	Flags      uint32
+	CreateTime  int64
+	CreateTimeN int64
+	ModifyTime  int64
+	ModifyTimeN int64
+	Dev         int32
+	INO         uint64
+	Mode        uint16
+	UID         uint32
+	GID         uint32
+	Size        int64
+	Flags       uint32
 }
 
-// Stat query file state information.
+// Stat query file state information, symbolic links are not followed.
 func Stat(path string) (FileStat, error) {
 	filestat := FileStat{}
-	fileinfo, err := os.Stat(path)
+	fileinfo, err := os.Lstat(path)
 	if err != nil {
 		return filestat, err
 	}
@@ -34,7 +36,13 @@ func Stat(path string) (FileStat, error) {
 		return filestat, fmt.Errorf("invalid system stat")
 	}
 
-	filestat.CreateTime = time.Unix(0, stat.CreationTime.Nanoseconds()).Unix()
-	filestat.ModifyTime = time.Unix(0, stat.LastWriteTime.Nanoseconds()).Unix()
+	ctime := time.Unix(0, stat.CreationTime.Nanoseconds())
+	filestat.CreateTime = ctime.Unix()
+	filestat.CreateTimeN = int64(ctime.Nanosecond())
+	mtime := time.Unix(0, stat.LastWriteTime.Nanoseconds())
+	filestat.ModifyTime = mtime.Unix()
+	filestat.ModifyTimeN = int64(mtime.Nanosecond())
+	filestat.Mode = gitMode(fileinfo.Mode())
+	filestat.Size = fileinfo.Size()
 	return filestat, nil
 }
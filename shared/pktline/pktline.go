@@ -0,0 +1,143 @@
+// Package pktline reads and writes the pkt-line framing of the Git protocols.
+// A packet is its length, including the 4 bytes of the length itself, as 4
+// hex digits followed by its payload. The length "0000" is a flush packet
+// ending a list of packets.
+package pktline
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MaxPayload is the largest payload of a single packet.
+const MaxPayload = 65516
+
+// ErrTooLong is returned for a payload which does not fit in a packet.
+var ErrTooLong = errors.New("pkt-line payload too long")
+
+// Writer writes packets.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter create a writer of packets to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WritePacket write the payload as a single packet.
+func (w *Writer) WritePacket(payload []byte) error {
+	if len(payload) > MaxPayload {
+		return ErrTooLong
+	}
+	if _, err := fmt.Fprintf(w.w, "%04x", len(payload)+4); err != nil {
+		return err
+	}
+	_, err := w.w.Write(payload)
+	return err
+}
+
+// WriteLine write the text line as a packet terminated by a line feed.
+func (w *Writer) WriteLine(line string) error {
+	return w.WritePacket([]byte(line + "\n"))
+}
+
+// WriteLines write the text lines followed by a flush packet.
+func (w *Writer) WriteLines(lines ...string) error {
+	for _, line := range lines {
+		if err := w.WriteLine(line); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// WriteData write the data split into packets followed by a flush packet.
+func (w *Writer) WriteData(data []byte) error {
+	for len(data) > 0 {
+		n := len(data)
+		if n > MaxPayload {
+			n = MaxPayload
+		}
+		if err := w.WritePacket(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return w.Flush()
+}
+
+// Flush write a flush packet.
+func (w *Writer) Flush() error {
+	_, err := io.WriteString(w.w, "0000")
+	return err
+}
+
+// Reader reads packets.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader create a reader of packets from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// ReadPacket read the next packet, a flush packet has a nil payload and
+// flush set.
+func (r *Reader) ReadPacket() (payload []byte, flush bool, err error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r.r, head[:]); err != nil {
+		return nil, false, err
+	}
+	length, err := strconv.ParseUint(string(head[:]), 16, 16)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid pkt-line length %q", head)
+	}
+	switch {
+	case length == 0:
+		return nil, true, nil
+	case length < 4:
+		return nil, false, fmt.Errorf("invalid pkt-line length %q", head)
+	}
+	payload = make([]byte, length-4)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		return nil, false, err
+	}
+	return payload, false, nil
+}
+
+// ReadLines read the text lines up to a flush packet, without their line
+// feed.
+func (r *Reader) ReadLines() ([]string, error) {
+	var lines []string
+	for {
+		payload, flush, err := r.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+		if flush {
+			return lines, nil
+		}
+		lines = append(lines, strings.TrimSuffix(string(payload), "\n"))
+	}
+}
+
+// ReadData read the payloads up to a flush packet as a whole.
+func (r *Reader) ReadData() ([]byte, error) {
+	var data []byte
+	for {
+		payload, flush, err := r.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+		if flush {
+			return data, nil
+		}
+		data = append(data, payload...)
+	}
+}
@@ -0,0 +1,49 @@
+package pktline
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPktLine(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.WriteLines("git-filter-client", "version=2"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "0016git-filter-client\n000eversion=2\n0000"; got != want {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+
+	data := []byte(strings.Repeat("x", MaxPayload+10))
+	if err := w.WriteData(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteData(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(make([]byte, MaxPayload+1)); err != ErrTooLong {
+		t.Fatalf("expected ErrTooLong, but got %v", err)
+	}
+
+	r := NewReader(&buf)
+	lines, err := r.ReadLines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"git-filter-client", "version=2"}; !reflect.DeepEqual(lines, want) {
+		t.Fatalf("expected %q, but got %q", want, lines)
+	}
+	got, err := r.ReadData()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("expected %d bytes back, but got %d, %v", len(data), len(got), err)
+	}
+	if got, err := r.ReadData(); err != nil || len(got) != 0 {
+		t.Fatalf("expected empty data, but got %q, %v", got, err)
+	}
+	if _, _, err := NewReader(strings.NewReader("0002")).ReadPacket(); err == nil {
+		t.Fatal("expected an invalid length error")
+	}
+}
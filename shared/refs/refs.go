@@ -0,0 +1,378 @@
+// Package refs reads and writes the refs of a Git repository. A ref is a
+// name like refs/heads/master or HEAD stored as a loose file holding either
+// the sha1 of an object or "ref: " followed by the name of another ref, a
+// symbolic ref. Updates go through a <ref>.lock file renamed over the ref so
+// concurrent writers fail instead of losing an update.
+package refs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// SymbolicPrefix starts the content of a symbolic ref.
+const SymbolicPrefix = "ref: "
+
+// ZeroSha1 is the old value of an update creating the ref.
+const ZeroSha1 = "0000000000000000000000000000000000000000"
+
+// MaxDepth is the most symbolic refs followed to resolve a ref, like Git.
+const MaxDepth = 5
+
+const lockSuffix = ".lock"
+
+// Ref is the content of a loose ref, either a sha1 or the target of a
+// symbolic ref.
+type Ref struct {
+	Name   string
+	Sha1   string
+	Target string
+}
+
+// Symbolic report whether the ref points to another ref.
+func (r Ref) Symbolic() bool {
+	return r.Target != ""
+}
+
+// Store is the refs of a repository. With linked working trees GitDir is the
+// repository folder of the working tree and CommonDir the one shared by all
+// of them, otherwise both are the same folder.
+type Store struct {
+	GitDir    string
+	CommonDir string
+}
+
+// Dir return the repository folder holding the ref. Branches, tags and other
+// refs are shared by the working trees, HEAD, the pseudo refs like ORIG_HEAD
+// and the refs under refs/worktree/ belong to each working tree.
+func (s Store) Dir(name string) string {
+	if !strings.HasPrefix(name, "refs/") || strings.HasPrefix(name, "refs/worktree/") {
+		return s.GitDir
+	}
+	return s.CommonDir
+}
+
+// File return the loose file of the ref.
+func (s Store) File(name string) string {
+	return filepath.Join(s.Dir(name), filepath.FromSlash(name))
+}
+
+// Read return the content of the loose ref without following it, the error
+// wraps fs.ErrNotExist when there is no such ref.
+func (s Store) Read(name string) (Ref, error) {
+	file := s.File(name)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		// a directory of refs like refs/heads is not a ref, and neither is a
+		// path going through a ref like refs/heads/master/x
+		if errors.Is(err, syscall.ENOTDIR) {
+			err = &fs.PathError{Op: "open", Path: file, Err: fs.ErrNotExist}
+		} else if info, statErr := os.Stat(file); statErr == nil && info.IsDir() {
+			err = &fs.PathError{Op: "open", Path: file, Err: fs.ErrNotExist}
+		}
+		return Ref{}, fmt.Errorf("read ref %s: %w", name, err)
+	}
+	content := strings.TrimSpace(string(data))
+	if strings.HasPrefix(content, SymbolicPrefix) {
+		return Ref{Name: name, Target: strings.TrimPrefix(content, SymbolicPrefix)}, nil
+	}
+	if !IsSha1(content) {
+		return Ref{}, fmt.Errorf("invalid ref %s: '%s'", name, content)
+	}
+	return Ref{Name: name, Sha1: content}, nil
+}
+
+// Resolve follow the symbolic refs from the ref and return the name of the
+// last one and the sha1 it points to. The sha1 is empty when a symbolic ref
+// points to a ref which does not exist yet, like HEAD on the branch of a new
+// repository, but the ref itself must exist.
+func (s Store) Resolve(name string) (string, string, error) {
+	current := name
+	for depth := 0; depth <= MaxDepth; depth++ {
+		ref, err := s.Read(current)
+		if depth > 0 && errors.Is(err, fs.ErrNotExist) {
+			return current, "", nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if !ref.Symbolic() {
+			return current, ref.Sha1, nil
+		}
+		current = ref.Target
+	}
+	return "", "", fmt.Errorf("ref %s: symbolic refs nested too deeply", name)
+}
+
+// List return the loose refs whose name starts with the prefix, like
+// "refs/heads/", sorted by name.
+func (s Store) List(prefix string) ([]Ref, error) {
+	dirs := []string{s.CommonDir}
+	if filepath.Clean(s.GitDir) != filepath.Clean(s.CommonDir) {
+		dirs = append(dirs, s.GitDir)
+	}
+	var refs []Ref
+	for _, dir := range dirs {
+		root := filepath.Join(dir, "refs")
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if p == root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(rel)
+			// refs of the other folder and pending updates are skipped
+			if !strings.HasPrefix(name, prefix) || strings.HasSuffix(name, lockSuffix) || s.Dir(name) != dir {
+				return nil
+			}
+			ref, err := s.Read(name)
+			if err != nil {
+				return err
+			}
+			refs = append(refs, ref)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+	return refs, nil
+}
+
+// UpdateParam is an update of a ref.
+type UpdateParam struct {
+	Name string
+	// New is the sha1 the ref points to after the update, empty deletes the
+	// ref.
+	New string
+	// Old is the sha1 the ref must point to for the update to happen, empty
+	// skips the check and ZeroSha1 requires the ref not to exist.
+	Old string
+	// NoDeref updates a symbolic ref itself instead of the ref it points to.
+	NoDeref bool
+}
+
+// Update point the ref to the new sha1, or delete it, when it still points to
+// the old one. The ref is locked from the check to the update so concurrent
+// updates of the ref fail instead of overwriting each other.
+func (s Store) Update(param UpdateParam) error {
+	if param.New != "" && !IsSha1(param.New) {
+		return fmt.Errorf("update ref %s: invalid sha1 '%s'", param.Name, param.New)
+	}
+	name := param.Name
+	if !param.NoDeref {
+		resolved, _, err := s.Resolve(name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err == nil {
+			name = resolved
+		}
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	lock, err := s.lock(name)
+	if err != nil {
+		return err
+	}
+	defer lock.abort()
+
+	_, current, err := s.Resolve(name)
+	exists := !errors.Is(err, fs.ErrNotExist)
+	if err != nil && exists {
+		return err
+	}
+	switch {
+	case param.Old == "":
+	case param.Old == ZeroSha1 && exists:
+		return fmt.Errorf("cannot lock ref '%s': reference already exists", param.Name)
+	case param.Old != ZeroSha1 && !exists:
+		return fmt.Errorf("cannot lock ref '%s': unable to resolve reference '%s'", param.Name, name)
+	case param.Old != ZeroSha1 && current != param.Old:
+		return fmt.Errorf("cannot lock ref '%s': is at %s but expected %s", param.Name, current, param.Old)
+	}
+	if param.New == "" {
+		if err := os.Remove(s.File(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return lock.commit(param.New)
+}
+
+// WriteSymbolic point the ref to the target ref.
+func (s Store) WriteSymbolic(name, target string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if err := CheckFormat(target, 0); err != nil || !strings.HasPrefix(target, "refs/") {
+		return fmt.Errorf("refusing to point %s outside of refs/: '%s'", name, target)
+	}
+	lock, err := s.lock(name)
+	if err != nil {
+		return err
+	}
+	defer lock.abort()
+	return lock.commit(SymbolicPrefix + target)
+}
+
+// checkName report an error when the name can not be written, it must be a
+// valid ref under refs/ or an uppercase pseudo ref like HEAD.
+func checkName(name string) error {
+	if strings.HasPrefix(name, "refs/") {
+		return CheckFormat(name, 0)
+	}
+	if name == "" || strings.Trim(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZ_") != "" {
+		return fmt.Errorf("refusing to update ref with bad name '%s'", name)
+	}
+	return nil
+}
+
+// lockFile is the lock of a ref, its new content is written to the lock file
+// then renamed over the ref.
+type lockFile struct {
+	file *os.File
+	path string
+	done bool
+}
+
+// lock create the lock file of the ref, failing when it already exists.
+func (s Store) lock(name string) (*lockFile, error) {
+	file := s.File(name)
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		// a ref is in the way of the directories of the ref
+		for prefix := path.Dir(name); errors.Is(err, syscall.ENOTDIR) && prefix != "."; prefix = path.Dir(prefix) {
+			if info, statErr := os.Stat(s.File(prefix)); statErr == nil && !info.IsDir() {
+				return nil, fmt.Errorf("cannot lock ref '%s': '%s' exists; cannot create '%s'", name, prefix, name)
+			}
+		}
+		return nil, fmt.Errorf("cannot lock ref '%s': %w", name, err)
+	}
+	f, err := os.OpenFile(file+lockSuffix, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("cannot lock ref '%s': unable to create '%s': file exists, another process seems to be updating it", name, file+lockSuffix)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot lock ref '%s': %w", name, err)
+	}
+	return &lockFile{file: f, path: file}, nil
+}
+
+// commit write the content to the lock file and rename it over the ref.
+func (l *lockFile) commit(content string) error {
+	if _, err := l.file.WriteString(content + "\n"); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(l.path+lockSuffix, l.path); err != nil {
+		return err
+	}
+	l.done = true
+	return nil
+}
+
+// abort remove the lock file unless it was committed.
+func (l *lockFile) abort() {
+	if l.done {
+		return
+	}
+	l.file.Close()
+	os.Remove(l.path + lockSuffix)
+}
+
+// FormatFlags are the options of CheckFormat.
+type FormatFlags uint8
+
+const (
+	// AllowOneLevel accepts names without a slash, like HEAD.
+	AllowOneLevel FormatFlags = 1 << iota
+	// RefspecPattern accepts a single '*' in the name.
+	RefspecPattern
+)
+
+// CheckFormat report an error when the name is not a valid ref name, with
+// the rules of git check-ref-format.
+func CheckFormat(name string, flags FormatFlags) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("'%s' is not a valid ref name: %s", name, reason)
+	}
+	switch {
+	case name == "":
+		return invalid("it is empty")
+	case name == "@":
+		return invalid("it is '@'")
+	case strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/"):
+		return invalid("it begins or ends with '/'")
+	case strings.HasSuffix(name, "."):
+		return invalid("it ends with '.'")
+	case strings.Contains(name, ".."):
+		return invalid("it contains '..'")
+	case strings.Contains(name, "@{"):
+		return invalid("it contains '@{'")
+	}
+	stars := 0
+	for _, c := range name {
+		switch {
+		case c < ' ' || c == 0x7f:
+			return invalid("it contains a control character")
+		case strings.ContainsRune(" ~^:?[\\", c):
+			return invalid(fmt.Sprintf("it contains '%c'", c))
+		case c == '*':
+			stars++
+		}
+	}
+	if stars > 1 || stars == 1 && flags&RefspecPattern == 0 {
+		return invalid("it contains '*'")
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) == 1 && flags&AllowOneLevel == 0 {
+		return invalid("it has a single level")
+	}
+	for _, part := range parts {
+		switch {
+		case part == "":
+			return invalid("it contains '//'")
+		case strings.HasPrefix(part, "."):
+			return invalid("a component begins with '.'")
+		case strings.HasSuffix(part, lockSuffix):
+			return invalid("a component ends with '.lock'")
+		}
+	}
+	return nil
+}
+
+// Normalize remove the leading slash and collapse the repeated slashes of
+// the name, like git check-ref-format --normalize before checking it.
+func Normalize(name string) string {
+	name = strings.TrimLeft(name, "/")
+	for strings.Contains(name, "//") {
+		name = strings.ReplaceAll(name, "//", "/")
+	}
+	return name
+}
+
+// IsSha1 report whether s is a full sha1 in lowercase hexadecimal.
+func IsSha1(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	return strings.Trim(s, "0123456789abcdef") == ""
+}
@@ -0,0 +1,192 @@
+package refs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	sha1A = "1111111111111111111111111111111111111111"
+	sha1B = "2222222222222222222222222222222222222222"
+)
+
+func TestStore(t *testing.T) {
+	common := t.TempDir()
+	s := Store{GitDir: common, CommonDir: common}
+	if err := s.WriteSymbolic("HEAD", "refs/heads/master"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(common, "HEAD")); string(data) != "ref: refs/heads/master\n" {
+		t.Fatalf("unexpected HEAD %q", data)
+	}
+	// HEAD of an unborn branch resolves to the branch without sha1
+	if name, sha1, err := s.Resolve("HEAD"); name != "refs/heads/master" || sha1 != "" || err != nil {
+		t.Fatalf("expected unborn master, but got %s %q %v", name, sha1, err)
+	}
+	if _, _, err := s.Resolve("refs/heads/master"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected a missing ref, but got %v", err)
+	}
+
+	// updates through HEAD update the branch, when the old value matches
+	if err := s.Update(UpdateParam{Name: "HEAD", New: sha1A, Old: ZeroSha1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update(UpdateParam{Name: "HEAD", New: sha1B, Old: ZeroSha1}); err == nil || !strings.Contains(err.Error(), "reference already exists") {
+		t.Fatalf("expected the create to fail, but got %v", err)
+	}
+	if err := s.Update(UpdateParam{Name: "HEAD", New: sha1B, Old: sha1B}); err == nil || !strings.Contains(err.Error(), "is at "+sha1A+" but expected "+sha1B) {
+		t.Fatalf("expected a stale update to fail, but got %v", err)
+	}
+	if err := s.Update(UpdateParam{Name: "HEAD", New: sha1B, Old: sha1A}); err != nil {
+		t.Fatal(err)
+	}
+	if ref, err := s.Read("refs/heads/master"); err != nil || ref != (Ref{Name: "refs/heads/master", Sha1: sha1B}) {
+		t.Fatalf("expected master at %s, but got %+v, %v", sha1B, ref, err)
+	}
+	if ref, err := s.Read("HEAD"); err != nil || !ref.Symbolic() {
+		t.Fatalf("expected HEAD to stay symbolic, but got %+v, %v", ref, err)
+	}
+	// a path through a ref is not a ref and can not be created
+	if _, err := s.Read("refs/heads/master/x"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected a missing ref, but got %v", err)
+	}
+	if err := s.Update(UpdateParam{Name: "refs/heads/master/x", New: sha1A}); err == nil || !strings.Contains(err.Error(), "'refs/heads/master' exists; cannot create 'refs/heads/master/x'") {
+		t.Fatalf("expected the create to fail, but got %v", err)
+	}
+
+	// a held lock fails the update
+	lock := filepath.Join(common, "refs", "heads", "master.lock")
+	if err := os.WriteFile(lock, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update(UpdateParam{Name: "refs/heads/master", New: sha1A}); err == nil || !strings.Contains(err.Error(), "file exists") {
+		t.Fatalf("expected a locked update to fail, but got %v", err)
+	}
+	os.Remove(lock)
+
+	// detaching HEAD writes HEAD itself
+	if err := s.Update(UpdateParam{Name: "HEAD", New: sha1A, NoDeref: true}); err != nil {
+		t.Fatal(err)
+	}
+	if name, sha1, err := s.Resolve("HEAD"); name != "HEAD" || sha1 != sha1A || err != nil {
+		t.Fatalf("expected a detached HEAD, but got %s %s %v", name, sha1, err)
+	}
+
+	// symbolic refs pointing to each other never resolve
+	for _, link := range [][2]string{{"refs/heads/a", "refs/heads/b"}, {"refs/heads/b", "refs/heads/a"}} {
+		if err := s.WriteSymbolic(link[0], link[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := s.Resolve("refs/heads/a"); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Fatalf("expected a loop error, but got %v", err)
+	}
+	if err := s.WriteSymbolic("HEAD", "master"); err == nil {
+		t.Fatal("expected a target outside of refs/ to fail")
+	}
+
+	if err := s.Update(UpdateParam{Name: "refs/tags/v1", New: sha1A}); err != nil {
+		t.Fatal(err)
+	}
+	refs, err := s.List("refs/heads/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Ref{
+		{Name: "refs/heads/a", Target: "refs/heads/b"},
+		{Name: "refs/heads/b", Target: "refs/heads/a"},
+		{Name: "refs/heads/master", Sha1: sha1B},
+	}
+	if !reflect.DeepEqual(refs, want) {
+		t.Fatalf("expected %+v, but got %+v", want, refs)
+	}
+
+	if err := s.Update(UpdateParam{Name: "refs/tags/v1", Old: sha1B}); err == nil {
+		t.Fatal("expected a stale delete to fail")
+	}
+	if err := s.Update(UpdateParam{Name: "refs/tags/v1", Old: sha1A}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Read("refs/tags/v1"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected v1 deleted, but got %v", err)
+	}
+	if _, err := s.Read("refs/tags"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected a folder not to be a ref, but got %v", err)
+	}
+}
+
+func TestStoreWorktree(t *testing.T) {
+	common := t.TempDir()
+	dir := filepath.Join(common, "worktrees", "wt")
+	s := Store{GitDir: dir, CommonDir: common}
+	for _, name := range []string{"HEAD", "refs/worktree/bisect", "refs/heads/wt"} {
+		if err := s.Update(UpdateParam{Name: name, New: sha1A}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, file := range map[string]string{
+		"HEAD":                 filepath.Join(dir, "HEAD"),
+		"refs/worktree/bisect": filepath.Join(dir, "refs", "worktree", "bisect"),
+		"refs/heads/wt":        filepath.Join(common, "refs", "heads", "wt"),
+	} {
+		if _, err := os.Stat(file); err != nil {
+			t.Fatalf("expected %s in %s, but got %v", name, file, err)
+		}
+	}
+	refs, err := s.List("refs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0].Name != "refs/heads/wt" || refs[1].Name != "refs/worktree/bisect" {
+		t.Fatalf("unexpected refs %+v", refs)
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		flags FormatFlags
+		valid bool
+	}{
+		{"refs/heads/master", 0, true},
+		{"refs/heads/feature/x-1", 0, true},
+		{"HEAD", 0, false},
+		{"HEAD", AllowOneLevel, true},
+		{"", AllowOneLevel, false},
+		{"@", AllowOneLevel, false},
+		{"refs/heads/@", 0, true},
+		{"/refs/heads/x", 0, false},
+		{"refs/heads/x/", 0, false},
+		{"refs//heads", 0, false},
+		{"refs/heads/x.", 0, false},
+		{"refs/heads/.x", 0, false},
+		{"refs/heads/x.lock", 0, false},
+		{"refs/heads/x.lock/y", 0, false},
+		{"refs/heads/a..b", 0, false},
+		{"refs/heads/a@{1}", 0, false},
+		{"refs/heads/a b", 0, false},
+		{"refs/heads/a~1", 0, false},
+		{"refs/heads/a^", 0, false},
+		{"refs/heads/a:b", 0, false},
+		{"refs/heads/a?", 0, false},
+		{"refs/heads/a[", 0, false},
+		{"refs/heads/a\\b", 0, false},
+		{"refs/heads/a\tb", 0, false},
+		{"refs/heads/*", 0, false},
+		{"refs/heads/*", RefspecPattern, true},
+		{"refs/*/*", RefspecPattern, false},
+	} {
+		err := CheckFormat(tc.name, tc.flags)
+		if (err == nil) != tc.valid {
+			t.Errorf("CheckFormat(%q, %d) == %v, want valid %v", tc.name, tc.flags, err, tc.valid)
+		}
+	}
+	if got := Normalize("//refs///heads//x"); got != "refs/heads/x" {
+		t.Fatalf("expected refs/heads/x, but got %q", got)
+	}
+}
@@ -0,0 +1,268 @@
+package tinygit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func sparseCheckoutFile() string {
+	return filepath.Join(gitDir(), "info", "sparse-checkout")
+}
+
+// sparseCone is a set of cone mode patterns. Files at the top level are always
+// included, as are the files directly inside the parents of a recursive
+// directory and every file below a recursive directory.
+type sparseCone struct {
+	recursive map[string]bool
+	parents   map[string]bool
+}
+
+func newSparseCone(dirs []string) sparseCone {
+	cone := sparseCone{
+		recursive: make(map[string]bool),
+		parents:   make(map[string]bool),
+	}
+	for _, dir := range dirs {
+		cone.recursive[dir] = true
+		for parent := path.Dir(dir); parent != "."; parent = path.Dir(parent) {
+			cone.parents[parent] = true
+		}
+	}
+	return cone
+}
+
+// dirs return the sorted recursive directories, dropping the ones already
+// covered by a recursive parent.
+func (c sparseCone) dirs() []string {
+	var dirs []string
+	for dir := range c.recursive {
+		covered := false
+		for parent := path.Dir(dir); parent != "."; parent = path.Dir(parent) {
+			if c.recursive[parent] {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// includes report whether the repo relative file path is inside the cone.
+func (c sparseCone) includes(p string) bool {
+	dir := path.Dir(p)
+	if dir == "." || c.parents[dir] {
+		return true
+	}
+	for ; dir != "."; dir = path.Dir(dir) {
+		if c.recursive[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// patterns render the cone the way Git writes the sparse-checkout file.
+func (c sparseCone) patterns() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("/*\n!/*/\n")
+	dirs := c.dirs()
+	written := make(map[string]bool)
+	for _, dir := range dirs {
+		var parents []string
+		for parent := path.Dir(dir); parent != "."; parent = path.Dir(parent) {
+			parents = append([]string{parent}, parents...)
+		}
+		for _, parent := range parents {
+			if written[parent] {
+				continue
+			}
+			written[parent] = true
+			fmt.Fprintf(&buf, "/%s/\n!/%s/*/\n", parent, parent)
+		}
+		written[dir] = true
+		fmt.Fprintf(&buf, "/%s/\n", dir)
+	}
+	return buf.Bytes()
+}
+
+// parseSparseCone parse the cone mode patterns, a positive directory pattern
+// without the matching "!/<dir>/*/" negation is a recursive directory.
+func parseSparseCone(data []byte) (sparseCone, error) {
+	var positive []string
+	negated := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || line == "/*" || line == "!/*/":
+		case strings.HasPrefix(line, "!/") && strings.HasSuffix(line, "/*/"):
+			negated[strings.TrimSuffix(strings.TrimPrefix(line, "!/"), "/*/")] = true
+		case strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") && len(line) > 2:
+			positive = append(positive, strings.Trim(line, "/"))
+		default:
+			return sparseCone{}, fmt.Errorf("unrecognized cone mode pattern '%s'", line)
+		}
+	}
+	var dirs []string
+	for _, dir := range positive {
+		if !negated[dir] {
+			dirs = append(dirs, dir)
+		}
+	}
+	return newSparseCone(dirs), scanner.Err()
+}
+
+// readSparseCone read the cone of the repository, ok is false when sparse
+// checkout is not enabled.
+func readSparseCone() (cone sparseCone, ok bool, err error) {
+	data, err := os.ReadFile(sparseCheckoutFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		return sparseCone{}, false, nil
+	}
+	if err != nil {
+		return sparseCone{}, false, fmt.Errorf("read sparse-checkout file: %w", err)
+	}
+	cone, err = parseSparseCone(data)
+	return cone, err == nil, err
+}
+
+func cleanSparseDirs(dirs []string) ([]string, error) {
+	cleaned := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		rel, err := repoRelPath(dir)
+		if err != nil {
+			return nil, err
+		}
+		if rel == "." {
+			return nil, fmt.Errorf("'%s' is not a directory inside the repository", dir)
+		}
+		cleaned = append(cleaned, rel)
+	}
+	return cleaned, nil
+}
+
+// SparseCheckoutSet enable sparse checkout with the given directories as the
+// cone and update the working tree accordingly.
+func SparseCheckoutSet(dirs []string) error {
+	cleaned, err := cleanSparseDirs(dirs)
+	if err != nil {
+		return err
+	}
+	return writeSparseCone(newSparseCone(cleaned))
+}
+
+// SparseCheckoutAdd add directories to the current cone.
+func SparseCheckoutAdd(dirs []string) error {
+	cone, ok, err := readSparseCone()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("no sparse-checkout to add to")
+	}
+	cleaned, err := cleanSparseDirs(dirs)
+	if err != nil {
+		return err
+	}
+	return writeSparseCone(newSparseCone(append(cone.dirs(), cleaned...)))
+}
+
+// SparseCheckoutList return the directories of the cone.
+func SparseCheckoutList() ([]string, error) {
+	cone, ok, err := readSparseCone()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("this worktree is not sparse")
+	}
+	return cone.dirs(), nil
+}
+
+// SparseCheckoutDisable restore every file of the index into the working tree
+// and turn sparse checkout off.
+func SparseCheckoutDisable() error {
+	if err := applySparseCheckout(nil); err != nil {
+		return err
+	}
+	if err := os.Remove(sparseCheckoutFile()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func writeSparseCone(cone sparseCone) error {
+	if err := os.MkdirAll(filepath.Dir(sparseCheckoutFile()), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.WriteFile(sparseCheckoutFile(), cone.patterns(), 0644); err != nil {
+		return err
+	}
+	return applySparseCheckout(&cone)
+}
+
+// applySparseCheckout mark the index entries outside of the cone as
+// skip-worktree and remove their files, while the entries inside the cone
+// get their files written back. A nil cone includes everything. Files with
+// local modifications are left in the working tree and are not marked.
+func applySparseCheckout(cone *sparseCone) error {
+	lock, err := lockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.abort()
+	indexes, err := ReadIndex()
+	if err != nil {
+		return err
+	}
+	filter, err := newConvertFilter()
+	if err != nil {
+		return err
+	}
+	indexTime := indexModTime()
+	for i, index := range indexes {
+		if index.Stage() != StageMerged {
+			continue
+		}
+		if cone == nil || cone.includes(index.Path) {
+			if !index.SkipWorktree() {
+				continue
+			}
+			index.SetSkipWorktree(false)
+			if _, err := os.Lstat(filepath.FromSlash(index.Path)); errors.Is(err, fs.ErrNotExist) {
+				if index, err = checkoutIndex(filter, index); err != nil {
+					return err
+				}
+			}
+			indexes[i] = index
+			continue
+		}
+		if index.SkipWorktree() {
+			continue
+		}
+		change, err := index.worktreeChange(filter, indexTime)
+		if err != nil {
+			return err
+		}
+		if change == worktreeModified {
+			continue
+		}
+		if err := removeWorktreeFile(index.Path); err != nil {
+			return err
+		}
+		indexes[i].SetSkipWorktree(true)
+	}
+	return writeIndex(lock, indexes)
+}
@@ -0,0 +1,90 @@
+package tinygit
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSparseCone(t *testing.T) {
+	cone := newSparseCone([]string{"a/b/c", "d", "d/e"})
+	want := "/*\n!/*/\n/a/\n!/a/*/\n/a/b/\n!/a/b/*/\n/a/b/c/\n/d/\n"
+	if got := string(cone.patterns()); got != want {
+		t.Fatalf("expected patterns %q, but got %q", want, got)
+	}
+	parsed, err := parseSparseCone([]byte(want))
+	if err != nil {
+		t.Fatalf("parse cone: %+v", err)
+	}
+	if got, want := parsed.dirs(), []string{"a/b/c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected dirs %v, but got %v", want, got)
+	}
+
+	cases := map[string]bool{
+		"top.txt":        true,
+		"a/file.txt":     true,
+		"a/x/file.txt":   false,
+		"a/b/file.txt":   true,
+		"a/b/c/file.txt": true,
+		"a/b/c/x/y.txt":  true,
+		"d/x/y.txt":      true,
+		"e/file.txt":     false,
+	}
+	for path, want := range cases {
+		if got := parsed.includes(path); got != want {
+			t.Errorf("includes(%s) == %v want %v", path, got, want)
+		}
+	}
+}
+
+func TestSparseCheckout(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{
+		"top.txt":       "top",
+		"src/a.txt":     "a",
+		"docs/b.txt":    "b",
+		"docs/sub/c.md": "c",
+	})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+
+	if err := SparseCheckoutSet([]string{"src"}); err != nil {
+		t.Fatalf("sparse-checkout set: %+v", err)
+	}
+	if _, err := os.Stat("docs"); !os.IsNotExist(err) {
+		t.Fatalf("expected docs to be removed, but got %v", err)
+	}
+	want := []string{"S docs/b.txt", "S docs/sub/c.md", "H src/a.txt", "H top.txt"}
+	if got := mustLsFiles(t, LsFilesParam{Tags: true}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+
+	if err := SparseCheckoutAdd([]string{"docs/sub"}); err != nil {
+		t.Fatalf("sparse-checkout add: %+v", err)
+	}
+	list, err := SparseCheckoutList()
+	if err != nil {
+		t.Fatalf("sparse-checkout list: %+v", err)
+	}
+	if want := []string{"docs/sub", "src"}; !reflect.DeepEqual(list, want) {
+		t.Fatalf("expected list %v, but got %v", want, list)
+	}
+	if data, err := os.ReadFile("docs/sub/c.md"); err != nil || string(data) != "c" {
+		t.Fatalf("expected docs/sub/c.md restored, but got %q, %v", data, err)
+	}
+	// files directly in the parent of a cone directory are included
+	if _, err := os.Stat("docs/b.txt"); err != nil {
+		t.Fatalf("expected docs/b.txt restored, but got %v", err)
+	}
+
+	if err := SparseCheckoutDisable(); err != nil {
+		t.Fatalf("sparse-checkout disable: %+v", err)
+	}
+	if got := mustLsFiles(t, LsFilesParam{Modified: true}); len(got) != 0 {
+		t.Fatalf("expected clean working tree, but got %v", got)
+	}
+	if _, err := SparseCheckoutList(); err == nil {
+		t.Fatal("expected error listing a disabled sparse-checkout")
+	}
+}
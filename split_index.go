@@ -0,0 +1,256 @@
+package tinygit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/startdusk/tinygit/shared/binarypack"
+	"github.com/startdusk/tinygit/shared/ewah"
+)
+
+// In split index mode the index is made of a shared index file holding most
+// entries and the small index file holding only the entries changed since.
+// The "link" extension of the index file names the shared index by its
+// checksum and marks the shared entries that were deleted or replaced. The
+// entries replacing shared ones come first in the index file, in the order of
+// the shared entries, followed by the added entries.
+const (
+	linkSignature = "link"
+
+	defaultMaxPercentChange = 20
+)
+
+func sharedIndexPrefix() string {
+	return filepath.Join(gitDir(), "sharedindex.")
+}
+
+var linkFormat = []string{"40s"}
+
+func sharedIndexFile(checksum string) string {
+	return sharedIndexPrefix() + checksum
+}
+
+// encodeLink pack the shared index checksum followed by the EWAH bitmaps of
+// the deleted and of the replaced shared entries.
+func encodeLink(checksum string, deleted, replaced *ewah.Bitmap) ([]byte, error) {
+	data, err := binarypack.Pack(linkFormat, []any{checksum}, binary.BigEndian)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, deleted.Encode()...)
+	return append(data, replaced.Encode()...), nil
+}
+
+func decodeLink(data []byte) (string, *ewah.Bitmap, *ewah.Bitmap, error) {
+	fields, err := binarypack.UnPack(linkFormat, data, binary.BigEndian)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid link extension: %w", err)
+	}
+	checksum, _ := fields[0].(string)
+	deleted, rest, err := ewah.Read(data[checkSumSize:])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid link extension delete bitmap: %w", err)
+	}
+	replaced, err := ewah.Decode(rest)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid link extension replace bitmap: %w", err)
+	}
+	return checksum, deleted, replaced, nil
+}
+
+// sharedIndexCache keeps the last shared index read or written, so writing
+// the split index compares the entries with it instead of reading and
+// decoding the whole shared index again.
+var sharedIndexCache struct {
+	sync.Mutex
+	checksum string
+	entries  Indexes
+}
+
+// loadSharedIndex return the entries of the shared index with the checksum,
+// read from its file unless it is the cached one.
+func loadSharedIndex(checksum string) (Indexes, error) {
+	file := sharedIndexFile(checksum)
+	// the file is checked even when cached, another repository may share the
+	// checksum but not the file
+	if _, err := os.Stat(file); err != nil {
+		return nil, fmt.Errorf("read shared index: %w", err)
+	}
+	sharedIndexCache.Lock()
+	defer sharedIndexCache.Unlock()
+	if sharedIndexCache.checksum == checksum {
+		return sharedIndexCache.entries, nil
+	}
+	shared, _, err := readIndexFile(file)
+	if err != nil {
+		return nil, err
+	}
+	sharedIndexCache.checksum, sharedIndexCache.entries = checksum, shared
+	return shared, nil
+}
+
+// cacheSharedIndex remember the entries of the shared index just written.
+func cacheSharedIndex(checksum string, entries Indexes) {
+	sharedIndexCache.Lock()
+	defer sharedIndexCache.Unlock()
+	sharedIndexCache.checksum, sharedIndexCache.entries = checksum, entries
+}
+
+// readSharedIndex read the shared index linked by the extension. The entries
+// are shared with the cache and must not be modified.
+func readSharedIndex(ext indexExtension) (string, Indexes, *ewah.Bitmap, *ewah.Bitmap, error) {
+	checksum, deleted, replaced, err := decodeLink(ext.Data)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	shared, err := loadSharedIndex(checksum)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	if len(shared) != deleted.Len() || len(shared) != replaced.Len() {
+		return "", nil, nil, nil, fmt.Errorf("shared index %s does not match the link extension", checksum)
+	}
+	return checksum, shared, deleted, replaced, nil
+}
+
+// readSplitIndex merge the entries of the index file with the entries of its
+// shared index which are not deleted, the first entries of the index file
+// replacing the shared entries marked as replaced.
+func readSplitIndex(entries Indexes, ext indexExtension) (Indexes, error) {
+	_, shared, deleted, replaced, err := readSharedIndex(ext)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(Indexes, 0, len(shared)+len(entries))
+	for i, index := range shared {
+		switch {
+		case replaced.Get(i):
+			if len(entries) == 0 {
+				return nil, errors.New("invalid link extension: too many replaced entries")
+			}
+			merged = append(merged, entries[0])
+			entries = entries[1:]
+		case !deleted.Get(i):
+			merged = append(merged, index)
+		}
+	}
+	merged = append(merged, entries...)
+	return merged.Sort(), nil
+}
+
+// writeSplitIndex write only the entries changed since the current shared
+// index. When the changes exceed splitIndex.maxPercentChange percent of the
+// entries, every entry is written to a new shared index instead. The other
+// extensions are written after the link one.
+func writeSplitIndex(lock *indexLock, indexes []Index, config *Config, exts []indexExtension) error {
+	maxPercent, err := config.GetInt("splitIndex.maxPercentChange", defaultMaxPercentChange)
+	if err != nil {
+		return err
+	}
+
+	_, current, err := readIndexFile(indexFile())
+	if err != nil {
+		return err
+	}
+	for _, ext := range current {
+		if ext.Signature != linkSignature {
+			continue
+		}
+		checksum, shared, _, _, err := readSharedIndex(ext)
+		if err != nil {
+			// a missing or broken shared index is replaced by a new one
+			break
+		}
+		diff := diffSharedIndex(shared, indexes)
+		changes := len(diff.replacements) + len(diff.added) + diff.deletedNum
+		if changes*100 > maxPercent*(len(shared)+len(diff.added)) {
+			break
+		}
+		link, err := encodeLink(checksum, diff.deleted, diff.replaced)
+		if err != nil {
+			return err
+		}
+		changed := append(diff.replacements, diff.added...)
+		return lock.commit(changed, append([]indexExtension{{Signature: linkSignature, Data: link}}, exts...))
+	}
+
+	// re-merge every entry into a new shared index
+	data, err := encodeIndex(indexes, nil)
+	if err != nil {
+		return err
+	}
+	checksum := string(data[len(data)-checkSumSize:])
+	// like an object, the file named by its checksum is written at once
+	if err := writeObjectFile(sharedIndexFile(checksum), data); err != nil {
+		return err
+	}
+	link, err := encodeLink(checksum, ewah.New(len(indexes)), ewah.New(len(indexes)))
+	if err != nil {
+		return err
+	}
+	if err := lock.commit(nil, append([]indexExtension{{Signature: linkSignature, Data: link}}, exts...)); err != nil {
+		return err
+	}
+	cacheSharedIndex(checksum, indexes)
+	return removeSharedIndexes(checksum)
+}
+
+// sharedIndexDiff is the difference between the shared entries and the
+// entries to write.
+type sharedIndexDiff struct {
+	// deleted and replaced mark the shared entries deleted or replaced.
+	deleted, replaced *ewah.Bitmap
+	// replacements are the entries replacing shared ones, in their order.
+	replacements Indexes
+	// added are the entries missing from the shared index.
+	added      Indexes
+	deletedNum int
+}
+
+// diffSharedIndex walk the sorted shared entries and the sorted entries to
+// write side by side.
+func diffSharedIndex(shared, indexes Indexes) sharedIndexDiff {
+	diff := sharedIndexDiff{deleted: ewah.New(len(shared)), replaced: ewah.New(len(shared))}
+	i, j := 0, 0
+	for i < len(shared) || j < len(indexes) {
+		switch {
+		case j == len(indexes) || i < len(shared) && indexLess(shared[i], indexes[j]):
+			diff.deleted.Set(i)
+			diff.deletedNum++
+			i++
+		case i == len(shared) || indexLess(indexes[j], shared[i]):
+			diff.added = append(diff.added, indexes[j])
+			j++
+		default:
+			if shared[i] != indexes[j] {
+				diff.replaced.Set(i)
+				diff.replacements = append(diff.replacements, indexes[j])
+			}
+			i++
+			j++
+		}
+	}
+	return diff
+}
+
+// removeSharedIndexes remove the shared index files except the one to keep.
+func removeSharedIndexes(keep string) error {
+	files, err := filepath.Glob(sharedIndexPrefix() + "*")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if strings.TrimPrefix(file, sharedIndexPrefix()) == keep {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
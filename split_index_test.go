@@ -0,0 +1,163 @@
+package tinygit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/startdusk/tinygit/shared/ewah"
+)
+
+func sharedIndexFiles(t *testing.T) []string {
+	t.Helper()
+	files, err := filepath.Glob(sharedIndexPrefix() + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestSplitIndex(t *testing.T) {
+	setupRepo(t)
+	files := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("file%02d.txt", i)] = fmt.Sprintf("content %d", i)
+	}
+	writeFiles(t, files)
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if err := UpdateIndex(UpdateIndexParam{SplitIndex: true}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	shared := sharedIndexFiles(t)
+	if len(shared) != 1 {
+		t.Fatalf("expected one shared index, but got %v", shared)
+	}
+	info, err := os.Stat(shared[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Fatalf("expected a 0644 shared index, but got %v", info.Mode())
+	}
+	entries, _, err := readIndexFile(indexFile())
+	if err != nil {
+		t.Fatalf("read index file: %+v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected an empty split index, but got %d entries", len(entries))
+	}
+
+	// a small change only touches the split index
+	writeFiles(t, map[string]string{"file03.txt": "changed", "new.txt": "new"})
+	if err := Add(AddParam{Paths: []string{"file03.txt", "new.txt", "file05.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if err := UpdateIndex(UpdateIndexParam{Paths: []string{"file05.txt"}, ForceRemove: true}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	if got := sharedIndexFiles(t); !reflect.DeepEqual(got, shared) {
+		t.Fatalf("expected shared index %v to be kept, but got %v", shared, got)
+	}
+	entries, _, err = readIndexFile(indexFile())
+	if err != nil {
+		t.Fatalf("read index file: %+v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries in the split index, but got %+v", entries)
+	}
+	indexes, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	if len(indexes) != 20 {
+		t.Fatalf("expected 20 entries, but got %d", len(indexes))
+	}
+	if _, ok := indexes.Find("file05.txt"); ok {
+		t.Fatal("expected file05.txt to be removed")
+	}
+	i, ok := indexes.Find("file03.txt")
+	sha1, _, _ := HashObject(HashParam{Data: []byte("changed"), ObjType: Blob})
+	if !ok || indexes[i].Sha1 != sha1 {
+		t.Fatalf("expected file03.txt to be updated, but got %+v", indexes[i])
+	}
+
+	// past the threshold the entries are merged into a new shared index
+	changed := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		changed[fmt.Sprintf("file%02d.txt", i)] = fmt.Sprintf("changed %d", i)
+	}
+	writeFiles(t, changed)
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if got := sharedIndexFiles(t); len(got) != 1 || reflect.DeepEqual(got, shared) {
+		t.Fatalf("expected a new shared index, but got %v", got)
+	}
+	readed, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	// file05.txt is still in the working tree and added back
+	if len(readed) != 21 {
+		t.Fatalf("expected 21 entries, but got %d", len(readed))
+	}
+
+	if err := UpdateIndex(UpdateIndexParam{NoSplitIndex: true}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	if got := sharedIndexFiles(t); len(got) != 0 {
+		t.Fatalf("expected shared indexes to be removed, but got %v", got)
+	}
+	unsplit, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	if !reflect.DeepEqual(unsplit, readed) {
+		t.Fatal("expected the same entries after turning split index off")
+	}
+}
+
+func TestDiffSharedIndex(t *testing.T) {
+	entry := func(p, sha1 string, stage int) Index {
+		index := Index{Mode: ModeRegular, Sha1: sha1, Path: p}
+		index.SetStage(stage)
+		return index
+	}
+	shared := Indexes{
+		entry("a.txt", "a1", StageMerged),
+		entry("b.txt", "b1", StageMerged),
+		entry("c.txt", "c1", StageMerged),
+		entry("d.txt", "d1", StageMerged),
+	}
+	indexes := Indexes{
+		entry("a.txt", "a1", StageMerged),
+		entry("b.txt", "b2", StageMerged),
+		entry("bb.txt", "bb1", StageMerged),
+		entry("d.txt", "d1", StageOurs),
+		entry("d.txt", "d2", StageTheirs),
+	}
+	diff := diffSharedIndex(shared, indexes)
+	bits := func(b *ewah.Bitmap) []bool {
+		got := make([]bool, b.Len())
+		for i := range got {
+			got[i] = b.Get(i)
+		}
+		return got
+	}
+	if want := []bool{false, false, true, true}; !reflect.DeepEqual(bits(diff.deleted), want) || diff.deletedNum != 2 {
+		t.Fatalf("expected deleted %v, but got %v (%d)", want, bits(diff.deleted), diff.deletedNum)
+	}
+	if want := []bool{false, true, false, false}; !reflect.DeepEqual(bits(diff.replaced), want) {
+		t.Fatalf("expected replaced %v, but got %v", want, bits(diff.replaced))
+	}
+	if want := indexes[1:2]; !reflect.DeepEqual(diff.replacements, want) {
+		t.Fatalf("expected replacements %+v, but got %+v", want, diff.replacements)
+	}
+	if want := indexes[2:]; !reflect.DeepEqual(diff.added, want) {
+		t.Fatalf("expected added %+v, but got %+v", want, diff.added)
+	}
+}
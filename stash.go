@@ -0,0 +1,581 @@
+package tinygit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A stash entry is a commit W recording the working tree, whose parents are
+// the HEAD commit, a commit I recording the index and, when untracked files
+// are stashed, a commit U recording them. The entries are the reflog of
+// refs/stash, the ref itself points to the latest one.
+const stashRef = "refs/stash"
+
+// labels of the sides of the conflicts of an applied stash
+const (
+	stashOursLabel   = "Updated upstream"
+	stashTheirsLabel = "Stashed changes"
+)
+
+// StashPushParam stash push command params.
+type StashPushParam struct {
+	// Message describes the entry instead of the HEAD commit.
+	Message string
+	// IncludeUntracked also stashes and removes the untracked files which are
+	// not ignored.
+	IncludeUntracked bool
+	// KeepIndex leaves the changes added to the index in place.
+	KeepIndex bool
+}
+
+// StashPush save the local modifications of the index and the working tree
+// into a new stash entry and reset them to the HEAD commit.
+func StashPush(param StashPushParam) (string, error) {
+	branch, head, err := readHead()
+	if err != nil {
+		return "", err
+	}
+	if head == "" {
+		return "", errors.New("you do not have the initial commit yet")
+	}
+	lock, err := lockIndex()
+	if err != nil {
+		return "", err
+	}
+	defer lock.abort()
+	indexes, err := ReadIndex()
+	if err != nil {
+		return "", err
+	}
+	if unmerged := indexes.Unmerged(); len(unmerged) > 0 {
+		return "", fmt.Errorf("%s: needs merge\ncannot save the current index state", unmerged[0])
+	}
+	headCommit, err := readCommit(head)
+	if err != nil {
+		return "", err
+	}
+
+	indexTree, err := writeTree(indexes)
+	if err != nil {
+		return "", err
+	}
+	filter, err := newConvertFilter()
+	if err != nil {
+		return "", err
+	}
+	// the working tree version of the tracked files
+	indexTime := indexModTime()
+	var worktree Indexes
+	for _, index := range indexes {
+		if index.SkipWorktree() {
+			worktree = append(worktree, Index{Mode: index.Mode, Sha1: index.Sha1, Path: index.Path})
+			continue
+		}
+		change, err := index.worktreeChange(filter, indexTime)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case change == worktreeDeleted:
+			continue
+		// a submodule records the commit it has checked out
+		case change == worktreeModified && index.Mode == ModeGitlink:
+			gitlink, err := gitlinkIndex(index.Path)
+			if err != nil {
+				return "", err
+			}
+			worktree = append(worktree, gitlink)
+		case change == worktreeModified || index.IntentToAdd():
+			sha1, err := hashFile(filter, index.Path)
+			if err != nil {
+				return "", err
+			}
+			worktree = append(worktree, Index{Mode: worktreeMode(index.Path), Sha1: sha1, Path: index.Path})
+		default:
+			worktree = append(worktree, Index{Mode: index.Mode, Sha1: index.Sha1, Path: index.Path})
+		}
+	}
+	worktreeTree, err := writeTree(worktree)
+	if err != nil {
+		return "", err
+	}
+	var untracked Indexes
+	if param.IncludeUntracked {
+		rules, err := standardIgnoreRules()
+		if err != nil {
+			return "", err
+		}
+		err = walkWorktree(rules, func(p string, info fs.FileInfo) error {
+			// untracked repositories are not stashed
+			if _, tracked := indexes.Find(p); tracked || info.IsDir() {
+				return nil
+			}
+			sha1, err := hashFile(filter, p)
+			if err != nil {
+				return err
+			}
+			untracked = append(untracked, Index{Mode: worktreeMode(p), Sha1: sha1, Path: p})
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	if indexTree == headCommit.Tree && worktreeTree == indexTree && len(untracked) == 0 {
+		return "No local changes to save", nil
+	}
+
+	sig, err := signature()
+	if err != nil {
+		return "", err
+	}
+	name := branch
+	if name == "" {
+		name = "(no branch)"
+	}
+	desc := fmt.Sprintf("%s: %s %s", name, head[:7], headCommit.subject())
+	indexCommit, err := writeCommit(commitObject{
+		Tree:      indexTree,
+		Parents:   []string{head},
+		Author:    sig,
+		Committer: sig,
+		Message:   "index on " + desc + "\n",
+	})
+	if err != nil {
+		return "", err
+	}
+	parents := []string{head, indexCommit}
+	if len(untracked) > 0 {
+		untrackedTree, err := writeTree(untracked)
+		if err != nil {
+			return "", err
+		}
+		untrackedCommit, err := writeCommit(commitObject{
+			Tree:      untrackedTree,
+			Author:    sig,
+			Committer: sig,
+			Message:   "untracked files on " + desc + "\n",
+		})
+		if err != nil {
+			return "", err
+		}
+		parents = append(parents, untrackedCommit)
+	}
+	message := "WIP on " + desc
+	if param.Message != "" {
+		message = "On " + name + ": " + param.Message
+	}
+	stash, err := writeCommit(commitObject{
+		Tree:      worktreeTree,
+		Parents:   parents,
+		Author:    sig,
+		Committer: sig,
+		Message:   message + "\n",
+	})
+	if err != nil {
+		return "", err
+	}
+	previous, err := readRef(stashRef)
+	if err != nil {
+		return "", err
+	}
+	if err := updateRef(stashRef, stash, previous); err != nil {
+		return "", err
+	}
+	if err := appendReflog(stashRef, reflogEntry{Old: previous, New: stash, Committer: sig, Message: message}); err != nil {
+		return "", err
+	}
+
+	// reset the index and the working tree
+	target, err := flattenTree(headCommit.Tree)
+	if param.KeepIndex {
+		target, err = flattenTree(indexTree)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := checkoutTree(lock, filter, nil, target, true); err != nil {
+		return "", err
+	}
+	for _, index := range untracked {
+		if err := removeWorktreeFile(index.Path); err != nil {
+			return "", err
+		}
+	}
+	return "Saved working directory and index state " + message, nil
+}
+
+// parseStash return the position of the stash entry named "stash@{<n>}" or
+// "<n>", the latest entry when the name is empty.
+func parseStash(name string) (int, error) {
+	if name == "" {
+		return 0, nil
+	}
+	s := strings.TrimSuffix(strings.TrimPrefix(name, "stash@{"), "}")
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || s != name && "stash@{"+s+"}" != name {
+		return 0, fmt.Errorf("'%s' is not a stash reference", name)
+	}
+	return n, nil
+}
+
+// readStash return the reflog of the stash entries and the position in it of
+// the named entry.
+func readStash(name string) ([]reflogEntry, int, error) {
+	n, err := parseStash(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries, err := readReflog(stashRef)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(entries) == 0 {
+		return nil, 0, errors.New("no stash entries found")
+	}
+	if n >= len(entries) {
+		return nil, 0, fmt.Errorf("stash@{%d} does not exist, only %d entries", n, len(entries))
+	}
+	return entries, len(entries) - 1 - n, nil
+}
+
+// stashTrees return the files of the base commit, the index, the working
+// tree and the untracked files of the stash entry.
+func stashTrees(sha1 string) (base, index, worktree, untracked Indexes, err error) {
+	stash, err := readCommit(sha1)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if len(stash.Parents) < 2 {
+		return nil, nil, nil, nil, fmt.Errorf("%s is not a stash-like commit", sha1)
+	}
+	trees := make([]Indexes, len(stash.Parents))
+	for i, parent := range stash.Parents {
+		commit, err := readCommit(parent)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		if trees[i], err = flattenTree(commit.Tree); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+	if worktree, err = flattenTree(stash.Tree); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if len(trees) > 2 {
+		untracked = trees[2]
+	}
+	return trees[0], trees[1], worktree, untracked, nil
+}
+
+// StashList list the stash entries, the latest first.
+func StashList() ([]string, error) {
+	entries, err := readReflog(stashRef)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]string, len(entries))
+	for i := range entries {
+		lines[i] = fmt.Sprintf("stash@{%d}: %s", i, entries[len(entries)-1-i].Message)
+	}
+	return lines, nil
+}
+
+// StashShow list the files changed by the stash entry from its base commit
+// as "<status>\t<path>" lines, the status being A, M or D.
+func StashShow(name string) ([]string, error) {
+	entries, i, err := readStash(name)
+	if err != nil {
+		return nil, err
+	}
+	base, _, worktree, _, err := stashTrees(entries[i].New)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, p := range unionPaths(base, worktree) {
+		b, w := findEntry(base, p), findEntry(worktree, p)
+		switch {
+		case sameEntry(b, w):
+		case b == nil:
+			lines = append(lines, "A\t"+p)
+		case w == nil:
+			lines = append(lines, "D\t"+p)
+		default:
+			lines = append(lines, "M\t"+p)
+		}
+	}
+	return lines, nil
+}
+
+// StashApplyParam stash apply and pop command params.
+type StashApplyParam struct {
+	// Stash names the entry, the latest when empty.
+	Stash string
+	// Index also restores the changes added to the index.
+	Index bool
+}
+
+// StashApply apply the changes of the stash entry onto the working tree,
+// merging them with the changes made since. Files added by the stash are
+// added to the index, other changes are left unstaged unless Index is set.
+// The conflicts are returned and recorded in the index.
+func StashApply(param StashApplyParam) ([]string, error) {
+	entries, i, err := readStash(param.Stash)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := lockIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.abort()
+	indexes, err := ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	if len(indexes.Unmerged()) > 0 {
+		return nil, errors.New("cannot apply a stash in the middle of a merge")
+	}
+	base, stashedIndex, worktree, untracked, err := stashTrees(entries[i].New)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range untracked {
+		if _, err := os.Lstat(filepath.FromSlash(index.Path)); err == nil {
+			return nil, fmt.Errorf("%s already exists, no checkout\ncould not restore untracked files from stash", index.Path)
+		}
+	}
+
+	// the index changes must apply without conflict
+	result := append(Indexes(nil), indexes...)
+	if param.Index {
+		for _, p := range unionPaths(base, stashedIndex) {
+			b, s, current := findEntry(base, p), findEntry(stashedIndex, p), findEntry(indexes, p)
+			if sameEntry(b, s) || sameEntry(current, s) {
+				continue
+			}
+			if !sameEntry(current, b) {
+				return nil, errors.New("conflicts in index. Try without --index")
+			}
+			if s == nil {
+				result = result.Remove(p)
+			} else {
+				result = result.Set(*s)
+			}
+		}
+	}
+
+	rules, err := standardIgnoreRules()
+	if err != nil {
+		return nil, err
+	}
+	filter, err := newConvertFilter()
+	if err != nil {
+		return nil, err
+	}
+	indexTime := indexModTime()
+	type update struct {
+		path string
+		// entry is written to the working tree, the file is removed when nil
+		entry *Index
+		// data replaces the content of entry, merged or with conflict markers
+		data []byte
+		// stages are recorded in the index for a conflict
+		stages [4]*Index
+	}
+	var updates []update
+	var dirty, conflicts []string
+	for _, p := range unionPaths(base, worktree, indexes) {
+		b, o, t := findEntry(base, p), findEntry(indexes, p), findEntry(worktree, p)
+		if sameEntry(o, t) || sameEntry(b, t) {
+			continue
+		}
+		// the working tree file must not hold changes which would be lost
+		if o != nil {
+			change, err := o.worktreeChange(filter, indexTime)
+			if err != nil {
+				return nil, err
+			}
+			if change == worktreeModified {
+				dirty = append(dirty, p)
+				continue
+			}
+		} else if t != nil {
+			if _, err := os.Lstat(filepath.FromSlash(p)); err == nil {
+				ignored, err := rules.ignored(p, false)
+				if err != nil {
+					return nil, err
+				}
+				if !ignored {
+					dirty = append(dirty, p)
+					continue
+				}
+			}
+		}
+		if sameEntry(b, o) {
+			updates = append(updates, update{path: p, entry: t})
+			continue
+		}
+		if o == nil || t == nil {
+			// modified on one side and deleted on the other
+			kept := o
+			if o == nil {
+				kept = t
+				conflicts = append(conflicts, fmt.Sprintf("CONFLICT (modify/delete): %s deleted in %s and modified in %s.", p, stashOursLabel, stashTheirsLabel))
+			} else {
+				conflicts = append(conflicts, fmt.Sprintf("CONFLICT (modify/delete): %s deleted in %s and modified in %s.", p, stashTheirsLabel, stashOursLabel))
+			}
+			updates = append(updates, update{path: p, entry: kept, stages: [4]*Index{nil, b, o, t}})
+			continue
+		}
+		// submodule commits are not objects of the repository nor merged,
+		// ours is kept
+		if o.Mode == ModeGitlink || t.Mode == ModeGitlink || b != nil && b.Mode == ModeGitlink {
+			conflicts = append(conflicts, fmt.Sprintf("CONFLICT (submodule): Merge conflict in %s", p))
+			updates = append(updates, update{path: p, entry: o, stages: [4]*Index{nil, b, o, t}})
+			continue
+		}
+		var baseData []byte
+		if b != nil {
+			obj, err := ReadObject(b.Sha1)
+			if err != nil {
+				return nil, err
+			}
+			baseData = obj.Data
+		}
+		ours, err := ReadObject(o.Sha1)
+		if err != nil {
+			return nil, err
+		}
+		theirs, err := ReadObject(t.Sha1)
+		if err != nil {
+			return nil, err
+		}
+		merged, conflict := mergeFile(baseData, ours.Data, theirs.Data, stashOursLabel, stashTheirsLabel)
+		u := update{path: p, entry: t, data: merged}
+		// link targets are not merged, ours is kept
+		if o.Mode == ModeSymlink || t.Mode == ModeSymlink {
+			u = update{path: p, entry: o, data: ours.Data}
+			conflict = true
+		}
+		if conflict {
+			kind := "content"
+			if b == nil {
+				kind = "add/add"
+			}
+			conflicts = append(conflicts, fmt.Sprintf("CONFLICT (%s): Merge conflict in %s", kind, p))
+			u.stages = [4]*Index{nil, b, o, t}
+		}
+		updates = append(updates, u)
+	}
+	if len(dirty) > 0 {
+		return nil, fmt.Errorf("your local changes to the following files would be overwritten by merge:\n\t%s\nPlease commit your changes or stash them before you merge.",
+			strings.Join(dirty, "\n\t"))
+	}
+
+	for _, u := range updates {
+		if u.entry == nil {
+			if err := removeWorktreeFile(u.path); err != nil {
+				return nil, err
+			}
+		} else if u.data == nil {
+			written, err := checkoutIndex(filter, *u.entry)
+			if err != nil {
+				return nil, err
+			}
+			// the index keeps its version unless the stash adds the file
+			if current := findEntry(result, u.path); current == nil && u.stages[StageOurs] == nil {
+				result = result.Set(written)
+			} else if sameEntry(current, u.entry) {
+				result = result.Set(written)
+			}
+		} else {
+			if err := writeWorktreeFile(filter, u.path, u.data, u.entry.Mode); err != nil {
+				return nil, err
+			}
+		}
+		for stage, entry := range u.stages {
+			if entry != nil {
+				conflicted := Index{Mode: entry.Mode, Sha1: entry.Sha1, Path: u.path}
+				conflicted.SetStage(stage)
+				result = result.Set(conflicted)
+			}
+		}
+	}
+	for _, index := range untracked {
+		if _, err := checkoutIndex(filter, index); err != nil {
+			return nil, err
+		}
+	}
+	return conflicts, writeIndex(lock, result.Sort())
+}
+
+// StashDrop remove the stash entry and return a message naming it.
+func StashDrop(name string) (string, error) {
+	entries, i, err := readStash(name)
+	if err != nil {
+		return "", err
+	}
+	dropped, latest := entries[i], entries[len(entries)-1].New
+	entries = append(entries[:i], entries[i+1:]...)
+	if err := writeReflog(stashRef, entries); err != nil {
+		return "", err
+	}
+	// the ref points to the latest entry left, dropping the last entry
+	// deletes it
+	top := ""
+	if len(entries) > 0 {
+		top = entries[len(entries)-1].New
+	}
+	if top != latest {
+		if err := updateRef(stashRef, top, latest); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("Dropped stash@{%d} (%s)", len(entries)-i, dropped.New), nil
+}
+
+// StashPop apply the stash entry and drop it unless there are conflicts,
+// return the conflicts and a message about the entry.
+func StashPop(param StashApplyParam) ([]string, string, error) {
+	conflicts, err := StashApply(param)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(conflicts) > 0 {
+		return conflicts, "The stash entry is kept in case you need it again.", nil
+	}
+	message, err := StashDrop(param.Stash)
+	return nil, message, err
+}
+
+// findEntry return the stage 0 entry, or the first stage, of the path.
+func findEntry(indexes Indexes, p string) *Index {
+	if i, ok := indexes.Find(p); ok {
+		return &indexes[i]
+	}
+	return nil
+}
+
+// unionPaths return the sorted paths of all the entries.
+func unionPaths(lists ...Indexes) []string {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, list := range lists {
+		for _, index := range list {
+			if !seen[index.Path] {
+				seen[index.Path] = true
+				paths = append(paths, index.Path)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
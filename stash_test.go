@@ -0,0 +1,241 @@
+package tinygit
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStash(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	commitIndex(t, "first")
+
+	shortStatus := func() []string {
+		t.Helper()
+		report, err := Status(StatusParam{})
+		if err != nil {
+			t.Fatalf("status: %+v", err)
+		}
+		return report.Short(false)
+	}
+
+	if message, err := StashPush(StashPushParam{}); err != nil || message != "No local changes to save" {
+		t.Fatalf("expected nothing to save, but got %q, %+v", message, err)
+	}
+
+	writeFiles(t, map[string]string{"a.txt": "a2\n", "c.txt": "c\n", "u.txt": "u\n"})
+	if err := Add(AddParam{Paths: []string{"c.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if err := os.Remove("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	message, err := StashPush(StashPushParam{Message: "work"})
+	if err != nil {
+		t.Fatalf("stash push: %+v", err)
+	}
+	if message != "Saved working directory and index state On master: work" {
+		t.Fatalf("unexpected message %q", message)
+	}
+	// the untracked file is left alone without -u
+	if want, got := []string{"?? u.txt"}, shortStatus(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+	assertFiles(t, map[string]string{"a.txt": "a\n", "b.txt": "b\n", "u.txt": "u\n"})
+
+	list, err := StashList()
+	if err != nil {
+		t.Fatalf("stash list: %+v", err)
+	}
+	if want := []string{"stash@{0}: On master: work"}; !reflect.DeepEqual(list, want) {
+		t.Fatalf("expected %q, but got %q", want, list)
+	}
+	show, err := StashShow("")
+	if err != nil {
+		t.Fatalf("stash show: %+v", err)
+	}
+	if want := []string{"M\ta.txt", "D\tb.txt", "A\tc.txt"}; !reflect.DeepEqual(show, want) {
+		t.Fatalf("expected %q, but got %q", want, show)
+	}
+
+	// pop restores the changes, only the new file stays added
+	conflicts, message, err := StashPop(StashApplyParam{})
+	if err != nil || len(conflicts) > 0 {
+		t.Fatalf("stash pop: %q, %+v", conflicts, err)
+	}
+	if !strings.HasPrefix(message, "Dropped stash@{0} (") {
+		t.Fatalf("unexpected message %q", message)
+	}
+	if want, got := []string{" M a.txt", " D b.txt", "A  c.txt", "?? u.txt"}, shortStatus(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+	if list, _ := StashList(); len(list) != 0 {
+		t.Fatalf("expected no stash entries, but got %q", list)
+	}
+	if _, err := os.Stat(".tinygit/refs/stash"); !os.IsNotExist(err) {
+		t.Fatalf("expected refs/stash to be removed, but got %v", err)
+	}
+}
+
+func TestStashIndexAndUntracked(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a\n"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	commitIndex(t, "first")
+
+	writeFiles(t, map[string]string{"a.txt": "staged\n", "u.txt": "u\n"})
+	if err := Add(AddParam{Paths: []string{"a.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	writeFiles(t, map[string]string{"a.txt": "local\n"})
+	if _, err := StashPush(StashPushParam{IncludeUntracked: true}); err != nil {
+		t.Fatalf("stash push: %+v", err)
+	}
+	if _, err := os.Stat("u.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expected u.txt to be stashed, but got %v", err)
+	}
+	list, _ := StashList()
+	if len(list) != 1 || !strings.HasPrefix(list[0], "stash@{0}: WIP on master: ") || !strings.HasSuffix(list[0], " first") {
+		t.Fatalf("unexpected stash list %q", list)
+	}
+
+	// an untracked file in the way stops the apply
+	writeFiles(t, map[string]string{"u.txt": "other\n"})
+	if _, err := StashApply(StashApplyParam{Index: true}); err == nil || !strings.Contains(err.Error(), "u.txt already exists, no checkout") {
+		t.Fatalf("expected an untracked file error, but got %v", err)
+	}
+	if err := os.Remove("u.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StashApply(StashApplyParam{Stash: "stash@{0}", Index: true}); err != nil {
+		t.Fatalf("stash apply: %+v", err)
+	}
+	assertFiles(t, map[string]string{"a.txt": "local\n", "u.txt": "u\n"})
+	indexes, _ := ReadIndex()
+	i, _ := indexes.Find("a.txt")
+	if obj, err := ReadObject(indexes[i].Sha1); err != nil || string(obj.Data) != "staged\n" {
+		t.Fatalf("expected the staged content to be restored, but got %+v", indexes[i])
+	}
+	// apply keeps the entry
+	if list, _ := StashList(); len(list) != 1 {
+		t.Fatalf("expected the stash entry to be kept, but got %q", list)
+	}
+	if _, err := StashDrop("1"); err == nil {
+		t.Fatal("expected dropping a missing entry to fail")
+	}
+	if _, err := StashDrop("0"); err != nil {
+		t.Fatalf("stash drop: %+v", err)
+	}
+}
+
+func TestStashConflict(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "1\n2\n3\n"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	commitIndex(t, "first")
+
+	writeFiles(t, map[string]string{"a.txt": "1\nstashed\n3\n"})
+	if _, err := StashPush(StashPushParam{}); err != nil {
+		t.Fatalf("stash push: %+v", err)
+	}
+	writeFiles(t, map[string]string{"a.txt": "1\nupstream\n3\n"})
+	if _, err := StashApply(StashApplyParam{}); err == nil || !strings.Contains(err.Error(), "would be overwritten by merge") {
+		t.Fatalf("expected local changes to be protected, but got %v", err)
+	}
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	commitIndex(t, "second")
+
+	conflicts, message, err := StashPop(StashApplyParam{})
+	if err != nil {
+		t.Fatalf("stash pop: %+v", err)
+	}
+	if want := []string{"CONFLICT (content): Merge conflict in a.txt"}; !reflect.DeepEqual(conflicts, want) {
+		t.Fatalf("expected %q, but got %q", want, conflicts)
+	}
+	if message != "The stash entry is kept in case you need it again." {
+		t.Fatalf("unexpected message %q", message)
+	}
+	assertFiles(t, map[string]string{
+		"a.txt": "1\n<<<<<<< Updated upstream\nupstream\n=======\nstashed\n>>>>>>> Stashed changes\n3\n",
+	})
+	indexes, _ := ReadIndex()
+	if unmerged := indexes.Unmerged(); !reflect.DeepEqual(unmerged, []string{"a.txt"}) {
+		t.Fatalf("expected a.txt to be unmerged, but got %q", unmerged)
+	}
+	if list, _ := StashList(); len(list) != 1 {
+		t.Fatalf("expected the stash entry to be kept, but got %q", list)
+	}
+}
+
+func TestStashSubmodule(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	commitIndex(t, "super")
+	lib := filepath.Join(t.TempDir(), "lib")
+	if err := Initail(lib); err != nil {
+		t.Fatalf("init repo: %+v", err)
+	}
+	// commitIn commit a new content of lib.txt in the working tree
+	commitIn := func(worktree, content string) string {
+		var sha1 string
+		if err := inWorktree(worktree, func() error {
+			writeFiles(t, map[string]string{"lib.txt": content})
+			if err := Add(AddParam{All: true}); err != nil {
+				t.Fatalf("add: %+v", err)
+			}
+			sha1 = commitIndex(t, content)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return sha1
+	}
+	first := commitIn(lib, "v1")
+	if err := SubmoduleAdd(SubmoduleAddParam{URL: lib}); err != nil {
+		t.Fatalf("submodule add: %+v", err)
+	}
+	commitIndex(t, "add lib")
+
+	// the submodule checks out another commit
+	second := commitIn("lib", "v2")
+	if _, err := StashPush(StashPushParam{}); err != nil {
+		t.Fatalf("stash push: %+v", err)
+	}
+	if lines, err := StashShow(""); err != nil || !reflect.DeepEqual(lines, []string{"M\tlib"}) {
+		t.Fatalf("expected lib stashed, but got %q, %v", lines, err)
+	}
+
+	// the superproject records a third commit meanwhile
+	third := commitIn("lib", "v3")
+	if err := Add(AddParam{Paths: []string{"lib"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	commitIndex(t, "lib v3")
+	conflicts, err := StashApply(StashApplyParam{})
+	if err != nil {
+		t.Fatalf("stash apply: %+v", err)
+	}
+	if want := []string{"CONFLICT (submodule): Merge conflict in lib"}; !reflect.DeepEqual(conflicts, want) {
+		t.Fatalf("expected %q, but got %q", want, conflicts)
+	}
+	indexes, _ := ReadIndex()
+	stages := indexes.Stages("lib")
+	if stages[StageBase].Sha1 != first || stages[StageOurs].Sha1 != third || stages[StageTheirs].Sha1 != second {
+		t.Fatalf("unexpected stages %v", stages)
+	}
+}
@@ -0,0 +1,441 @@
+package tinygit
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/startdusk/tinygit/shared/filestat"
+	"github.com/startdusk/tinygit/shared/refs"
+)
+
+// StatusParam status command params.
+type StatusParam struct {
+	Paths []string
+	// Untracked is how untracked files are shown: "no", "normal", the default
+	// which shows untracked directories as a whole, or "all".
+	Untracked string
+}
+
+// StatusEntry describes a path which differs between HEAD, the index and the
+// working tree.
+type StatusEntry struct {
+	Path string
+	// Staged and Unstaged are the status letters of the short format for the
+	// index compared to HEAD and the working tree compared to the index.
+	Staged   byte
+	Unstaged byte
+
+	HeadMode     uint16
+	IndexMode    uint16
+	WorktreeMode uint16
+	HeadSha1     string
+	IndexSha1    string
+	// Stages holds the conflict entries of an unmerged path.
+	Stages [4]*Index
+}
+
+// Unmerged report whether the entry is a conflicting path.
+func (e StatusEntry) Unmerged() bool {
+	return e.Stages[StageBase] != nil || e.Stages[StageOurs] != nil || e.Stages[StageTheirs] != nil
+}
+
+// Untracked report whether the entry is an untracked file or directory.
+func (e StatusEntry) Untracked() bool {
+	return e.Staged == '?'
+}
+
+// StatusReport is the state of the working tree.
+type StatusReport struct {
+	// Branch is the current branch, empty when HEAD is detached.
+	Branch string
+	// Head is the HEAD commit, empty when there are no commits yet.
+	Head string
+	// Entries are sorted by path, untracked entries come last.
+	Entries []StatusEntry
+}
+
+// Status compare HEAD, the index and the working tree.
+func Status(param StatusParam) (StatusReport, error) {
+	var report StatusReport
+	switch param.Untracked {
+	case "":
+		param.Untracked = "normal"
+	case "no", "normal", "all":
+	default:
+		return report, fmt.Errorf("invalid untracked files mode '%s'", param.Untracked)
+	}
+	specs := make([]string, 0, len(param.Paths))
+	for _, p := range param.Paths {
+		rel, err := repoRelPath(p)
+		if err != nil {
+			return report, err
+		}
+		specs = append(specs, rel)
+	}
+	branch, head, err := readHead()
+	if err != nil {
+		return report, err
+	}
+	report.Branch, report.Head = branch, head
+	headIndexes, err := headTree()
+	if err != nil {
+		return report, err
+	}
+	// the index is locked from reading to writing back the refreshed
+	// entries, the write is skipped when the lock can not be taken, as when
+	// another command holds it
+	lock, err := lockIndex()
+	if err == nil {
+		defer lock.abort()
+	}
+	indexes, err := ReadIndex()
+	if err != nil {
+		return report, err
+	}
+	filter, err := newConvertFilter()
+	if err != nil {
+		return report, err
+	}
+	indexTime := indexModTime()
+	config := filter.config
+	// with a file system monitor the files found unchanged are recorded so
+	// they are not checked again until the monitor reports a change
+	monitored, refreshed := fsmonitorEnabled(config), false
+
+	var entries []StatusEntry
+	for _, p := range indexes.Unmerged() {
+		if !matchAnyPathspec(specs, p) {
+			continue
+		}
+		entry := StatusEntry{Path: p, Stages: indexes.Stages(p)}
+		entry.Staged, entry.Unstaged = unmergedStatus(entry.Stages)
+		entry.WorktreeMode = worktreeMode(p)
+		entries = append(entries, entry)
+	}
+
+	for n, index := range indexes {
+		if index.Stage() != StageMerged || !matchAnyPathspec(specs, index.Path) {
+			continue
+		}
+		entry := StatusEntry{
+			Path:      index.Path,
+			Staged:    ' ',
+			Unstaged:  ' ',
+			IndexMode: treeMode(index.Mode),
+			IndexSha1: index.Sha1,
+		}
+		if i, ok := headIndexes.Find(index.Path); ok {
+			entry.HeadMode, entry.HeadSha1 = headIndexes[i].Mode, headIndexes[i].Sha1
+		}
+		switch {
+		case index.IntentToAdd():
+			entry.Unstaged = 'A'
+		case entry.HeadSha1 == "":
+			entry.Staged = 'A'
+		case entry.HeadSha1 != index.Sha1 || entry.HeadMode != entry.IndexMode:
+			entry.Staged = 'M'
+		}
+		change, err := index.worktreeChange(filter, indexTime)
+		if err != nil {
+			return report, err
+		}
+		switch {
+		case index.IntentToAdd():
+		case change == worktreeDeleted:
+			entry.Unstaged = 'D'
+		case change == worktreeModified:
+			entry.Unstaged = 'M'
+		// the monitor does not see the commits of submodules
+		case monitored && !index.FSMonitorValid() && !index.AssumeUnchanged() && !index.SkipWorktree() &&
+			index.Mode != ModeGitlink:
+			indexes[n].SetFSMonitorValid(true)
+			refreshed = true
+		}
+		switch {
+		case index.FSMonitorValid() || index.Mode == ModeGitlink && entry.Unstaged != 'D':
+			entry.WorktreeMode = index.Mode
+		case entry.Unstaged != 'D':
+			entry.WorktreeMode = worktreeMode(index.Path)
+		}
+		if entry.Staged != ' ' || entry.Unstaged != ' ' {
+			entries = append(entries, entry)
+		}
+	}
+
+	for _, headIndex := range headIndexes {
+		if _, ok := indexes.Find(headIndex.Path); ok || !matchAnyPathspec(specs, headIndex.Path) {
+			continue
+		}
+		entries = append(entries, StatusEntry{
+			Path:     headIndex.Path,
+			Staged:   'D',
+			Unstaged: ' ',
+			HeadMode: headIndex.Mode,
+			HeadSha1: headIndex.Sha1,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	if refreshed && lock != nil {
+		if err := writeIndex(lock, indexes); err != nil {
+			return report, err
+		}
+	}
+
+	if param.Untracked != "no" {
+		untracked, err := untrackedPaths(indexes, param.Untracked == "all", specs)
+		if err != nil {
+			return report, err
+		}
+		for _, p := range untracked {
+			entries = append(entries, StatusEntry{Path: p, Staged: '?', Unstaged: '?'})
+		}
+	}
+	report.Entries = entries
+	return report, nil
+}
+
+// unmergedStatus return the short format letters of a conflict from the
+// stages present.
+func unmergedStatus(stages [4]*Index) (byte, byte) {
+	base, ours, theirs := stages[StageBase] != nil, stages[StageOurs] != nil, stages[StageTheirs] != nil
+	switch {
+	case base && !ours && !theirs:
+		return 'D', 'D'
+	case !base && ours && !theirs:
+		return 'A', 'U'
+	case base && ours && !theirs:
+		return 'U', 'D'
+	case !base && !ours && theirs:
+		return 'U', 'A'
+	case base && !ours && theirs:
+		return 'D', 'U'
+	case !base && ours && theirs:
+		return 'A', 'A'
+	}
+	return 'U', 'U'
+}
+
+func worktreeMode(p string) uint16 {
+	st, err := filestat.Stat(filepath.FromSlash(p))
+	if err != nil {
+		return 0
+	}
+	return st.Mode
+}
+
+// untrackedPaths return the sorted untracked files matching the pathspecs
+// which are not ignored.
+// Unless all is set, a directory without any tracked file is returned once
+// with a trailing slash.
+func untrackedPaths(indexes Indexes, all bool, specs []string) ([]string, error) {
+	trackedDirs := make(map[string]bool)
+	for _, index := range indexes {
+		for dir := path.Dir(index.Path); dir != "."; dir = path.Dir(dir) {
+			trackedDirs[dir] = true
+		}
+	}
+	rules, err := standardIgnoreRules()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	seen := make(map[string]bool)
+	err = walkWorktree(rules, func(p string, info fs.FileInfo) error {
+		if _, tracked := indexes.Find(p); tracked || !matchAnyPathspec(specs, p) {
+			return nil
+		}
+		// another repository is untracked as a whole
+		if info.IsDir() {
+			p += "/"
+		}
+		if !all {
+			parts := strings.Split(p, "/")
+			for i := 1; i < len(parts); i++ {
+				if dir := strings.Join(parts[:i], "/"); !trackedDirs[dir] {
+					p = dir + "/"
+					break
+				}
+			}
+		}
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+var unmergedLabels = map[string]string{
+	"DD": "both deleted:",
+	"AU": "added by us:",
+	"UD": "deleted by them:",
+	"UA": "added by them:",
+	"DU": "deleted by us:",
+	"AA": "both added:",
+	"UU": "both modified:",
+}
+
+var changeLabels = map[byte]string{
+	'A': "new file:",
+	'M': "modified:",
+	'D': "deleted:",
+}
+
+// Long format the report like the default output of git status.
+func (r StatusReport) Long() string {
+	var b strings.Builder
+	if r.Branch != "" {
+		fmt.Fprintf(&b, "On branch %s\n", r.Branch)
+	} else {
+		fmt.Fprintf(&b, "HEAD detached at %s\n", r.Head[:7])
+	}
+	if r.Head == "" {
+		b.WriteString("\nNo commits yet\n")
+	}
+
+	var staged, unmerged, unstaged, untracked []StatusEntry
+	deletions := false
+	for _, e := range r.Entries {
+		switch {
+		case e.Untracked():
+			untracked = append(untracked, e)
+		case e.Unmerged():
+			unmerged = append(unmerged, e)
+		default:
+			if e.Staged != ' ' {
+				staged = append(staged, e)
+			}
+			if e.Unstaged != ' ' {
+				unstaged = append(unstaged, e)
+				deletions = deletions || e.Unstaged == 'D'
+			}
+		}
+	}
+
+	if len(staged) > 0 {
+		b.WriteString("\nChanges to be committed:\n")
+		if r.Head == "" {
+			b.WriteString("  (use \"tinygit rm --cached <file>...\" to unstage)\n")
+		} else {
+			b.WriteString("  (use \"tinygit restore --staged <file>...\" to unstage)\n")
+		}
+		for _, e := range staged {
+			fmt.Fprintf(&b, "\t%-12s%s\n", changeLabels[e.Staged], e.Path)
+		}
+	}
+	if len(unmerged) > 0 {
+		b.WriteString("\nUnmerged paths:\n")
+		b.WriteString("  (use \"tinygit add <file>...\" to mark resolution)\n")
+		for _, e := range unmerged {
+			fmt.Fprintf(&b, "\t%-17s%s\n", unmergedLabels[string([]byte{e.Staged, e.Unstaged})], e.Path)
+		}
+	}
+	if len(unstaged) > 0 {
+		b.WriteString("\nChanges not staged for commit:\n")
+		if deletions {
+			b.WriteString("  (use \"tinygit add/rm <file>...\" to update what will be committed)\n")
+		} else {
+			b.WriteString("  (use \"tinygit add <file>...\" to update what will be committed)\n")
+		}
+		b.WriteString("  (use \"tinygit restore <file>...\" to discard changes in working directory)\n")
+		for _, e := range unstaged {
+			fmt.Fprintf(&b, "\t%-12s%s\n", changeLabels[e.Unstaged], e.Path)
+		}
+	}
+	if len(untracked) > 0 {
+		b.WriteString("\nUntracked files:\n")
+		b.WriteString("  (use \"tinygit add <file>...\" to include in what will be committed)\n")
+		for _, e := range untracked {
+			fmt.Fprintf(&b, "\t%s\n", e.Path)
+		}
+	}
+
+	b.WriteString("\n")
+	switch {
+	case len(staged) > 0 || len(unmerged) > 0:
+	case len(unstaged) > 0:
+		b.WriteString("no changes added to commit (use \"tinygit add\" and/or \"tinygit commit -a\")\n")
+	case len(untracked) > 0:
+		b.WriteString("nothing added to commit but untracked files present (use \"tinygit add\" to track)\n")
+	case r.Head == "":
+		b.WriteString("nothing to commit (create/copy files and use \"tinygit add\" to track)\n")
+	default:
+		b.WriteString("nothing to commit, working tree clean\n")
+	}
+	return b.String()
+}
+
+// Short format the report as "XY path" lines, the format of --short and
+// --porcelain=v1. With branch the first line describes the branch.
+func (r StatusReport) Short(branch bool) []string {
+	var lines []string
+	if branch {
+		switch {
+		case r.Branch == "":
+			lines = append(lines, "## HEAD (no branch)")
+		case r.Head == "":
+			lines = append(lines, "## No commits yet on "+r.Branch)
+		default:
+			lines = append(lines, "## "+r.Branch)
+		}
+	}
+	for _, e := range r.Entries {
+		lines = append(lines, fmt.Sprintf("%c%c %s", e.Staged, e.Unstaged, e.Path))
+	}
+	return lines
+}
+
+// PorcelainV2 format the report in the --porcelain=v2 format.
+func (r StatusReport) PorcelainV2(branch bool) []string {
+	orZero := func(sha1 string) string {
+		if sha1 == "" {
+			return refs.ZeroSha1
+		}
+		return sha1
+	}
+	dot := func(c byte) byte {
+		if c == ' ' {
+			return '.'
+		}
+		return c
+	}
+	var lines []string
+	if branch {
+		oid, head := r.Head, r.Branch
+		if oid == "" {
+			oid = "(initial)"
+		}
+		if head == "" {
+			head = "(detached)"
+		}
+		lines = append(lines, "# branch.oid "+oid, "# branch.head "+head)
+	}
+	for _, e := range r.Entries {
+		switch {
+		case e.Untracked():
+			lines = append(lines, "? "+e.Path)
+		case e.Unmerged():
+			var modes [4]uint16
+			var sha1s [4]string
+			for stage := StageBase; stage <= StageTheirs; stage++ {
+				if index := e.Stages[stage]; index != nil {
+					modes[stage], sha1s[stage] = treeMode(index.Mode), index.Sha1
+				}
+			}
+			lines = append(lines, fmt.Sprintf("u %c%c N... %06o %06o %06o %06o %s %s %s %s",
+				e.Staged, e.Unstaged, modes[1], modes[2], modes[3], e.WorktreeMode,
+				orZero(sha1s[1]), orZero(sha1s[2]), orZero(sha1s[3]), e.Path))
+		default:
+			lines = append(lines, fmt.Sprintf("1 %c%c N... %06o %06o %06o %s %s %s",
+				dot(e.Staged), dot(e.Unstaged), e.HeadMode, e.IndexMode, e.WorktreeMode,
+				orZero(e.HeadSha1), orZero(e.IndexSha1), e.Path))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,222 @@
+package tinygit
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{
+		"keep.txt":     "keep",
+		"modify.txt":   "modify",
+		"delete.txt":   "delete",
+		"unstage.txt":  "unstage",
+		"dir/file.txt": "file",
+	})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	head := commitIndex(t, "initial")
+
+	writeFiles(t, map[string]string{
+		"modify.txt":       "modified",
+		"new.txt":          "new",
+		"unstage.txt":      "unstaged",
+		"untracked/a.txt":  "a",
+		"untracked/b.txt":  "b",
+		"dir/untracked.md": "md",
+	})
+	if err := Add(AddParam{Paths: []string{"modify.txt", "new.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if err := os.Remove("delete.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Add(AddParam{Paths: []string{"delete.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	writeFiles(t, map[string]string{"new.txt": "new changed"})
+	if err := os.Remove("keep.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Status(StatusParam{})
+	if err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	if report.Branch != "master" || report.Head != head {
+		t.Fatalf("expected master at %s, but got %s at %s", head, report.Branch, report.Head)
+	}
+	wantShort := []string{
+		"## master",
+		"D  delete.txt",
+		" D keep.txt",
+		"M  modify.txt",
+		"AM new.txt",
+		" M unstage.txt",
+		"?? dir/untracked.md",
+		"?? untracked/",
+	}
+	if got := report.Short(true); !reflect.DeepEqual(got, wantShort) {
+		t.Fatalf("expected short %q, but got %q", wantShort, got)
+	}
+
+	wantLong := `On branch master
+
+Changes to be committed:
+  (use "tinygit restore --staged <file>..." to unstage)
+	deleted:    delete.txt
+	modified:   modify.txt
+	new file:   new.txt
+
+Changes not staged for commit:
+  (use "tinygit add/rm <file>..." to update what will be committed)
+  (use "tinygit restore <file>..." to discard changes in working directory)
+	deleted:    keep.txt
+	modified:   new.txt
+	modified:   unstage.txt
+
+Untracked files:
+  (use "tinygit add <file>..." to include in what will be committed)
+	dir/untracked.md
+	untracked/
+
+`
+	if got := report.Long(); got != wantLong {
+		t.Fatalf("expected long:\n%s\nbut got:\n%s", wantLong, got)
+	}
+
+	all, err := Status(StatusParam{Untracked: "all", Paths: []string{"untracked"}})
+	if err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	want := []string{"?? untracked/a.txt", "?? untracked/b.txt"}
+	if got := all.Short(false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+
+	v2, err := Status(StatusParam{Untracked: "no", Paths: []string{"new.txt", "delete.txt"}})
+	if err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	deleteSha1, _, _ := HashObject(HashParam{Data: []byte("delete"), ObjType: Blob})
+	newSha1, _, _ := HashObject(HashParam{Data: []byte("new"), ObjType: Blob})
+	zero := strings.Repeat("0", 40)
+	want = []string{
+		"# branch.oid " + head,
+		"# branch.head master",
+		"1 D. N... 100644 000000 000000 " + deleteSha1 + " " + zero + " delete.txt",
+		"1 AM N... 000000 100644 100644 " + zero + " " + newSha1 + " new.txt",
+	}
+	if got := v2.PorcelainV2(true); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+}
+
+func TestStatusNoCommits(t *testing.T) {
+	setupRepo(t)
+	report, err := Status(StatusParam{})
+	if err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	want := "On branch master\n\nNo commits yet\n\nnothing to commit (create/copy files and use \"tinygit add\" to track)\n"
+	if got := report.Long(); got != want {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+	if got := report.Short(true); !reflect.DeepEqual(got, []string{"## No commits yet on master"}) {
+		t.Fatalf("unexpected short %q", got)
+	}
+
+	writeFiles(t, map[string]string{"a.txt": "a", "b.txt": "b", "gen.txt": "gen"})
+	if err := Add(AddParam{Paths: []string{"a.txt", "gen.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if err := Add(AddParam{Paths: []string{"b.txt"}, IntentToAdd: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	if err := UpdateIndex(UpdateIndexParam{Paths: []string{"gen.txt"}, AssumeUnchanged: true}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	writeFiles(t, map[string]string{"gen.txt": "regenerated"})
+	report, err = Status(StatusParam{})
+	if err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	wantShort := []string{"A  a.txt", " A b.txt", "A  gen.txt"}
+	if got := report.Short(false); !reflect.DeepEqual(got, wantShort) {
+		t.Fatalf("expected %q, but got %q", wantShort, got)
+	}
+}
+
+func TestStatusIndexLock(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a"})
+	if err := Add(AddParam{Paths: []string{"a.txt"}}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	// the monitor makes status refresh the entries it checked
+	setConfig(t, map[string]string{"core.fsmonitor": "true"})
+	lock := filepath.Join(RepoRootPath, "index.lock")
+	writeFiles(t, map[string]string{lock: "held"})
+	if err := WriteIndex(nil); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("expected the held lock to be refused, but got %v", err)
+	}
+	before, err := os.ReadFile(filepath.Join(RepoRootPath, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// status does not write the index while another command holds the lock
+	if _, err := Status(StatusParam{}); err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	assertFiles(t, map[string]string{lock: "held"})
+	if after, _ := os.ReadFile(filepath.Join(RepoRootPath, "index")); !reflect.DeepEqual(after, before) {
+		t.Fatal("expected the index to be kept")
+	}
+	if err := os.Remove(lock); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Status(StatusParam{}); err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	if _, err := os.Stat(lock); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the lock to be released, but got %v", err)
+	}
+}
+
+func TestStatusUnmerged(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "<<<<<<<", "b.txt": "b"})
+	sha1, _, _ := HashObject(HashParam{Data: []byte("b"), ObjType: Blob, WriteFile: true})
+	input := "100644 " + sha1 + " 1\ta.txt\n" +
+		"100644 " + sha1 + " 2\ta.txt\n" +
+		"100644 " + sha1 + " 3\ta.txt\n" +
+		"100644 " + sha1 + " 1\tb.txt\n" +
+		"100644 " + sha1 + " 2\tb.txt\n"
+	if err := UpdateIndex(UpdateIndexParam{IndexInfo: strings.NewReader(input)}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	report, err := Status(StatusParam{})
+	if err != nil {
+		t.Fatalf("status: %+v", err)
+	}
+	want := []string{"UU a.txt", "UD b.txt"}
+	if got := report.Short(false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+	if long := report.Long(); !strings.Contains(long, "\tboth modified:   a.txt\n") ||
+		!strings.Contains(long, "\tdeleted by them: b.txt\n") {
+		t.Fatalf("unexpected long format:\n%s", long)
+	}
+	zero := strings.Repeat("0", 40)
+	wantV2 := "u UD N... 100644 100644 000000 100644 " + sha1 + " " + sha1 + " " + zero + " b.txt"
+	if got := report.PorcelainV2(false); got[1] != wantV2 {
+		t.Fatalf("expected %q, but got %q", wantV2, got[1])
+	}
+}
@@ -0,0 +1,582 @@
+package tinygit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/startdusk/tinygit/shared/refs"
+)
+
+// GitmodulesFileName is the file of the superproject describing its
+// submodules, in the Git config file format with a [submodule "<name>"]
+// section holding the path and url of each.
+const GitmodulesFileName = ".gitmodules"
+
+// The repository of a submodule lives in .tinygit/modules/<name> of the
+// superproject, its working tree has a .tinygit file naming it like a linked
+// working tree.
+const modulesFolder = "modules"
+
+// isNestedRepo report whether the directory is the working tree of another
+// repository.
+func isNestedRepo(dir string) bool {
+	if filepath.Clean(dir) == "." {
+		return false
+	}
+	_, err := os.Lstat(filepath.Join(dir, RepoRootPath))
+	return err == nil
+}
+
+// submoduleHead return the commit checked out in the repository of the
+// directory, empty when its branch has no commits yet.
+func submoduleHead(dir string) (string, error) {
+	repo := gitDirOf(dir)
+	_, head, err := readHeadOf(refs.Store{GitDir: repo, CommonDir: commonDirOf(repo)})
+	if err != nil {
+		return "", fmt.Errorf("submodule %s: %w", filepath.ToSlash(dir), err)
+	}
+	return head, nil
+}
+
+// gitlinkIndex return the gitlink entry of the repository at the repo
+// relative path, recording its HEAD commit.
+func gitlinkIndex(p string) (Index, error) {
+	head, err := submoduleHead(filepath.FromSlash(p))
+	if err != nil {
+		return Index{}, err
+	}
+	if head == "" {
+		return Index{}, fmt.Errorf("'%s' does not have a commit checked out", p)
+	}
+	return Index{Mode: ModeGitlink, Sha1: head, Path: p}, nil
+}
+
+// Submodule is a submodule described by the .gitmodules file.
+type Submodule struct {
+	Name string
+	Path string
+	URL  string
+}
+
+// readGitmodules return the .gitmodules file of the working tree and its
+// submodules, an absent file has none.
+func readGitmodules() (*Config, []Submodule, error) {
+	data, err := os.ReadFile(GitmodulesFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Config{}, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("read %s: %w", GitmodulesFileName, err)
+	}
+	config, err := ParseConfig(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", GitmodulesFileName, err)
+	}
+	var submodules []Submodule
+	for _, name := range config.Subsections("submodule") {
+		p, ok := config.Get("submodule." + name + ".path")
+		if !ok {
+			continue
+		}
+		if err := checkSubmoduleName(name); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", GitmodulesFileName, err)
+		}
+		if err := checkSubmodulePath(p); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", GitmodulesFileName, err)
+		}
+		url, _ := config.Get("submodule." + name + ".url")
+		submodules = append(submodules, Submodule{Name: name, Path: path.Clean(p), URL: url})
+	}
+	return config, submodules, nil
+}
+
+// splitSubmodulePath split the name or path on slashes and backslashes,
+// which are separators on some systems.
+func splitSubmodulePath(p string) []string {
+	return strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' })
+}
+
+// checkSubmoduleName refuse the names which would not be a folder under
+// .tinygit/modules as Git does: empty, absolute, or holding a ".." or
+// repository folder component.
+func checkSubmoduleName(name string) error {
+	if name == "" || strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") || filepath.IsAbs(name) {
+		return fmt.Errorf("invalid submodule name '%s'", name)
+	}
+	for _, part := range splitSubmodulePath(name) {
+		if part == ".." || strings.EqualFold(part, RepoRootPath) {
+			return fmt.Errorf("invalid submodule name '%s'", name)
+		}
+	}
+	return nil
+}
+
+// checkSubmodulePath refuse the paths which are not inside the working tree,
+// or are inside a repository folder.
+func checkSubmodulePath(p string) error {
+	clean := path.Clean(filepath.ToSlash(p))
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") || strings.HasPrefix(p, "/") ||
+		strings.HasPrefix(p, "\\") || filepath.IsAbs(p) {
+		return fmt.Errorf("submodule path '%s' is outside the working tree", p)
+	}
+	for _, part := range splitSubmodulePath(p) {
+		if part == ".." || strings.EqualFold(part, RepoRootPath) {
+			return fmt.Errorf("invalid submodule path '%s'", p)
+		}
+	}
+	return nil
+}
+
+// selectSubmodules return the submodules matching the paths, every one when
+// no path is given.
+func selectSubmodules(submodules []Submodule, paths []string) ([]Submodule, error) {
+	if len(paths) == 0 {
+		return submodules, nil
+	}
+	var selected []Submodule
+	for _, p := range paths {
+		spec, err := repoRelPath(p)
+		if err != nil {
+			return nil, err
+		}
+		matched := false
+		for _, s := range submodules {
+			if matchPathspec(spec, s.Path) {
+				selected = append(selected, s)
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("pathspec '%s' did not match any submodule", p)
+		}
+	}
+	return selected, nil
+}
+
+// localRepository return the repository folder of the url. Only local
+// repositories can be cloned, a relative url is relative to the superproject.
+func localRepository(url string) (string, error) {
+	if strings.Contains(url, "://") || strings.HasPrefix(url, "git@") {
+		return "", fmt.Errorf("cannot clone '%s': only local repositories are supported", url)
+	}
+	p := filepath.FromSlash(url)
+	if !filepath.IsAbs(p) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		p = filepath.Join(wd, p)
+	}
+	// the url names either a working tree or its repository folder
+	for _, dir := range []string{gitDirOf(p), p} {
+		if _, err := os.Stat(filepath.Join(dir, "HEAD")); err == nil {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("repository '%s' does not exist", url)
+}
+
+// copyFiles copy the files below the src folder into the dst folder, files
+// already there are kept.
+func copyFiles(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == src {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+		if _, err := os.Lstat(target); err == nil {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+}
+
+// cloneRepository copy the objects, the branches and tags and HEAD of the
+// src repository folder into the new dir repository folder.
+func cloneRepository(src, dir string) error {
+	common := commonDirOf(src)
+	for _, folder := range []string{ObjectsFolder, filepath.Join("refs", "heads"), filepath.Join("refs", "tags")} {
+		if err := os.MkdirAll(filepath.Join(dir, folder), os.ModePerm); err != nil {
+			return err
+		}
+		if err := copyFiles(filepath.Join(common, folder), filepath.Join(dir, folder)); err != nil {
+			return err
+		}
+	}
+	head, err := os.ReadFile(filepath.Join(src, "HEAD"))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "HEAD"), head, 0644)
+}
+
+// fetchObjects copy the objects of the src repository folder missing from
+// the dir repository folder.
+func fetchObjects(src, dir string) error {
+	return copyFiles(filepath.Join(commonDirOf(src), ObjectsFolder), filepath.Join(dir, ObjectsFolder))
+}
+
+// connectSubmodule make the directory of the repo relative path the working
+// tree of the repository folder.
+func connectSubmodule(dir, rel string) error {
+	worktree := filepath.FromSlash(rel)
+	if err := os.MkdirAll(worktree, os.ModePerm); err != nil {
+		return err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	absWorktree, err := filepath.Abs(worktree)
+	if err != nil {
+		return err
+	}
+	// the relative folder keeps working when the superproject moves
+	link, err := filepath.Rel(absWorktree, absDir)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(worktree, RepoRootPath), []byte(gitdirPrefix+filepath.ToSlash(link)+"\n"), 0644)
+}
+
+// checkoutSubmodule check the commit out in the submodule at the repo
+// relative path. A submodule without index is populated from scratch, keeping
+// its HEAD unless detach is set, otherwise HEAD is detached at the commit.
+func checkoutSubmodule(rel, sha1 string, detach, force bool) error {
+	return inWorktree(filepath.FromSlash(rel), func() error {
+		if _, err := os.Stat(indexFile()); errors.Is(err, fs.ErrNotExist) {
+			commit, err := readCommit(sha1)
+			if err != nil {
+				return err
+			}
+			target, err := flattenTree(commit.Tree)
+			if err != nil {
+				return err
+			}
+			lock, err := lockIndex()
+			if err != nil {
+				return err
+			}
+			defer lock.abort()
+			filter, err := newConvertFilter()
+			if err != nil {
+				return err
+			}
+			if err := checkoutTree(lock, filter, nil, target, force); err != nil {
+				return err
+			}
+			if detach {
+				return writeHead("", sha1)
+			}
+			return nil
+		}
+		_, err := Checkout(CheckoutParam{Target: sha1, Detach: true, Force: force})
+		return err
+	})
+}
+
+// SubmoduleAddParam submodule add command params.
+type SubmoduleAddParam struct {
+	// URL is the path of the local repository to clone, relative to the
+	// superproject when it is not absolute.
+	URL string
+	// Path is where the submodule is checked out, by default the last
+	// element of URL.
+	Path string
+}
+
+// SubmoduleAdd clone the repository as a submodule, record it in the
+// .gitmodules file and the config and stage both with its gitlink entry.
+func SubmoduleAdd(param SubmoduleAddParam) (err error) {
+	if param.URL == "" {
+		return errors.New("no repository specified")
+	}
+	src, err := localRepository(param.URL)
+	if err != nil {
+		return err
+	}
+	p := param.Path
+	if p == "" {
+		p = path.Base(strings.TrimSuffix(strings.TrimRight(filepath.ToSlash(param.URL), "/"), "/"+RepoRootPath))
+	}
+	rel, err := repoRelPath(p)
+	if err != nil {
+		return err
+	}
+	indexes, err := ReadIndex()
+	if err != nil {
+		return err
+	}
+	if _, ok := indexes.Find(rel); ok {
+		return fmt.Errorf("'%s' already exists in the index", rel)
+	}
+	if entries, err := os.ReadDir(filepath.FromSlash(rel)); err == nil && len(entries) > 0 {
+		return fmt.Errorf("'%s' already exists and is not empty", rel)
+	}
+	name := rel
+	if err := checkSubmoduleName(name); err != nil {
+		return err
+	}
+	if err := checkSubmodulePath(rel); err != nil {
+		return err
+	}
+	dir := filepath.Join(commonDir(), modulesFolder, filepath.FromSlash(name))
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("a repository for '%s' already exists in '%s'", name, dir)
+	}
+
+	defer func() {
+		// a failed add leaves nothing behind
+		if err != nil {
+			os.RemoveAll(dir)
+			os.RemoveAll(filepath.FromSlash(rel))
+		}
+	}()
+	if err := cloneRepository(src, dir); err != nil {
+		return err
+	}
+	if err := connectSubmodule(dir, rel); err != nil {
+		return err
+	}
+	head, err := submoduleHead(filepath.FromSlash(rel))
+	if err != nil {
+		return err
+	}
+	if head == "" {
+		return fmt.Errorf("'%s' does not have a commit checked out", rel)
+	}
+	if err := checkoutSubmodule(rel, head, false, false); err != nil {
+		return err
+	}
+
+	gitmodules, _, err := readGitmodules()
+	if err != nil {
+		return err
+	}
+	config, err := ReadConfig()
+	if err != nil {
+		return err
+	}
+	for _, c := range []*Config{gitmodules, config} {
+		if err := c.Set("submodule."+name+".url", param.URL); err != nil {
+			return err
+		}
+	}
+	if err := gitmodules.Set("submodule."+name+".path", rel); err != nil {
+		return err
+	}
+	if err := os.WriteFile(GitmodulesFileName, gitmodules.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := WriteConfig(config); err != nil {
+		return err
+	}
+	return Add(AddParam{Paths: []string{GitmodulesFileName, rel}})
+}
+
+// SubmoduleInit copy the urls of the submodules of the paths, or of every
+// submodule, from the .gitmodules file to the config, and return a message
+// for each newly registered one.
+func SubmoduleInit(paths []string) ([]string, error) {
+	_, submodules, err := readGitmodules()
+	if err != nil {
+		return nil, err
+	}
+	if submodules, err = selectSubmodules(submodules, paths); err != nil {
+		return nil, err
+	}
+	config, err := ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+	var messages []string
+	for _, s := range submodules {
+		key := "submodule." + s.Name + ".url"
+		if _, ok := config.Get(key); ok {
+			continue
+		}
+		if s.URL == "" {
+			return nil, fmt.Errorf("no url found for submodule path '%s' in %s", s.Path, GitmodulesFileName)
+		}
+		if err := config.Set(key, s.URL); err != nil {
+			return nil, err
+		}
+		messages = append(messages, fmt.Sprintf("Submodule '%s' (%s) registered for path '%s'", s.Name, s.URL, s.Path))
+	}
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	return messages, WriteConfig(config)
+}
+
+// SubmoduleUpdateParam submodule update command params.
+type SubmoduleUpdateParam struct {
+	Paths []string
+	// Init initializes the submodules first.
+	Init bool
+	// Force discards the local modifications of the submodules.
+	Force bool
+}
+
+// SubmoduleUpdate clone the initialized submodules which are not checked
+// out yet and detach their HEAD at the commit recorded in the index, and
+// return a message for each submodule checked out.
+func SubmoduleUpdate(param SubmoduleUpdateParam) ([]string, error) {
+	var messages []string
+	if param.Init {
+		registered, err := SubmoduleInit(param.Paths)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, registered...)
+	}
+	_, submodules, err := readGitmodules()
+	if err != nil {
+		return nil, err
+	}
+	if submodules, err = selectSubmodules(submodules, param.Paths); err != nil {
+		return nil, err
+	}
+	config, err := ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range submodules {
+		i, ok := indexes.Find(s.Path)
+		if !ok || indexes[i].Mode != ModeGitlink || indexes[i].Stage() != StageMerged {
+			continue
+		}
+		// submodules which are not initialized are skipped
+		url, ok := config.Get("submodule." + s.Name + ".url")
+		if !ok {
+			continue
+		}
+		sha1 := indexes[i].Sha1
+		worktree := filepath.FromSlash(s.Path)
+		if isNestedRepo(worktree) {
+			head, err := submoduleHead(worktree)
+			if err != nil {
+				return nil, err
+			}
+			if head == sha1 {
+				continue
+			}
+		} else {
+			dir := filepath.Join(commonDir(), modulesFolder, filepath.FromSlash(s.Name))
+			if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+				src, err := localRepository(url)
+				if err != nil {
+					return nil, err
+				}
+				if err := cloneRepository(src, dir); err != nil {
+					return nil, err
+				}
+			}
+			if err := connectSubmodule(dir, s.Path); err != nil {
+				return nil, err
+			}
+		}
+		// the recorded commit may be newer than the submodule repository
+		objects := filepath.Join(commonDirOf(gitDirOf(worktree)), ObjectsFolder)
+		if _, err := os.Stat(filepath.Join(objects, sha1[:2], sha1[2:])); err != nil {
+			src, err := localRepository(url)
+			if err != nil {
+				return nil, err
+			}
+			if err := fetchObjects(src, commonDirOf(gitDirOf(worktree))); err != nil {
+				return nil, err
+			}
+		}
+		if err := checkoutSubmodule(s.Path, sha1, true, param.Force); err != nil {
+			return nil, fmt.Errorf("unable to checkout '%s' in submodule path '%s': %w", sha1, s.Path, err)
+		}
+		messages = append(messages, fmt.Sprintf("Submodule path '%s': checked out '%s'", s.Path, sha1))
+	}
+	return messages, nil
+}
+
+// SubmoduleStatus is the state of a submodule of the index.
+type SubmoduleStatus struct {
+	Path string
+	// Sha1 is the commit checked out in the submodule, the recorded one when
+	// it is not checked out.
+	Sha1 string
+	// Flag is '-' for a submodule which is not checked out, '+' when another
+	// commit than the recorded one is checked out, 'U' for a conflict and ' '
+	// otherwise.
+	Flag byte
+}
+
+// String format the status as "<flag><sha1> <path>".
+func (s SubmoduleStatus) String() string {
+	return fmt.Sprintf("%c%s %s", s.Flag, s.Sha1, s.Path)
+}
+
+// SubmoduleStatuses return the status of the gitlink entries of the index
+// matching the paths.
+func SubmoduleStatuses(paths []string) ([]SubmoduleStatus, error) {
+	specs := make([]string, 0, len(paths))
+	for _, p := range paths {
+		rel, err := repoRelPath(p)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, rel)
+	}
+	indexes, err := ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	var statuses []SubmoduleStatus
+	for n, index := range indexes {
+		if index.Mode != ModeGitlink || !matchAnyPathspec(specs, index.Path) {
+			continue
+		}
+		if index.Stage() != StageMerged {
+			// a conflict is reported once whatever its stages
+			if n == 0 || indexes[n-1].Path != index.Path || indexes[n-1].Mode != ModeGitlink {
+				statuses = append(statuses, SubmoduleStatus{Path: index.Path, Sha1: refs.ZeroSha1, Flag: 'U'})
+			}
+			continue
+		}
+		status := SubmoduleStatus{Path: index.Path, Sha1: index.Sha1, Flag: '-'}
+		if worktree := filepath.FromSlash(index.Path); isNestedRepo(worktree) {
+			head, err := submoduleHead(worktree)
+			if err != nil {
+				return nil, err
+			}
+			status.Flag = ' '
+			if head != index.Sha1 {
+				status.Flag = '+'
+				if head != "" {
+					status.Sha1 = head
+				}
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
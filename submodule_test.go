@@ -0,0 +1,141 @@
+package tinygit
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSubmodule(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	commitIndex(t, "super")
+
+	lib := filepath.Join(t.TempDir(), "lib")
+	if err := Initail(lib); err != nil {
+		t.Fatalf("init repo: %+v", err)
+	}
+	var first string
+	err := inWorktree(lib, func() error {
+		writeFiles(t, map[string]string{"lib.txt": "v1"})
+		if err := Add(AddParam{All: true}); err != nil {
+			t.Fatalf("add: %+v", err)
+		}
+		first = commitIndex(t, "v1")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SubmoduleAdd(SubmoduleAddParam{URL: lib}); err != nil {
+		t.Fatalf("submodule add: %+v", err)
+	}
+	assertFiles(t, map[string]string{
+		"lib/lib.txt":      "v1",
+		GitmodulesFileName: "[submodule \"lib\"]\n\turl = " + lib + "\n\tpath = lib\n",
+	})
+	want := []string{"160000 " + first + " 0\tlib"}
+	if got := mustLsFiles(t, LsFilesParam{Stage: true, Paths: []string{"lib"}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+	if got := blobOf(t, GitmodulesFileName); !strings.Contains(got, "path = lib") {
+		t.Fatalf("expected .gitmodules staged, but got %q", got)
+	}
+	if err := SubmoduleAdd(SubmoduleAddParam{URL: lib}); err == nil || !strings.Contains(err.Error(), "already exists in the index") {
+		t.Fatalf("expected a second add to fail, but got %v", err)
+	}
+	commitIndex(t, "add lib")
+	report, err := Status(StatusParam{})
+	if err != nil || len(report.Entries) != 0 {
+		t.Fatalf("expected a clean working tree, but got %+v, %v", report.Entries, err)
+	}
+
+	// a new commit in the submodule is a modification of the superproject
+	var second string
+	err = inWorktree("lib", func() error {
+		writeFiles(t, map[string]string{"lib.txt": "v2"})
+		if err := Add(AddParam{All: true}); err != nil {
+			t.Fatalf("add: %+v", err)
+		}
+		second = commitIndex(t, "v2")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report, err := Status(StatusParam{}); err != nil || !reflect.DeepEqual(report.Short(false), []string{" M lib"}) {
+		t.Fatalf("expected lib modified, but got %q, %v", report.Short(false), err)
+	}
+	statuses, err := SubmoduleStatuses(nil)
+	if err != nil {
+		t.Fatalf("submodule status: %+v", err)
+	}
+	if len(statuses) != 1 || statuses[0].String() != "+"+second+" lib" {
+		t.Fatalf("expected lib at %s, but got %v", second, statuses)
+	}
+
+	// update checks the recorded commit out again, cloning when needed
+	if err := os.RemoveAll("lib"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(commonDir(), modulesFolder)); err != nil {
+		t.Fatal(err)
+	}
+	if statuses, _ := SubmoduleStatuses(nil); len(statuses) != 1 || statuses[0].String() != "-"+first+" lib" {
+		t.Fatalf("expected lib not checked out, but got %v", statuses)
+	}
+	config, _ := ReadConfig()
+	config.Unset("submodule.lib.url")
+	if err := WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if messages, err := SubmoduleUpdate(SubmoduleUpdateParam{}); err != nil || len(messages) != 0 {
+		t.Fatalf("expected an uninitialized submodule skipped, but got %q, %v", messages, err)
+	}
+	messages, err := SubmoduleUpdate(SubmoduleUpdateParam{Init: true})
+	if err != nil {
+		t.Fatalf("submodule update: %+v", err)
+	}
+	want = []string{
+		"Submodule 'lib' (" + lib + ") registered for path 'lib'",
+		"Submodule path 'lib': checked out '" + first + "'",
+	}
+	if !reflect.DeepEqual(messages, want) {
+		t.Fatalf("expected %q, but got %q", want, messages)
+	}
+	assertFiles(t, map[string]string{"lib/lib.txt": "v1"})
+	if statuses, _ := SubmoduleStatuses(nil); len(statuses) != 1 || statuses[0].String() != " "+first+" lib" {
+		t.Fatalf("expected lib at %s, but got %v", first, statuses)
+	}
+	if report, err := Status(StatusParam{}); err != nil || len(report.Entries) != 0 {
+		t.Fatalf("expected a clean working tree, but got %+v, %v", report.Entries, err)
+	}
+}
+
+func TestSubmoduleSuspiciousGitmodules(t *testing.T) {
+	setupRepo(t)
+	for _, c := range []struct{ name, path, err string }{
+		{"../../evil", "lib", "invalid submodule name '../../evil'"},
+		{`..\evil`, "lib", `invalid submodule name '..\evil'`},
+		{"/tmp/evil", "lib", "invalid submodule name '/tmp/evil'"},
+		{"lib/.tinygit/hooks", "lib", "invalid submodule name 'lib/.tinygit/hooks'"},
+		{"lib", "../out", "submodule path '../out' is outside the working tree"},
+		{"lib", "a/../../out", "submodule path 'a/../../out' is outside the working tree"},
+		{"lib", "/tmp/out", "submodule path '/tmp/out' is outside the working tree"},
+		{"lib", ".tinygit/lib", "invalid submodule path '.tinygit/lib'"},
+	} {
+		writeFiles(t, map[string]string{
+			GitmodulesFileName: "[submodule \"" + strings.ReplaceAll(c.name, `\`, `\\`) + "\"]\n\turl = ../lib\n\tpath = " + c.path + "\n",
+		})
+		_, err := SubmoduleUpdate(SubmoduleUpdateParam{Init: true})
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s %s: expected %q, but got %v", c.name, c.path, c.err, err)
+		}
+	}
+}
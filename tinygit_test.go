@@ -2,6 +2,7 @@ package tinygit
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -14,3 +15,87 @@ func TestMain(m *testing.M) {
 	}()
 	m.Run()
 }
+
+// setupRepo initialize a repository in a temporary directory and change into
+// it for the duration of the test.
+func setupRepo(t *testing.T) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := Initail(dir); err != nil {
+		t.Fatalf("init repo: %+v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+	return dir
+}
+
+// writeFiles write the given path to content pairs into the working tree.
+func writeFiles(t *testing.T, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		path = filepath.FromSlash(path)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// assertFiles check the content of the given working tree files.
+func assertFiles(t *testing.T, files map[string]string) {
+	t.Helper()
+	for path, want := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		if string(data) != want {
+			t.Fatalf("expected %s to be %q, but got %q", path, want, data)
+		}
+	}
+}
+
+// commitIndex commit the current index on the branch HEAD points to and
+// return the commit sha1.
+func commitIndex(t *testing.T, message string) string {
+	t.Helper()
+	indexes, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	tree, err := writeTree(indexes)
+	if err != nil {
+		t.Fatalf("write tree: %+v", err)
+	}
+	_, head, err := readHead()
+	if err != nil {
+		t.Fatalf("read head: %+v", err)
+	}
+	commit := commitObject{
+		Tree:      tree,
+		Author:    "tester <tester@example.com> 1670330587 +0000",
+		Committer: "tester <tester@example.com> 1670330587 +0000",
+		Message:   message + "\n",
+	}
+	if head != "" {
+		commit.Parents = []string{head}
+	}
+	sha1, err := writeCommit(commit)
+	if err != nil {
+		t.Fatalf("write commit: %+v", err)
+	}
+	if err := updateRef("HEAD", sha1, head); err != nil {
+		t.Fatal(err)
+	}
+	return sha1
+}
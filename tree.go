@@ -0,0 +1,166 @@
+package tinygit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ModeTree is the mode of a sub tree entry.
+const ModeTree uint16 = 040000
+
+// TreeEntry represents an entry of a tree object.
+type TreeEntry struct {
+	Mode uint16
+	Name string
+	Sha1 string
+}
+
+// parseTree parse the data of a tree object, made of entries in the format
+// "<mode> SP <name> NUL <20 bytes sha1>".
+func parseTree(data []byte) ([]TreeEntry, error) {
+	var entries []TreeEntry
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		if space < 0 {
+			return nil, errors.New("invalid tree entry mode")
+		}
+		mode, err := parseMode(string(data[:space]))
+		if err != nil {
+			return nil, err
+		}
+		data = data[space+1:]
+		nul := bytes.IndexByte(data, '\x00')
+		if nul < 0 || len(data) < nul+1+20 {
+			return nil, errors.New("invalid tree entry")
+		}
+		entries = append(entries, TreeEntry{
+			Mode: mode,
+			Name: string(data[:nul]),
+			Sha1: hex.EncodeToString(data[nul+1 : nul+1+20]),
+		})
+		data = data[nul+1+20:]
+	}
+	return entries, nil
+}
+
+// ReadTree read the entries of the tree object with given SHA-1 prefix.
+func ReadTree(sha1Prefix string) ([]TreeEntry, error) {
+	obj, err := ReadObject(sha1Prefix)
+	if err != nil {
+		return nil, err
+	}
+	if obj.Type != Tree {
+		return nil, fmt.Errorf("object %s is a %s, not a tree", sha1Prefix, obj.Type)
+	}
+	return parseTree(obj.Data)
+}
+
+// flattenTree read the tree recursively and return its files as sorted index
+// entries without stat information.
+func flattenTree(sha1 string) (Indexes, error) {
+	var indexes Indexes
+	var walk func(sha1, prefix string) error
+	walk = func(sha1, prefix string) error {
+		entries, err := ReadTree(sha1)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			p := path.Join(prefix, entry.Name)
+			if entry.Mode == ModeTree {
+				if err := walk(entry.Sha1, p); err != nil {
+					return err
+				}
+				continue
+			}
+			indexes = append(indexes, Index{Mode: entry.Mode, Sha1: entry.Sha1, Path: p})
+		}
+		return nil
+	}
+	if err := walk(sha1, ""); err != nil {
+		return nil, err
+	}
+	return indexes.Sort(), nil
+}
+
+// writeTree write the tree objects of the stage 0 index entries and return
+// the sha1 of the root tree. Intent-to-add entries are left out.
+func writeTree(indexes Indexes) (string, error) {
+	if unmerged := indexes.Unmerged(); len(unmerged) > 0 {
+		return "", fmt.Errorf("%s: unmerged entry", unmerged[0])
+	}
+	type dir struct {
+		entries []TreeEntry
+		subdirs map[string]*dir
+	}
+	root := &dir{subdirs: make(map[string]*dir)}
+	for _, index := range indexes {
+		if index.IntentToAdd() {
+			continue
+		}
+		current := root
+		parts := strings.Split(index.Path, "/")
+		for _, name := range parts[:len(parts)-1] {
+			sub, ok := current.subdirs[name]
+			if !ok {
+				sub = &dir{subdirs: make(map[string]*dir)}
+				current.subdirs[name] = sub
+			}
+			current = sub
+		}
+		current.entries = append(current.entries, TreeEntry{
+			Mode: treeMode(index.Mode),
+			Name: parts[len(parts)-1],
+			Sha1: index.Sha1,
+		})
+	}
+
+	var write func(d *dir) (string, error)
+	write = func(d *dir) (string, error) {
+		entries := d.entries
+		for name, sub := range d.subdirs {
+			sha1, err := write(sub)
+			if err != nil {
+				return "", err
+			}
+			entries = append(entries, TreeEntry{Mode: ModeTree, Name: name, Sha1: sha1})
+		}
+		// Git sorts sub trees as if their name ends with a slash
+		sortName := func(e TreeEntry) string {
+			if e.Mode == ModeTree {
+				return e.Name + "/"
+			}
+			return e.Name
+		}
+		sort.Slice(entries, func(i, j int) bool { return sortName(entries[i]) < sortName(entries[j]) })
+		var data []byte
+		for _, entry := range entries {
+			raw, err := hex.DecodeString(entry.Sha1)
+			if err != nil {
+				return "", fmt.Errorf("invalid sha1 %s for %s: %w", entry.Sha1, entry.Name, err)
+			}
+			data = append(data, fmt.Sprintf("%o %s\x00", entry.Mode, entry.Name)...)
+			data = append(data, raw...)
+		}
+		sha1, _, err := HashObject(HashParam{Data: data, ObjType: Tree, WriteFile: true})
+		return sha1, err
+	}
+	return write(root)
+}
+
+// treeMode return the mode a file is recorded with in a tree, Git only keeps
+// the executable bit of regular files.
+func treeMode(mode uint16) uint16 {
+	if mode&0170000 != 0100000 {
+		return mode
+	}
+	if mode&0111 != 0 {
+		return ModeExecutable
+	}
+	return ModeRegular
+}
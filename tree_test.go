@@ -0,0 +1,109 @@
+package tinygit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTreeLifecycle(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{
+		"a.txt":       "a",
+		"a-b.txt":     "a-b",
+		"a/b.txt":     "b",
+		"a/c/d.txt":   "d",
+		"z/empty.txt": "",
+	})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	indexes, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	tree, err := writeTree(indexes)
+	if err != nil {
+		t.Fatalf("write tree: %+v", err)
+	}
+
+	entries, err := ReadTree(tree)
+	if err != nil {
+		t.Fatalf("read tree: %+v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	// "a" sorts as "a/", after "a-b.txt" and "a.txt"
+	if want := []string{"a-b.txt", "a.txt", "a", "z"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected entries %v, but got %v", want, names)
+	}
+	if entries[2].Mode != ModeTree {
+		t.Fatalf("expected tree mode, but got %o", entries[2].Mode)
+	}
+
+	flattened, err := flattenTree(tree)
+	if err != nil {
+		t.Fatalf("flatten tree: %+v", err)
+	}
+	if len(flattened) != len(indexes) {
+		t.Fatalf("expected %d files, but got %d", len(indexes), len(flattened))
+	}
+	for i, index := range indexes {
+		if flattened[i].Path != index.Path || flattened[i].Sha1 != index.Sha1 || flattened[i].Mode != ModeRegular {
+			t.Fatalf("expected %+v, but got %+v", index, flattened[i])
+		}
+	}
+
+	// the "z" sub tree is the same as a tree holding only its file
+	empty := Indexes{{Mode: ModeRegular, Sha1: emptyBlobSha1, Path: "empty.txt"}}
+	sha1, err := writeTree(empty)
+	if err != nil {
+		t.Fatalf("write tree: %+v", err)
+	}
+	if sha1 != entries[3].Sha1 {
+		t.Fatalf("expected sub tree %s, but got %s", entries[3].Sha1, sha1)
+	}
+
+	conflicted := Indexes{stagedIndex("a.txt", emptyBlobSha1, StageOurs)}
+	if _, err := writeTree(conflicted); err == nil {
+		t.Fatal("expected error writing an unmerged index")
+	}
+}
+
+func TestCommitLifecycle(t *testing.T) {
+	setupRepo(t)
+	commit := commitObject{
+		Tree:      "4b825dc642cb6eb9a060e54bf8d69288fbee4904",
+		Parents:   []string{"0123456789012345678901234567890123456789"},
+		Author:    "tester <tester@example.com> 1670330587 +0000",
+		Committer: "tester <tester@example.com> 1670330587 +0000",
+		Message:   "subject\n\nbody\n",
+	}
+	sha1, err := writeCommit(commit)
+	if err != nil {
+		t.Fatalf("write commit: %+v", err)
+	}
+	readed, err := readCommit(sha1)
+	if err != nil {
+		t.Fatalf("read commit: %+v", err)
+	}
+	if !reflect.DeepEqual(readed, commit) {
+		t.Fatalf("expected commit %+v, but got %+v", commit, readed)
+	}
+	if _, err := readCommit(emptyTreeOf(t)); err == nil {
+		t.Fatal("expected error reading a tree as a commit")
+	}
+}
+
+func emptyTreeOf(t *testing.T) string {
+	t.Helper()
+	sha1, err := writeTree(nil)
+	if err != nil {
+		t.Fatalf("write tree: %+v", err)
+	}
+	if sha1 != "4b825dc642cb6eb9a060e54bf8d69288fbee4904" {
+		t.Fatalf("expected git's empty tree, but got %s", sha1)
+	}
+	return sha1
+}
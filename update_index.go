@@ -0,0 +1,318 @@
+package tinygit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/startdusk/tinygit/shared/filestat"
+	"github.com/startdusk/tinygit/shared/refs"
+)
+
+// CacheInfo describes an index entry inserted directly by update-index.
+type CacheInfo struct {
+	Mode  uint16
+	Sha1  string
+	Stage int
+	Path  string
+}
+
+// ParseCacheInfo parse the "<mode>,<sha1>,<path>" form of --cacheinfo.
+func ParseCacheInfo(arg string) (CacheInfo, error) {
+	fields := strings.SplitN(arg, ",", 3)
+	if len(fields) != 3 {
+		return CacheInfo{}, fmt.Errorf("invalid cacheinfo '%s'", arg)
+	}
+	mode, err := parseMode(fields[0])
+	if err != nil {
+		return CacheInfo{}, err
+	}
+	if !refs.IsSha1(fields[1]) {
+		return CacheInfo{}, fmt.Errorf("invalid sha1 '%s'", fields[1])
+	}
+	return CacheInfo{Mode: mode, Sha1: fields[1], Path: fields[2]}, nil
+}
+
+// UpdateIndexParam update-index command params.
+type UpdateIndexParam struct {
+	Paths []string
+	// Add allows paths not yet in the index to be added.
+	Add bool
+	// Remove drops the entries of paths missing from the working tree.
+	Remove bool
+	// ForceRemove drops the entries of paths even if they still exist.
+	ForceRemove bool
+	// Chmod is "+x" or "-x" to set or clear the executable bit of the paths.
+	Chmod string
+	// CacheInfo entries are inserted without looking at the working tree.
+	CacheInfo []CacheInfo
+	// IndexInfo, when set, is read for "<mode> SP <sha1> TAB <path>" lines.
+	IndexInfo io.Reader
+	// Refresh updates the stat information of entries whose content is unchanged.
+	Refresh bool
+	// AssumeUnchanged and NoAssumeUnchanged set or clear the assume-unchanged
+	// bit of the paths without updating their content.
+	AssumeUnchanged   bool
+	NoAssumeUnchanged bool
+	// SkipWorktree and NoSkipWorktree set or clear the skip-worktree bit of
+	// the paths without updating their content.
+	SkipWorktree   bool
+	NoSkipWorktree bool
+	// SplitIndex and NoSplitIndex turn the split index mode on or off.
+	SplitIndex   bool
+	NoSplitIndex bool
+}
+
+// UpdateIndex register file contents in the working tree to the index.
+func UpdateIndex(param UpdateIndexParam) error {
+	if param.Chmod != "" && param.Chmod != "+x" && param.Chmod != "-x" {
+		return fmt.Errorf("option 'chmod' expects \"+x\" or \"-x\"")
+	}
+	lock, err := lockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.abort()
+	indexes, err := ReadIndex()
+	if err != nil {
+		return err
+	}
+	if param.SplitIndex || param.NoSplitIndex {
+		config, err := ReadConfig()
+		if err != nil {
+			return err
+		}
+		if err := config.Set("core.splitIndex", strconv.FormatBool(param.SplitIndex)); err != nil {
+			return err
+		}
+		if err := WriteConfig(config); err != nil {
+			return err
+		}
+	}
+
+	filter, err := newConvertFilter()
+	if err != nil {
+		return err
+	}
+	var refreshErr error
+	if param.Refresh {
+		indexes, refreshErr = refreshIndex(filter, indexes)
+	}
+
+	for _, info := range param.CacheInfo {
+		indexes, err = updateCacheInfo(indexes, info, param.Add)
+		if err != nil {
+			return err
+		}
+	}
+
+	if param.IndexInfo != nil {
+		scanner := bufio.NewScanner(param.IndexInfo)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				continue
+			}
+			info, err := parseIndexInfo(line)
+			if err != nil {
+				return err
+			}
+			if info.Mode == 0 {
+				indexes = indexes.Remove(info.Path)
+				continue
+			}
+			index := Index{Mode: info.Mode, Sha1: info.Sha1, Path: info.Path}
+			index.SetStage(info.Stage)
+			indexes = indexes.Set(index)
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("read index info: %w", err)
+		}
+	}
+
+	for _, path := range param.Paths {
+		rel, err := repoRelPath(path)
+		if err != nil {
+			return err
+		}
+		indexes, err = updateIndexPath(filter, indexes, rel, param)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := writeIndex(lock, indexes); err != nil {
+		return err
+	}
+	return refreshErr
+}
+
+func updateCacheInfo(indexes Indexes, info CacheInfo, add bool) (Indexes, error) {
+	path, err := repoRelPath(info.Path)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := indexes.Find(path); !ok && !add {
+		return nil, fmt.Errorf("%s: cannot add to the index - missing --add option?", path)
+	}
+	return indexes.Set(Index{Mode: info.Mode, Sha1: info.Sha1, Path: path}), nil
+}
+
+func updateIndexPath(filter *convertFilter, indexes Indexes, path string, param UpdateIndexParam) (Indexes, error) {
+	i, tracked := indexes.Find(path)
+	if param.ForceRemove {
+		return indexes.Remove(path), nil
+	}
+	if param.AssumeUnchanged || param.NoAssumeUnchanged || param.SkipWorktree || param.NoSkipWorktree {
+		if !tracked || indexes[i].Stage() != StageMerged {
+			return nil, fmt.Errorf("Unable to mark file %s", path)
+		}
+		switch {
+		case param.AssumeUnchanged:
+			indexes[i].SetAssumeUnchanged(true)
+		case param.NoAssumeUnchanged:
+			indexes[i].SetAssumeUnchanged(false)
+		}
+		switch {
+		case param.SkipWorktree:
+			indexes[i].SetSkipWorktree(true)
+		case param.NoSkipWorktree:
+			indexes[i].SetSkipWorktree(false)
+		}
+		return indexes, nil
+	}
+
+	st, err := filestat.Stat(filepath.FromSlash(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		if !param.Remove {
+			return nil, fmt.Errorf("%s: does not exist and --remove not passed", path)
+		}
+		return indexes.Remove(path), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !tracked && !param.Add {
+		return nil, fmt.Errorf("%s: cannot add to the index - missing --add option?", path)
+	}
+
+	var index Index
+	if tracked && indexes[i].Stage() == StageMerged &&
+		indexes[i].MatchStat(st) && !indexes[i].isRacy(indexModTime()) {
+		index = indexes[i]
+	} else {
+		sha1, err := hashFile(filter, path)
+		if err != nil {
+			return nil, err
+		}
+		index = newIndex(path, sha1, st)
+	}
+	if param.Chmod != "" && index.Mode&0170000 != 0100000 {
+		return nil, fmt.Errorf("cannot chmod %s '%s'", param.Chmod, path)
+	}
+	switch param.Chmod {
+	case "+x":
+		index.Mode = ModeExecutable
+	case "-x":
+		index.Mode = ModeRegular
+	}
+	return indexes.Set(index), nil
+}
+
+// refreshIndex update the stat information of entries whose working tree
+// file still has the recorded content. Entries needing an update are reported
+// as errors without stopping the refresh.
+func refreshIndex(filter *convertFilter, indexes Indexes) (Indexes, error) {
+	indexTime := indexModTime()
+	var errs []error
+	for i, index := range indexes {
+		if index.Stage() != StageMerged {
+			if i == 0 || indexes[i-1].Path != index.Path {
+				errs = append(errs, fmt.Errorf("%s: needs merge", index.Path))
+			}
+			continue
+		}
+		if index.AssumeUnchanged() || index.SkipWorktree() || index.Mode == ModeGitlink {
+			continue
+		}
+		st, err := filestat.Stat(filepath.FromSlash(index.Path))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: needs update", index.Path))
+			continue
+		}
+		if index.MatchStat(st) && !index.isRacy(indexTime) {
+			continue
+		}
+		data, err := readWorktreeFile(filter, index.Path)
+		if err != nil {
+			return nil, err
+		}
+		sha1, _, err := HashObject(HashParam{Data: data, ObjType: Blob})
+		if err != nil {
+			return nil, err
+		}
+		if sha1 != index.Sha1 {
+			errs = append(errs, fmt.Errorf("%s: needs update", index.Path))
+			continue
+		}
+		refreshed := newIndex(index.Path, sha1, st)
+		refreshed.Mode = index.Mode
+		refreshed.Flags = index.Flags
+		indexes[i] = refreshed
+	}
+	return indexes, collectErrors(errs)
+}
+
+// parseIndexInfo parse a --index-info line in one of the formats
+// "<mode> SP <sha1> TAB <path>", "<mode> SP <type> SP <sha1> TAB <path>" or
+// "<mode> SP <sha1> SP <stage> TAB <path>".
+func parseIndexInfo(line string) (CacheInfo, error) {
+	meta, path, ok := strings.Cut(line, "\t")
+	if !ok {
+		return CacheInfo{}, fmt.Errorf("malformed index info %s", line)
+	}
+	fields := strings.Fields(meta)
+	var modeStr, sha1 string
+	stage := StageMerged
+	switch len(fields) {
+	case 2:
+		modeStr, sha1 = fields[0], fields[1]
+	case 3:
+		if refs.IsSha1(fields[1]) {
+			n, err := strconv.Atoi(fields[2])
+			if err != nil || n < StageMerged || n > StageTheirs {
+				return CacheInfo{}, fmt.Errorf("malformed index info %s", line)
+			}
+			modeStr, sha1, stage = fields[0], fields[1], n
+		} else {
+			modeStr, sha1 = fields[0], fields[2]
+		}
+	default:
+		return CacheInfo{}, fmt.Errorf("malformed index info %s", line)
+	}
+	mode, err := parseMode(modeStr)
+	if err != nil {
+		return CacheInfo{}, err
+	}
+	if !refs.IsSha1(sha1) {
+		return CacheInfo{}, fmt.Errorf("malformed index info %s", line)
+	}
+	rel, err := repoRelPath(path)
+	if err != nil {
+		return CacheInfo{}, err
+	}
+	return CacheInfo{Mode: mode, Sha1: sha1, Stage: stage, Path: rel}, nil
+}
+
+func parseMode(s string) (uint16, error) {
+	mode, err := strconv.ParseUint(s, 8, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode '%s'", s)
+	}
+	return uint16(mode), nil
+}
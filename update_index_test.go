@@ -0,0 +1,158 @@
+package tinygit
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateIndexAddRemove(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+
+	if err := UpdateIndex(UpdateIndexParam{Paths: []string{"a.txt"}}); err == nil {
+		t.Fatal("expected error without --add")
+	}
+	if err := UpdateIndex(UpdateIndexParam{Paths: []string{"a.txt", "b.txt"}, Add: true}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	want := []string{"a.txt", "b.txt"}
+	if got := indexPaths(t); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected index paths %v, but got %v", want, got)
+	}
+
+	if err := os.Remove("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateIndex(UpdateIndexParam{Paths: []string{"a.txt"}}); err == nil {
+		t.Fatal("expected error without --remove")
+	}
+	if err := UpdateIndex(UpdateIndexParam{Paths: []string{"a.txt"}, Remove: true}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	if err := UpdateIndex(UpdateIndexParam{Paths: []string{"b.txt"}, ForceRemove: true}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	if got := indexPaths(t); len(got) != 0 {
+		t.Fatalf("expected empty index, but got %v", got)
+	}
+}
+
+func TestUpdateIndexCacheInfo(t *testing.T) {
+	setupRepo(t)
+	sha1, _, err := HashObject(HashParam{Data: []byte("x"), ObjType: Blob, WriteFile: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := ParseCacheInfo("100755," + sha1 + ",bin/run")
+	if err != nil {
+		t.Fatalf("parse cacheinfo: %+v", err)
+	}
+	if err := UpdateIndex(UpdateIndexParam{CacheInfo: []CacheInfo{info}}); err == nil {
+		t.Fatal("expected error without --add")
+	}
+	if err := UpdateIndex(UpdateIndexParam{CacheInfo: []CacheInfo{info}, Add: true}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+
+	input := "100644 " + sha1 + "\tdoc/a.txt\n" +
+		"100644 blob " + sha1 + "\tdoc/b.txt\n" +
+		"0 " + strings.Repeat("0", 40) + "\tbin/run\n"
+	if err := UpdateIndex(UpdateIndexParam{IndexInfo: strings.NewReader(input)}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	indexes, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	want := Indexes{
+		{Mode: ModeRegular, Sha1: sha1, Path: "doc/a.txt"},
+		{Mode: ModeRegular, Sha1: sha1, Path: "doc/b.txt"},
+	}
+	if !reflect.DeepEqual(indexes, want) {
+		t.Fatalf("expected indexes %+v, but got %+v", want, indexes)
+	}
+
+	if _, err := ParseCacheInfo("100644,nothex,a"); err == nil {
+		t.Fatal("expected error for invalid sha1")
+	}
+}
+
+func TestUpdateIndexChmodAndRefresh(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	param := UpdateIndexParam{Paths: []string{"a.txt", "b.txt"}, Add: true, Chmod: "+x"}
+	if err := UpdateIndex(param); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	indexes, err := ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	if indexes[0].Mode != ModeExecutable {
+		t.Fatalf("expected mode %o, but got %o", ModeExecutable, indexes[0].Mode)
+	}
+
+	// touching keeps the content, changing does not
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes("a.txt", future, future); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, map[string]string{"b.txt": "changed"})
+
+	err = UpdateIndex(UpdateIndexParam{Refresh: true})
+	var pathErrs PathErrors
+	if !errors.As(err, &pathErrs) || len(pathErrs) != 1 || pathErrs[0].Error() != "b.txt: needs update" {
+		t.Fatalf("expected b.txt to need update, but got %v", err)
+	}
+	indexes, err = ReadIndex()
+	if err != nil {
+		t.Fatalf("read index: %+v", err)
+	}
+	if indexes[0].MTimeS != future.Unix() {
+		t.Fatalf("expected refreshed mtime %d, but got %d", future.Unix(), indexes[0].MTimeS)
+	}
+	if indexes[0].Mode != ModeExecutable {
+		t.Fatalf("expected refresh to keep mode %o, but got %o", ModeExecutable, indexes[0].Mode)
+	}
+}
+
+func TestUpdateIndexInfoStages(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a"})
+	sha1, _, _ := HashObject(HashParam{Data: []byte("a"), ObjType: Blob})
+	input := "100644 " + sha1 + " 1\ta.txt\n" +
+		"100644 " + sha1 + " 2\ta.txt\n" +
+		"100644 " + sha1 + " 3\ta.txt\n"
+	if err := UpdateIndex(UpdateIndexParam{IndexInfo: strings.NewReader(input)}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	got, err := LsFiles(LsFilesParam{Unmerged: true})
+	if err != nil {
+		t.Fatalf("ls-files: %+v", err)
+	}
+	want := []string{
+		"100644 " + sha1 + " 1\ta.txt",
+		"100644 " + sha1 + " 2\ta.txt",
+		"100644 " + sha1 + " 3\ta.txt",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+
+	err = UpdateIndex(UpdateIndexParam{Refresh: true})
+	var pathErrs PathErrors
+	if !errors.As(err, &pathErrs) || len(pathErrs) != 1 || pathErrs[0].Error() != "a.txt: needs merge" {
+		t.Fatalf("expected a.txt to need merge, but got %v", err)
+	}
+
+	if err := UpdateIndex(UpdateIndexParam{Paths: []string{"a.txt"}}); err != nil {
+		t.Fatalf("update index: %+v", err)
+	}
+	if got, err := LsFiles(LsFilesParam{Unmerged: true}); err != nil || len(got) != 0 {
+		t.Fatalf("expected conflict resolved, but got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,296 @@
+package tinygit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/startdusk/tinygit/shared/filestat"
+)
+
+// walkWorktree call fn for every file of the working tree with its slash
+// separated repo relative path, the repository folder is skipped. A
+// directory holding another repository is passed to fn without looking into
+// it. Files and directories ignored by the rules are skipped too unless rules
+// is nil.
+func walkWorktree(rules *ignoreRules, fn func(path string, info fs.FileInfo) error) error {
+	return filepath.Walk(".", func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() == RepoRootPath && info.IsDir() {
+			return filepath.SkipDir
+		}
+		// a linked working tree has a .tinygit file instead of the folder
+		if info.Name() == RepoRootPath {
+			return nil
+		}
+		if path == "." {
+			return nil
+		}
+		rel := filepath.ToSlash(path)
+		if rules != nil {
+			ignored, err := rules.ignored(rel, info.IsDir())
+			if err != nil {
+				return err
+			}
+			if ignored && info.IsDir() {
+				return filepath.SkipDir
+			}
+			if ignored {
+				return nil
+			}
+		}
+		if info.IsDir() && !isNestedRepo(path) {
+			return nil
+		}
+		if err := fn(rel, info); err != nil || !info.IsDir() {
+			return err
+		}
+		return filepath.SkipDir
+	})
+}
+
+// worktreeChange describes how the working tree file of an index entry differs.
+type worktreeChange int
+
+const (
+	worktreeUnchanged worktreeChange = iota
+	worktreeModified
+	worktreeDeleted
+)
+
+// worktreeChange compare the working tree file with the entry, using the
+// recorded stat information to avoid hashing unchanged files. Entries marked
+// assume-unchanged or skip-worktree, or known unchanged by the file system
+// monitor, are never reported as changed.
+func (i Index) worktreeChange(filter *convertFilter, indexTime time.Time) (worktreeChange, error) {
+	if i.AssumeUnchanged() || i.SkipWorktree() || i.FSMonitorValid() {
+		return worktreeUnchanged, nil
+	}
+	path := filepath.FromSlash(i.Path)
+	// a submodule changes when another commit is checked out, a submodule
+	// which is not checked out is left alone
+	if i.Mode == ModeGitlink {
+		if !isNestedRepo(path) {
+			return worktreeUnchanged, nil
+		}
+		head, err := submoduleHead(path)
+		if err != nil {
+			return 0, err
+		}
+		if head != i.Sha1 {
+			return worktreeModified, nil
+		}
+		return worktreeUnchanged, nil
+	}
+	st, err := filestat.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return worktreeDeleted, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if i.MatchStat(st) && !i.isRacy(indexTime) {
+		return worktreeUnchanged, nil
+	}
+	// a zero size comes from entries added without looking at the file
+	if i.Size != 0 && i.Size != st.Size {
+		return worktreeModified, nil
+	}
+	data, err := readWorktreeFile(filter, i.Path)
+	if err != nil {
+		return 0, err
+	}
+	sha1, _, err := HashObject(HashParam{Data: data, ObjType: Blob})
+	if err != nil {
+		return 0, err
+	}
+	if sha1 != i.Sha1 || treeMode(st.Mode) != treeMode(i.Mode) {
+		return worktreeModified, nil
+	}
+	return worktreeUnchanged, nil
+}
+
+// refreshEntry return the entry with the stat information of its working
+// tree file when the file has the content and mode of the entry, the entry
+// unchanged otherwise.
+func refreshEntry(filter *convertFilter, index Index) (Index, error) {
+	if index.Mode == ModeGitlink {
+		return index, nil
+	}
+	path := filepath.FromSlash(index.Path)
+	st, err := filestat.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return index, err
+	}
+	if treeMode(st.Mode) != treeMode(index.Mode) {
+		return index, nil
+	}
+	data, err := readWorktreeFile(filter, index.Path)
+	if err != nil {
+		return index, err
+	}
+	sha1, _, err := HashObject(HashParam{Data: data, ObjType: Blob})
+	if err != nil || sha1 != index.Sha1 {
+		return index, err
+	}
+	refreshed := newIndex(index.Path, index.Sha1, st)
+	refreshed.Mode = index.Mode
+	refreshed.Flags = index.Flags
+	return refreshed, nil
+}
+
+// checkoutIndex write the blob of the entry into the working tree and return
+// the entry with the stat information of the written file.
+func checkoutIndex(filter *convertFilter, index Index) (Index, error) {
+	// the commit of a submodule is checked out by submodule update
+	if index.Mode == ModeGitlink {
+		return index, os.MkdirAll(filepath.FromSlash(index.Path), os.ModePerm)
+	}
+	obj, err := ReadObject(index.Sha1)
+	if err != nil {
+		return index, err
+	}
+	if obj.Type != Blob {
+		return index, fmt.Errorf("%s: expected blob %s, but got %s", index.Path, index.Sha1, obj.Type)
+	}
+	if err := writeWorktreeFile(filter, index.Path, obj.Data, index.Mode); err != nil {
+		return index, err
+	}
+	st, err := filestat.Stat(filepath.FromSlash(index.Path))
+	if err != nil {
+		return index, err
+	}
+	refreshed := newIndex(index.Path, index.Sha1, st)
+	refreshed.Mode = index.Mode
+	refreshed.Flags = index.Flags
+	return refreshed, nil
+}
+
+// readWorktreeFile return the content of the working tree file as it is
+// stored in a blob, converted by the attributes of the file, the target of a
+// symbolic link.
+func readWorktreeFile(filter *convertFilter, p string) ([]byte, error) {
+	path := filepath.FromSlash(p)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(filepath.ToSlash(target)), nil
+	}
+	// large files are streamed to the store instead of being read whole
+	if lfs, err := filter.builtinLFS(p); err != nil {
+		return nil, err
+	} else if lfs {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return lfsClean(file)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return filter.toBlob(p, data)
+}
+
+// writeWorktreeFile write the blob content to the working tree file with the
+// permissions of the mode, converted by the attributes of the file, creating
+// its directories. A symbolic link mode makes a link to the target held by
+// the data.
+func writeWorktreeFile(filter *convertFilter, p string, data []byte, mode uint16) error {
+	path := filepath.FromSlash(p)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	// a link is replaced instead of writing through it
+	if info, err := os.Lstat(path); err == nil && (mode == ModeSymlink || info.Mode()&fs.ModeSymlink != 0) {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	if mode == ModeSymlink {
+		return os.Symlink(filepath.FromSlash(string(data)), path)
+	}
+	perm := fs.FileMode(0644)
+	if mode&0111 != 0 {
+		perm = 0755
+	}
+	// large files are streamed from the store instead of being read whole
+	if lfs, err := filter.builtinLFS(p); err != nil {
+		return err
+	} else if lfs {
+		file, pointer, err := lfsOpen(data)
+		if err != nil {
+			return err
+		}
+		if file != nil {
+			defer file.Close()
+			if err := writeLFSFile(path, file, pointer, perm); err != nil {
+				return err
+			}
+			return os.Chmod(path, perm)
+		}
+	}
+	data, err := filter.toWorktree(p, data)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return err
+	}
+	// an existing file keeps its permissions on write
+	return os.Chmod(path, perm)
+}
+
+// writeLFSFile copy the large file content of the pointer from the store file
+// to the working tree file, which is removed when the content does not match
+// the pointer.
+func writeLFSFile(path string, r io.Reader, p lfsPointer, perm fs.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	err = lfsCopy(file, r, p)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// removeWorktreeFile remove the file of the repo relative path and its parent
+// directories left empty.
+func removeWorktreeFile(path string) error {
+	// a checked out submodule is kept
+	if isNestedRepo(filepath.FromSlash(path)) {
+		return nil
+	}
+	if err := os.Remove(filepath.FromSlash(path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for dir := filepath.Dir(filepath.FromSlash(path)); dir != "."; dir = filepath.Dir(dir) {
+		// stop at the first directory which is not empty
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+	return nil
+}
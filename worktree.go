@@ -0,0 +1,479 @@
+package tinygit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/startdusk/tinygit/shared/refs"
+)
+
+// A linked working tree has a .tinygit file holding "gitdir: <folder>" in
+// place of the repository folder. The folder is .tinygit/worktrees/<name> of
+// the main working tree and holds the HEAD and the index of the working tree,
+// a commondir file naming the shared repository folder relative to it and a
+// gitdir file naming the .tinygit file of the working tree.
+const gitdirPrefix = "gitdir: "
+
+const (
+	worktreesFolder   = "worktrees"
+	commondirFileName = "commondir"
+	gitdirFileName    = "gitdir"
+)
+
+// gitDir return the repository folder of the working tree, holding its HEAD
+// and index.
+func gitDir() string {
+	return gitDirOf(".")
+}
+
+// gitDirOf return the repository folder of the working tree at root. A
+// relative folder named by a .tinygit file is relative to root.
+func gitDirOf(root string) string {
+	file := filepath.Join(root, RepoRootPath)
+	info, err := os.Lstat(file)
+	if err != nil || info.IsDir() {
+		return file
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return file
+	}
+	dir := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(dir, gitdirPrefix) {
+		return file
+	}
+	dir = filepath.FromSlash(strings.TrimPrefix(dir, gitdirPrefix))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	return dir
+}
+
+// commonDir return the repository folder shared by the working trees,
+// holding the objects, the refs and the config.
+func commonDir() string {
+	return commonDirOf(gitDir())
+}
+
+// commonDirOf return the shared repository folder of the repository folder.
+func commonDirOf(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, commondirFileName))
+	if err != nil {
+		return dir
+	}
+	common := filepath.FromSlash(strings.TrimSpace(string(data)))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(dir, common)
+	}
+	return common
+}
+
+// Worktree is a working tree of the repository.
+type Worktree struct {
+	// Path is the absolute path of the working tree, empty when the gitdir
+	// file of a linked working tree is missing.
+	Path string
+	// Name is the folder of a linked working tree under worktrees.
+	Name string
+	// Head is the commit sha1 checked out, empty on an unborn branch.
+	Head string
+	// Branch is the branch checked out, empty when HEAD is detached.
+	Branch string
+	// Main reports the working tree holding the repository folder.
+	Main bool
+	// Prunable reports a linked working tree whose folder is gone.
+	Prunable bool
+	// gitDir is the repository folder of the working tree.
+	gitDir string
+}
+
+// String format the working tree as "<path> <short sha1> [<branch>]", or
+// "(detached HEAD)" in place of the branch. A working tree without a path is
+// named by its folder, "worktrees/<name>".
+func (w Worktree) String() string {
+	head := "0000000"
+	if w.Head != "" {
+		head = w.Head[:7]
+	}
+	path := w.Path
+	if path == "" {
+		path = worktreesFolder + "/" + w.Name
+	}
+	s := fmt.Sprintf("%s %s (detached HEAD)", path, head)
+	if w.Branch != "" {
+		s = fmt.Sprintf("%s %s [%s]", path, head, w.Branch)
+	}
+	if w.Prunable {
+		s += " prunable"
+	}
+	return s
+}
+
+// WorktreeList return the working trees of the repository, the main one
+// first then the linked ones sorted by name.
+func WorktreeList() ([]Worktree, error) {
+	common, err := filepath.Abs(commonDir())
+	if err != nil {
+		return nil, err
+	}
+	main := Worktree{Path: filepath.Dir(common), Main: true, gitDir: common}
+	main.Branch, main.Head, err = readHeadOf(refs.Store{GitDir: common, CommonDir: common})
+	if err != nil {
+		return nil, err
+	}
+	worktrees := []Worktree{main}
+
+	entries, err := os.ReadDir(filepath.Join(common, worktreesFolder))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(common, worktreesFolder, entry.Name())
+		w := Worktree{Name: entry.Name(), gitDir: dir}
+		if w.Branch, w.Head, err = readHeadOf(refs.Store{GitDir: dir, CommonDir: common}); err != nil {
+			return nil, err
+		}
+		gitdir, err := readGitdirFile(dir)
+		if err != nil {
+			return nil, err
+		}
+		// without its gitdir file the working tree is only known by its folder
+		if gitdir != "" {
+			w.Path = filepath.Dir(gitdir)
+		}
+		if _, err := os.Stat(gitdir); err != nil {
+			w.Prunable = true
+		}
+		worktrees = append(worktrees, w)
+	}
+	return worktrees, nil
+}
+
+// readGitdirFile return the .tinygit file of the linked working tree, empty
+// when the gitdir file is missing.
+func readGitdirFile(dir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, gitdirFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return filepath.FromSlash(strings.TrimSpace(string(data))), nil
+}
+
+// checkedOutAt return the path of the working tree the branch is checked
+// out in, empty when there is none.
+func checkedOutAt(branch string) (string, error) {
+	worktrees, err := WorktreeList()
+	if err != nil {
+		return "", err
+	}
+	for _, w := range worktrees {
+		if w.Branch == branch && !w.Prunable {
+			return w.Path, nil
+		}
+	}
+	return "", nil
+}
+
+// findWorktree return the working tree at the path, or the linked working
+// tree whose folder is named by it.
+func findWorktree(p string) (Worktree, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return Worktree{}, err
+	}
+	worktrees, err := WorktreeList()
+	if err != nil {
+		return Worktree{}, err
+	}
+	for _, w := range worktrees {
+		if w.Path == abs {
+			return w, nil
+		}
+	}
+	for _, w := range worktrees {
+		if !w.Main && w.Name == filepath.ToSlash(p) {
+			return w, nil
+		}
+	}
+	return Worktree{}, fmt.Errorf("'%s' is not a working tree", p)
+}
+
+// WorktreeAddParam worktree add command params.
+type WorktreeAddParam struct {
+	Path string
+	// Commitish is the branch or commit to check out, HEAD when empty.
+	Commitish string
+	// NewBranch creates a branch starting at Commitish and checks it out.
+	// Without NewBranch, Detach or a Commitish naming a branch, a branch
+	// named after the last element of Path is checked out, created at HEAD
+	// when it does not exist.
+	NewBranch string
+	// Detach detaches HEAD at the commit even when Commitish is a branch.
+	Detach bool
+	// Force checks out a branch already checked out in another working tree.
+	Force bool
+}
+
+// WorktreeAdd create a working tree at the path sharing the objects and the
+// refs of the repository, and return a message describing its HEAD.
+func WorktreeAdd(param WorktreeAddParam) (message string, err error) {
+	if param.Path == "" {
+		return "", errors.New("no path specified")
+	}
+	abs, err := filepath.Abs(param.Path)
+	if err != nil {
+		return "", err
+	}
+	if entries, err := os.ReadDir(abs); err == nil && len(entries) > 0 {
+		return "", fmt.Errorf("'%s' already exists", param.Path)
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	// the branch to check out and the commit of HEAD
+	commitish := param.Commitish
+	if commitish == "" {
+		commitish = "HEAD"
+	}
+	branch, newBranch := param.NewBranch, param.NewBranch != ""
+	if !newBranch && !param.Detach {
+		name := param.Commitish
+		if name == "" {
+			name = filepath.Base(abs)
+		}
+		sha1, err := readRef("refs/heads/" + name)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case sha1 != "":
+			branch, commitish = name, "refs/heads/"+name
+		case param.Commitish == "":
+			branch, newBranch = name, true
+		}
+	}
+	if newBranch {
+		if err := checkBranchName(branch); err != nil {
+			return "", err
+		}
+		if sha1, err := readRef("refs/heads/" + branch); err != nil {
+			return "", err
+		} else if sha1 != "" {
+			return "", fmt.Errorf("a branch named '%s' already exists", branch)
+		}
+	} else if branch != "" && !param.Force {
+		at, err := checkedOutAt(branch)
+		if err != nil {
+			return "", err
+		}
+		if at != "" {
+			return "", fmt.Errorf("'%s' is already checked out at '%s'", branch, at)
+		}
+	}
+	sha1, err := resolveRevision(commitish)
+	if err != nil {
+		return "", fmt.Errorf("invalid reference: %s", commitish)
+	}
+	commit, err := readCommit(sha1)
+	if err != nil {
+		return "", err
+	}
+	target, err := flattenTree(commit.Tree)
+	if err != nil {
+		return "", err
+	}
+
+	// the repository folder of the working tree is named after the path,
+	// with a number added when the name is taken
+	common, err := filepath.Abs(commonDir())
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(abs)
+	dir := filepath.Join(common, worktreesFolder, name)
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(dir); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		dir = filepath.Join(common, worktreesFolder, name+strconv.Itoa(n))
+	}
+	_, statErr := os.Stat(abs)
+	created := errors.Is(statErr, fs.ErrNotExist)
+	defer func() {
+		// a failed add leaves nothing behind
+		if err != nil {
+			os.RemoveAll(dir)
+			if created {
+				os.RemoveAll(abs)
+			}
+		}
+	}()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(abs, os.ModePerm); err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(dir, common)
+	if err != nil {
+		return "", err
+	}
+	gitdir := filepath.Join(abs, RepoRootPath)
+	for file, content := range map[string]string{
+		filepath.Join(dir, commondirFileName): filepath.ToSlash(rel),
+		filepath.Join(dir, gitdirFileName):    filepath.ToSlash(gitdir),
+		gitdir:                                gitdirPrefix + filepath.ToSlash(dir),
+	} {
+		if err := os.WriteFile(file, []byte(content+"\n"), 0644); err != nil {
+			return "", err
+		}
+	}
+	store := refs.Store{GitDir: dir, CommonDir: common}
+	if branch != "" {
+		err = store.WriteSymbolic("HEAD", "refs/heads/"+branch)
+	} else {
+		err = store.Update(refs.UpdateParam{Name: "HEAD", New: sha1, NoDeref: true})
+	}
+	if err != nil {
+		return "", err
+	}
+	// the filter follows the settings of the new working tree
+	err = inWorktree(abs, func() error {
+		lock, err := lockIndex()
+		if err != nil {
+			return err
+		}
+		defer lock.abort()
+		filter, err := newConvertFilter()
+		if err != nil {
+			return err
+		}
+		return checkoutTree(lock, filter, nil, target, false)
+	})
+	if err != nil {
+		return "", err
+	}
+	if newBranch {
+		if err := updateRef("refs/heads/"+branch, sha1, ""); err != nil {
+			return "", err
+		}
+	}
+
+	switch {
+	case newBranch:
+		message = fmt.Sprintf("Preparing worktree (new branch '%s')", branch)
+	case branch != "":
+		message = fmt.Sprintf("Preparing worktree (checking out '%s')", branch)
+	default:
+		message = fmt.Sprintf("Preparing worktree (detached HEAD %s)", sha1[:7])
+	}
+	return message + fmt.Sprintf("\nHEAD is now at %s %s", sha1[:7], commit.subject()), nil
+}
+
+// inWorktree run fn with the working tree at the path as current directory.
+func inWorktree(p string, fn func() error) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(p); err != nil {
+		return err
+	}
+	defer os.Chdir(wd)
+	return fn()
+}
+
+// WorktreeRemoveParam worktree remove command params.
+type WorktreeRemoveParam struct {
+	Worktree string
+	// Force removes a working tree with modified or untracked files.
+	Force bool
+}
+
+// WorktreeRemove remove the linked working tree and its repository folder.
+func WorktreeRemove(param WorktreeRemoveParam) error {
+	w, err := findWorktree(param.Worktree)
+	if err != nil {
+		return err
+	}
+	if w.Main {
+		return fmt.Errorf("'%s' is a main working tree", param.Worktree)
+	}
+	if !w.Prunable {
+		if !param.Force {
+			var report StatusReport
+			if err := inWorktree(w.Path, func() (err error) {
+				report, err = Status(StatusParam{})
+				return err
+			}); err != nil {
+				return err
+			}
+			if len(report.Entries) > 0 {
+				return fmt.Errorf("'%s' contains modified or untracked files, use --force to delete it", param.Worktree)
+			}
+		}
+		if err := os.RemoveAll(w.Path); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(w.gitDir)
+}
+
+// WorktreePruneParam worktree prune command params.
+type WorktreePruneParam struct {
+	// DryRun only reports the working trees which would be pruned.
+	DryRun bool
+}
+
+// WorktreePrune remove the repository folders of the linked working trees
+// whose folder is gone, and return a message for each.
+func WorktreePrune(param WorktreePruneParam) ([]string, error) {
+	dir := filepath.Join(commonDir(), worktreesFolder)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var messages []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		gitdir, err := readGitdirFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		reason := "gitdir file does not exist"
+		if gitdir != "" {
+			if _, err := os.Stat(gitdir); err == nil {
+				continue
+			}
+			reason = "gitdir file points to non-existent location"
+		}
+		messages = append(messages, fmt.Sprintf("Removing %s/%s: %s", worktreesFolder, entry.Name(), reason))
+		if param.DryRun {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return nil, err
+		}
+	}
+	if !param.DryRun {
+		// the folder is only kept while it holds working trees
+		os.Remove(dir)
+	}
+	return messages, nil
+}
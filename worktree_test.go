@@ -0,0 +1,143 @@
+package tinygit
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWorktree(t *testing.T) {
+	setupRepo(t)
+	writeFiles(t, map[string]string{"a.txt": "a", "dir/b.txt": "b"})
+	if err := Add(AddParam{All: true}); err != nil {
+		t.Fatalf("add: %+v", err)
+	}
+	first := commitIndex(t, "first")
+
+	wt := filepath.Join(t.TempDir(), "wt")
+	message, err := WorktreeAdd(WorktreeAddParam{Path: wt})
+	if err != nil {
+		t.Fatalf("worktree add: %+v", err)
+	}
+	if want := "Preparing worktree (new branch 'wt')\nHEAD is now at " + first[:7] + " first"; message != want {
+		t.Fatalf("expected %q, but got %q", want, message)
+	}
+
+	// the linked working tree has its own HEAD and index but shares the refs
+	var second string
+	err = inWorktree(wt, func() error {
+		assertFiles(t, map[string]string{"a.txt": "a", "dir/b.txt": "b"})
+		if branch, head, _ := readHead(); branch != "wt" || head != first {
+			t.Fatalf("expected HEAD wt at %s, but got %s at %s", first, branch, head)
+		}
+		report, err := Status(StatusParam{})
+		if err != nil || len(report.Entries) != 0 {
+			t.Fatalf("expected a clean working tree, but got %+v, %v", report.Entries, err)
+		}
+		writeFiles(t, map[string]string{"a.txt": "a2", "c.txt": "c"})
+		if err := Add(AddParam{All: true}); err != nil {
+			t.Fatalf("add: %+v", err)
+		}
+		second = commitIndex(t, "second")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha1, _ := readRef("refs/heads/wt"); sha1 != second {
+		t.Fatalf("expected wt at %s, but got %s", second, sha1)
+	}
+	if branch, head, _ := readHead(); branch != "master" || head != first {
+		t.Fatalf("expected HEAD master at %s, but got %s at %s", first, branch, head)
+	}
+	if got := mustLsFiles(t, LsFilesParam{}); !reflect.DeepEqual(got, []string{"a.txt", "dir/b.txt"}) {
+		t.Fatalf("expected the main index untouched, but got %q", got)
+	}
+
+	worktrees, err := WorktreeList()
+	if err != nil {
+		t.Fatalf("worktree list: %+v", err)
+	}
+	if len(worktrees) != 2 || !worktrees[0].Main || worktrees[0].Branch != "master" ||
+		worktrees[1].Path != wt || worktrees[1].Branch != "wt" || worktrees[1].Head != second {
+		t.Fatalf("unexpected worktrees %+v", worktrees)
+	}
+	if want := wt + " " + second[:7] + " [wt]"; worktrees[1].String() != want {
+		t.Fatalf("expected %q, but got %q", want, worktrees[1].String())
+	}
+
+	// a branch is checked out in a single working tree
+	if _, err := Checkout(CheckoutParam{Target: "wt"}); err == nil || !strings.Contains(err.Error(), "is already checked out at") {
+		t.Fatalf("expected checkout of wt to fail, but got %v", err)
+	}
+	detached := filepath.Join(t.TempDir(), "detached")
+	if _, err := WorktreeAdd(WorktreeAddParam{Path: detached, Commitish: "master"}); err == nil {
+		t.Fatal("expected add of master to fail")
+	}
+	if _, err := WorktreeAdd(WorktreeAddParam{Path: detached, Commitish: "master", Detach: true}); err != nil {
+		t.Fatalf("worktree add: %+v", err)
+	}
+	if worktrees, _ := WorktreeList(); len(worktrees) != 3 || worktrees[1].Branch != "" || worktrees[1].Head != first {
+		t.Fatalf("expected a detached working tree, but got %+v", worktrees)
+	}
+
+	// modified or untracked files are only removed by force
+	writeFiles(t, map[string]string{filepath.Join(wt, "new.txt"): "new"})
+	if err := WorktreeRemove(WorktreeRemoveParam{Worktree: wt}); err == nil {
+		t.Fatal("expected remove of a dirty working tree to fail")
+	}
+	if err := WorktreeRemove(WorktreeRemoveParam{Worktree: wt, Force: true}); err != nil {
+		t.Fatalf("worktree remove: %+v", err)
+	}
+	if _, err := os.Stat(wt); !os.IsNotExist(err) {
+		t.Fatalf("expected %s removed, but got %v", wt, err)
+	}
+	if err := WorktreeRemove(WorktreeRemoveParam{Worktree: "."}); err == nil {
+		t.Fatal("expected remove of the main working tree to fail")
+	}
+
+	// the repository folder of a deleted working tree is pruned
+	if err := os.RemoveAll(detached); err != nil {
+		t.Fatal(err)
+	}
+	if worktrees, _ := WorktreeList(); len(worktrees) != 2 || !worktrees[1].Prunable {
+		t.Fatalf("expected a prunable working tree, but got %+v", worktrees)
+	}
+	want := []string{"Removing worktrees/detached: gitdir file points to non-existent location"}
+	if messages, err := WorktreePrune(WorktreePruneParam{DryRun: true}); err != nil || !reflect.DeepEqual(messages, want) {
+		t.Fatalf("expected %q, but got %q, %v", want, messages, err)
+	}
+	if messages, err := WorktreePrune(WorktreePruneParam{}); err != nil || !reflect.DeepEqual(messages, want) {
+		t.Fatalf("expected %q, but got %q, %v", want, messages, err)
+	}
+	if worktrees, _ := WorktreeList(); len(worktrees) != 1 {
+		t.Fatalf("expected only the main working tree, but got %+v", worktrees)
+	}
+
+	// without its gitdir file a working tree is named by its folder
+	lost := filepath.Join(t.TempDir(), "lost")
+	if _, err := WorktreeAdd(WorktreeAddParam{Path: lost, Commitish: "master", Detach: true}); err != nil {
+		t.Fatalf("worktree add: %+v", err)
+	}
+	if err := os.Remove(filepath.Join(commonDir(), "worktrees", "lost", "gitdir")); err != nil {
+		t.Fatal(err)
+	}
+	worktrees, err = WorktreeList()
+	if err != nil {
+		t.Fatalf("worktree list: %+v", err)
+	}
+	if len(worktrees) != 2 || worktrees[1].Path != "" || !worktrees[1].Prunable {
+		t.Fatalf("expected a prunable working tree without a path, but got %+v", worktrees)
+	}
+	if want := "worktrees/lost " + first[:7] + " (detached HEAD) prunable"; worktrees[1].String() != want {
+		t.Fatalf("expected %q, but got %q", want, worktrees[1].String())
+	}
+	if err := WorktreeRemove(WorktreeRemoveParam{Worktree: "lost"}); err != nil {
+		t.Fatalf("worktree remove: %+v", err)
+	}
+	if worktrees, _ := WorktreeList(); len(worktrees) != 1 {
+		t.Fatalf("expected only the main working tree, but got %+v", worktrees)
+	}
+}